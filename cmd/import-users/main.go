@@ -0,0 +1,84 @@
+// Command import-users creates users in bulk from a JSONL or CSV file, the
+// same way as POST /applications/{applicationID}/users/import.
+//
+//	go run ./cmd/import-users -app <applicationID> -file users.csv [-dry-run]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/userimport"
+)
+
+func main() {
+	applicationID := flag.String("app", "", "ID of the application to import the users into")
+	file := flag.String("file", "", "file to import, - for standard input")
+	format := flag.String("format", "", "jsonl or csv, by default from the file extension")
+	dryRun := flag.Bool("dry-run", false, "check every row without creating any user")
+	flag.Parse()
+
+	if *applicationID == "" || *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *format == "" {
+		*format = userimport.FormatJSONL
+		if filepath.Ext(*file) == ".csv" {
+			*format = userimport.FormatCSV
+		}
+	}
+	inputFormat, err := userimport.ParseFormat(*format)
+	if err != nil {
+		fail(err)
+	}
+
+	var input io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	ctx := context.Background()
+	db := database.New()
+	defer db.Close()
+
+	app, err := db.GetApplicationByID(ctx, *applicationID)
+	if err != nil {
+		fail(err)
+	}
+
+	report, err := userimport.Import(ctx, db, input, userimport.Options{
+		ApplicationID:  app.ID,
+		Format:         inputFormat,
+		DryRun:         *dryRun,
+		PasswordPolicy: app.PasswordPolicy,
+	})
+	if err != nil {
+		fail(err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+
+	if report.Failed > 0 || report.Error != "" {
+		os.Exit(1)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "import-users:", err)
+	os.Exit(1)
+}
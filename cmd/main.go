@@ -3,13 +3,20 @@ package main
 import (
 	"fmt"
 
+	"github.com/wbrijesh/identity/internal/auth"
 	"github.com/wbrijesh/identity/internal/database"
 	"github.com/wbrijesh/identity/internal/server"
 )
 
 func init() {
+	if err := auth.LoadKeys(); err != nil {
+		panic(fmt.Sprintf("cannot load signing keys: %s", err))
+	}
+
 	dbService := database.New()
-	dbService.RunMigrations()
+	if err := dbService.RunMigrations(); err != nil {
+		panic(fmt.Sprintf("cannot run database migrations: %s", err))
+	}
 }
 
 func main() {
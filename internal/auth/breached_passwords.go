@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The breached password list is read once from BREACHED_PASSWORDS_FILE, one
+// hex SHA-1 prefix or full hash per line. Lines in the "HASH:count" format of
+// the Have I Been Pwned downloads are accepted, the count is ignored.
+var (
+	breachedOnce     sync.Once
+	breachedPrefixes map[int]map[string]struct{}
+)
+
+// BreachedPasswordsLoaded reports whether a breached password list is
+// configured and could be read.
+func BreachedPasswordsLoaded() bool {
+	loadBreachedPasswordsOnce()
+	return breachedPrefixes != nil
+}
+
+// IsBreachedPassword reports whether the SHA-1 of the password starts with
+// one of the prefixes in the breached password list.
+func IsBreachedPassword(password string) bool {
+	loadBreachedPasswordsOnce()
+	if breachedPrefixes == nil {
+		return false
+	}
+
+	digest := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(digest[:]))
+	for length, prefixes := range breachedPrefixes {
+		if _, ok := prefixes[hash[:length]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func loadBreachedPasswordsOnce() {
+	breachedOnce.Do(func() {
+		path := os.Getenv("BREACHED_PASSWORDS_FILE")
+		if path == "" {
+			return
+		}
+		prefixes, err := loadBreachedPasswords(path)
+		if err != nil {
+			log.Printf("breached password list not loaded: %v", err)
+			return
+		}
+		breachedPrefixes = prefixes
+	})
+}
+
+// loadBreachedPasswords reads the prefixes grouped by length, so a lookup
+// is one map access per distinct length.
+func loadBreachedPasswords(path string) (map[int]map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer file.Close()
+
+	prefixes := make(map[int]map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		prefix, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if prefix == "" || strings.HasPrefix(prefix, "#") {
+			continue
+		}
+		if len(prefix) > sha1.Size*2 {
+			return nil, fmt.Errorf("line %d: prefix is longer than a SHA-1 hash", line)
+		}
+		if _, err := hex.DecodeString(prefix + strings.Repeat("0", len(prefix)%2)); err != nil {
+			return nil, fmt.Errorf("line %d: prefix is not hexadecimal", line)
+		}
+
+		prefix = strings.ToUpper(prefix)
+		if prefixes[len(prefix)] == nil {
+			prefixes[len(prefix)] = make(map[string]struct{})
+		}
+		prefixes[len(prefix)][prefix] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+
+	return prefixes, nil
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cborMap is a CBOR map whose entries are encoded in order.
+type cborMap [][2]interface{}
+
+// encodeCBOR encodes the values the WebAuthn tests need, in the shortest
+// form like authenticators do.
+func encodeCBOR(value interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+		default:
+			return []byte{major<<5 | 26, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+		}
+	}
+
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case []interface{}:
+		out := head(4, uint64(len(v)))
+		for _, item := range v {
+			out = append(out, encodeCBOR(item)...)
+		}
+		return out
+	case cborMap:
+		out := head(5, uint64(len(v)))
+		for _, entry := range v {
+			out = append(out, encodeCBOR(entry[0])...)
+			out = append(out, encodeCBOR(entry[1])...)
+		}
+		return out
+	}
+	panic("encodeCBOR: unsupported type")
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Examples from RFC 8949 Appendix A, limited to the types the decoder
+// supports
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		hex  string
+		want interface{}
+	}{
+		{"00", int64(0)},
+		{"01", int64(1)},
+		{"0a", int64(10)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1864", int64(100)},
+		{"1903e8", int64(1000)},
+		{"1a000f4240", int64(1000000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"20", int64(-1)},
+		{"29", int64(-10)},
+		{"3863", int64(-100)},
+		{"3903e7", int64(-1000)},
+		{"3b7fffffffffffffff", int64(-1 << 63)},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"f7", nil},
+		{"40", []byte(nil)}, // empty byte strings decode to nil
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"60", ""},
+		{"6161", "a"},
+		{"6449455446", "IETF"},
+		{"62225c", "\"\\"},
+		{"62c3bc", "ü"},
+		{"63e6b0b4", "水"},
+		{"80", []interface{}{}},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"8301820203820405", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+		{"a0", map[interface{}]interface{}{}},
+		{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{"a26161016162820203", map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{"826161a161626163", []interface{}{"a", map[interface{}]interface{}{"b": "c"}}},
+		{"c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z"},
+		{"d74401020304", []byte{1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hex, func(t *testing.T) {
+			got, rest, err := decodeCBOR(mustHex(t, tt.hex))
+			if err != nil {
+				t.Fatalf("decodeCBOR: %v", err)
+			}
+			if len(rest) != 0 {
+				t.Fatalf("decodeCBOR left %x", rest)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("decodeCBOR = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORReturnsRest(t *testing.T) {
+	got, rest, err := decodeCBOR(mustHex(t, "0102ff"))
+	if err != nil {
+		t.Fatalf("decodeCBOR: %v", err)
+	}
+	if got != int64(1) || !bytes.Equal(rest, []byte{0x02, 0xff}) {
+		t.Fatalf("decodeCBOR = %v, %x", got, rest)
+	}
+}
+
+func TestDecodeCBORErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+		err  string
+	}{
+		{"empty", "", "unexpected end"},
+		{"truncated argument", "19 03", "unexpected end"},
+		{"truncated 64-bit argument", "1b000000e8d4a510", "unexpected end"},
+		{"truncated byte string", "44010203", "unexpected end"},
+		{"truncated text string", "64494554", "unexpected end"},
+		{"truncated array", "830102", "unexpected end"},
+		{"truncated map", "a2010203", "unexpected end"},
+		{"map without value", "a101", "unexpected end"},
+		{"huge byte string length", "5bffffffffffffffff", "unexpected end"},
+		{"indefinite byte string", "5f42010243030405ff", "indefinite"},
+		{"indefinite text string", "7f657374726561646d696e67ff", "indefinite"},
+		{"indefinite array", "9f018202039f0405ffff", "indefinite"},
+		{"indefinite map", "bf61610161629f0203ffff", "indefinite"},
+		{"reserved additional info", "1c", "indefinite"},
+		{"unsigned overflows int64", "1b8000000000000000", "overflows"},
+		{"negative overflows int64", "3b8000000000000000", "overflows"},
+		{"half float", "f93c00", "simple value"},
+		{"double float", "fb3ff199999999999a", "simple value"},
+		{"undefined simple value", "f0", "simple value"},
+		{"array too long", "9a00010000", "too long"},
+		{"map too long", "ba00010000", "too long"},
+		{"byte string map key", "a1420102 01", "map key"},
+		{"array map key", "a1800102", "map key"},
+		{"nesting too deep", strings.Repeat("81", cborMaxDepth+1) + "00", "too deep"},
+		{"nested tags too deep", strings.Repeat("c0", cborMaxDepth+1) + "00", "too deep"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decodeCBOR(mustHex(t, strings.ReplaceAll(tt.hex, " ", "")))
+			if err == nil {
+				t.Fatal("decodeCBOR succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("decodeCBOR error = %q, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORMaxDepth(t *testing.T) {
+	data := mustHex(t, strings.Repeat("81", cborMaxDepth)+"00")
+	if _, _, err := decodeCBOR(data); err != nil {
+		t.Fatalf("decodeCBOR at the depth limit: %v", err)
+	}
+}
+
+func TestEncodeCBORRoundTrip(t *testing.T) {
+	data := encodeCBOR(cborMap{{1, 2}, {3, -7}, {-1, []byte{0xaa}}, {"fmt", "none"}})
+	got, rest, err := decodeCBOR(data)
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("decodeCBOR = %v, %x, %v", got, rest, err)
+	}
+	want := map[interface{}]interface{}{
+		int64(1): int64(2), int64(3): int64(-7), int64(-1): []byte{0xaa}, "fmt": "none",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("decodeCBOR = %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms (RFC 9053) accepted for passkeys, matching the algorithms
+// of the signing key rings
+const (
+	COSEAlgorithmES256 = -7
+	COSEAlgorithmEdDSA = -8
+	COSEAlgorithmRS256 = -257
+)
+
+// COSEAlgorithms lists the accepted algorithms in order of preference.
+var COSEAlgorithms = []int{COSEAlgorithmES256, COSEAlgorithmEdDSA, COSEAlgorithmRS256}
+
+// COSE key parameters and values
+const (
+	coseKeyType      = 1
+	coseKeyAlgorithm = 3
+	coseKeyCurve     = -1
+	coseKeyX         = -2
+	coseKeyY         = -3
+	coseKeyRSAN      = -1
+	coseKeyRSAE      = -2
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// parseCOSEKey decodes a COSE_Key into a public key and its algorithm.
+func parseCOSEKey(data []byte) (crypto.PublicKey, int, error) {
+	item, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(rest) != 0 {
+		return nil, 0, errors.New("trailing data after COSE key")
+	}
+	key, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errors.New("COSE key is not a map")
+	}
+
+	keyType, _ := key[int64(coseKeyType)].(int64)
+	algorithm, _ := key[int64(coseKeyAlgorithm)].(int64)
+
+	switch {
+	case keyType == coseKeyTypeEC2 && algorithm == COSEAlgorithmES256:
+		curve, _ := key[int64(coseKeyCurve)].(int64)
+		x, _ := key[int64(coseKeyX)].([]byte)
+		y, _ := key[int64(coseKeyY)].([]byte)
+		if curve != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("invalid P-256 COSE key")
+		}
+		publicKey := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+			return nil, 0, errors.New("COSE key point is not on the curve")
+		}
+		return publicKey, COSEAlgorithmES256, nil
+
+	case keyType == coseKeyTypeOKP && algorithm == COSEAlgorithmEdDSA:
+		curve, _ := key[int64(coseKeyCurve)].(int64)
+		x, _ := key[int64(coseKeyX)].([]byte)
+		if curve != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("invalid Ed25519 COSE key")
+		}
+		return ed25519.PublicKey(x), COSEAlgorithmEdDSA, nil
+
+	case keyType == coseKeyTypeRSA && algorithm == COSEAlgorithmRS256:
+		n, _ := key[int64(coseKeyRSAN)].([]byte)
+		e, _ := key[int64(coseKeyRSAE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("invalid RSA COSE key")
+		}
+		exponent := new(big.Int).SetBytes(e)
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, COSEAlgorithmRS256, nil
+	}
+
+	return nil, 0, fmt.Errorf("unsupported COSE key type %d with algorithm %d", keyType, algorithm)
+}
+
+// verifyCOSESignature checks a WebAuthn assertion signature over data with
+// a COSE public key.
+func verifyCOSESignature(coseKey []byte, data, signature []byte) error {
+	publicKey, algorithm, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return err
+	}
+
+	switch algorithm {
+	case COSEAlgorithmES256:
+		digest := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(publicKey.(*ecdsa.PublicKey), digest[:], signature) {
+			return errors.New("invalid signature")
+		}
+	case COSEAlgorithmEdDSA:
+		if !ed25519.Verify(publicKey.(ed25519.PublicKey), data, signature) {
+			return errors.New("invalid signature")
+		}
+	case COSEAlgorithmRS256:
+		digest := sha256.Sum256(data)
+		if err := rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid signature")
+		}
+	}
+	return nil
+}
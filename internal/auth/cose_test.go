@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+// COSE key of a credential registered on webauthn.io, from the go-webauthn
+// test suite
+const webauthnIOCOSEKey = "pQMmIAEhWCAoCF-x0dwEhzQo-ABxHIAgr_5WL6cJceREc81oIwFn7iJYIHEHx8ZhBIE42L26-rSC_3l0ZaWEmsHAKyP9rgslApUdAQI"
+
+func ec2COSEKey(publicKey *ecdsa.PublicKey) []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	publicKey.X.FillBytes(x)
+	publicKey.Y.FillBytes(y)
+	return encodeCBOR(cborMap{
+		{coseKeyType, coseKeyTypeEC2}, {coseKeyAlgorithm, COSEAlgorithmES256},
+		{coseKeyCurve, coseCurveP256}, {coseKeyX, x}, {coseKeyY, y},
+	})
+}
+
+func okpCOSEKey(publicKey ed25519.PublicKey) []byte {
+	return encodeCBOR(cborMap{
+		{coseKeyType, coseKeyTypeOKP}, {coseKeyAlgorithm, COSEAlgorithmEdDSA},
+		{coseKeyCurve, coseCurveEd25519}, {coseKeyX, []byte(publicKey)},
+	})
+}
+
+func rsaCOSEKey(publicKey *rsa.PublicKey) []byte {
+	return encodeCBOR(cborMap{
+		{coseKeyType, coseKeyTypeRSA}, {coseKeyAlgorithm, COSEAlgorithmRS256},
+		{coseKeyRSAN, publicKey.N.Bytes()}, {coseKeyRSAE, []byte{1, 0, 1}},
+	})
+}
+
+func TestParseCOSEKey(t *testing.T) {
+	key, err := DecodeWebAuthnBase64(webauthnIOCOSEKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, algorithm, err := parseCOSEKey(key)
+	if err != nil {
+		t.Fatalf("parseCOSEKey: %v", err)
+	}
+	if algorithm != COSEAlgorithmES256 {
+		t.Fatalf("algorithm = %d, want %d", algorithm, COSEAlgorithmES256)
+	}
+	if _, ok := publicKey.(*ecdsa.PublicKey); !ok {
+		t.Fatalf("public key is %T", publicKey)
+	}
+}
+
+func TestVerifyCOSESignature(t *testing.T) {
+	data := []byte("authenticator data and client data hash")
+	digest := sha256.Sum256(data)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSignature, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPublicKey, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSignature := ed25519.Sign(edKey, data)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSignature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		key       []byte
+		signature []byte
+	}{
+		{"ES256", ec2COSEKey(&ecKey.PublicKey), ecSignature},
+		{"EdDSA", okpCOSEKey(edPublicKey), edSignature},
+		{"RS256", rsaCOSEKey(&rsaKey.PublicKey), rsaSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyCOSESignature(tt.key, data, tt.signature); err != nil {
+				t.Fatalf("verifyCOSESignature: %v", err)
+			}
+			if err := verifyCOSESignature(tt.key, append([]byte("x"), data...), tt.signature); err == nil {
+				t.Fatal("signature over other data verified")
+			}
+			tampered := append([]byte(nil), tt.signature...)
+			tampered[len(tampered)-1] ^= 0x01
+			if err := verifyCOSESignature(tt.key, data, tampered); err == nil {
+				t.Fatal("tampered signature verified")
+			}
+		})
+	}
+}
+
+func TestParseCOSEKeyErrors(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	ecKey.X.FillBytes(x)
+	ecKey.Y.FillBytes(y)
+	offCurveY := append([]byte(nil), y...)
+	offCurveY[31] ^= 0x01
+
+	edPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	smallRSAKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  []byte
+		err  string
+	}{
+		{"not a map", encodeCBOR([]interface{}{1, 2}), "not a map"},
+		{"trailing data", append(ec2COSEKey(&ecKey.PublicKey), 0x00), "trailing data"},
+		{"truncated", ec2COSEKey(&ecKey.PublicKey)[:40], "unexpected end"},
+		{"EC2 key with EdDSA", encodeCBOR(cborMap{
+			{coseKeyType, coseKeyTypeEC2}, {coseKeyAlgorithm, COSEAlgorithmEdDSA},
+			{coseKeyCurve, coseCurveP256}, {coseKeyX, x}, {coseKeyY, y},
+		}), "unsupported COSE key"},
+		{"OKP key with ES256", encodeCBOR(cborMap{
+			{coseKeyType, coseKeyTypeOKP}, {coseKeyAlgorithm, COSEAlgorithmES256},
+			{coseKeyCurve, coseCurveEd25519}, {coseKeyX, []byte(edPublicKey)},
+		}), "unsupported COSE key"},
+		{"ES384", encodeCBOR(cborMap{
+			{coseKeyType, coseKeyTypeEC2}, {coseKeyAlgorithm, -35},
+			{coseKeyCurve, 2}, {coseKeyX, x}, {coseKeyY, y},
+		}), "unsupported COSE key"},
+		{"P-384 curve", encodeCBOR(cborMap{
+			{coseKeyType, coseKeyTypeEC2}, {coseKeyAlgorithm, COSEAlgorithmES256},
+			{coseKeyCurve, 2}, {coseKeyX, x}, {coseKeyY, y},
+		}), "invalid P-256"},
+		{"short coordinate", encodeCBOR(cborMap{
+			{coseKeyType, coseKeyTypeEC2}, {coseKeyAlgorithm, COSEAlgorithmES256},
+			{coseKeyCurve, coseCurveP256}, {coseKeyX, x[1:]}, {coseKeyY, y},
+		}), "invalid P-256"},
+		{"point not on the curve", encodeCBOR(cborMap{
+			{coseKeyType, coseKeyTypeEC2}, {coseKeyAlgorithm, COSEAlgorithmES256},
+			{coseKeyCurve, coseCurveP256}, {coseKeyX, x}, {coseKeyY, offCurveY},
+		}), "not on the curve"},
+		{"Ed448 curve", encodeCBOR(cborMap{
+			{coseKeyType, coseKeyTypeOKP}, {coseKeyAlgorithm, COSEAlgorithmEdDSA},
+			{coseKeyCurve, 7}, {coseKeyX, []byte(edPublicKey)},
+		}), "invalid Ed25519"},
+		{"short Ed25519 key", encodeCBOR(cborMap{
+			{coseKeyType, coseKeyTypeOKP}, {coseKeyAlgorithm, COSEAlgorithmEdDSA},
+			{coseKeyCurve, coseCurveEd25519}, {coseKeyX, []byte(edPublicKey)[:31]},
+		}), "invalid Ed25519"},
+		{"1024-bit RSA key", rsaCOSEKey(&smallRSAKey.PublicKey), "invalid RSA"},
+		{"missing key type", encodeCBOR(cborMap{{coseKeyAlgorithm, COSEAlgorithmES256}}), "unsupported COSE key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseCOSEKey(tt.key)
+			if err == nil {
+				t.Fatal("parseCOSEKey succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("parseCOSEKey error = %q, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/wbrijesh/identity/internal/models"
+)
+
+// IDTokenClaims are the OpenID Connect claims of an ID token. The audience is
+// the ID of the application the user signed in to, which acts as the OIDC
+// client.
+type IDTokenClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Nonce         string `json:"nonce,omitempty"`
+	AuthTime      int64  `json:"auth_time"`
+	jwt.RegisteredClaims
+}
+
+// GenerateIDToken issues an ID token with the issuer and signing algorithm of
+// the token policy of the user's application.
+func GenerateIDToken(user *models.ResponseUser, nonce string) (string, error) {
+	policy, err := ApplicationPolicy(user.ApplicationID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := IDTokenClaims{
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		GivenName:     user.FirstName,
+		FamilyName:    user.LastName,
+		Nonce:         nonce,
+		AuthTime:      now.Unix(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    policy.Issuer,
+			Subject:   user.ID,
+			Audience:  jwt.ClaimStrings{user.ApplicationID},
+			ID:        newTokenID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)), // ID tokens expire in 1 hour
+		},
+	}
+
+	return signWithAlgorithm(jwtKeyRing, policy.SigningAlgorithm, claims)
+}
+
+// ValidateIDToken validates an ID token issued by GenerateIDToken. Admin and
+// user tokens are signed by the same key ring, they are told apart by their
+// role claim.
+func ValidateIDToken(tokenString string) (*TokenInfo, error) {
+	var claims struct {
+		IDTokenClaims
+		Role string `json:"role"`
+	}
+	token, err := jwt.ParseWithClaims(tokenString, &claims, keyfunc(jwtKeyRing), jwt.WithIssuedAt(), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid ID token")
+	}
+
+	if claims.Role != "" || len(claims.Audience) != 1 {
+		return nil, errors.New("token is not an ID token")
+	}
+	if err := checkApplicationIssuer(claims.Audience[0], claims.Issuer); err != nil {
+		return nil, err
+	}
+
+	return tokenInfo(TokenTypeID, claims.Subject, claims.Audience[0], &claims.RegisteredClaims)
+}
@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Hashes imported from other systems are only verified, never produced. The
+// first login with one replaces it with a hash from the current hasher.
+
+// Most PBKDF2 iterations an imported hash may ask for
+const maxPBKDF2Iterations = 10000000
+
+// importedVerifierFor picks the verifier of an imported hash format.
+func importedVerifierFor(encoded string) (passwordVerifier, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2i$"):
+		return argon2iVerifier{}, nil
+	case strings.HasPrefix(encoded, "$firebase-scrypt$"):
+		return firebaseScryptVerifier{}, nil
+	case strings.HasPrefix(encoded, "$pbkdf2"), strings.HasPrefix(encoded, "pbkdf2_"):
+		return pbkdf2Verifier{}, nil
+	case strings.HasPrefix(encoded, "{"):
+		return saltedSHAVerifier{}, nil
+	default:
+		return nil, errors.New("unsupported password hash format")
+	}
+}
+
+// argon2iVerifier reads $argon2i$v=19$m=...,t=...,p=...$salt$hash.
+type argon2iVerifier struct{}
+
+func (argon2iVerifier) Verify(encoded, password string) error {
+	params, salt, key, err := decodeArgon2(encoded, "argon2i")
+	if err != nil {
+		return err
+	}
+	computed := argon2.Key([]byte(password), salt, uint32(params.Iterations), uint32(params.Memory), uint8(params.Parallelism), uint32(len(key)))
+	return compareKeys(computed, key)
+}
+
+func (argon2iVerifier) check(encoded string) error {
+	params, _, _, err := decodeArgon2(encoded, "argon2i")
+	if err != nil {
+		return err
+	}
+	return params.checkImported()
+}
+
+// firebaseScryptVerifier reads the modified scrypt of Firebase
+// Authentication exports, written as
+// $firebase-scrypt$ln=<mem_cost>,r=<rounds>$<signer key>$<salt separator>$<salt>$<hash>
+// with the base64 values of the export and the project's hash parameters.
+type firebaseScryptVerifier struct{}
+
+type firebaseScryptHash struct {
+	params        ScryptHasher
+	signerKey     []byte
+	saltSeparator []byte
+	salt          []byte
+	key           []byte
+}
+
+func (firebaseScryptVerifier) Verify(encoded, password string) error {
+	h, err := decodeFirebaseScrypt(encoded)
+	if err != nil {
+		return err
+	}
+
+	// The signer key is encrypted with AES-256-CTR, a zero IV and the start
+	// of the scrypt key of the password
+	derived, err := scrypt.Key([]byte(password), append(h.salt, h.saltSeparator...), 1<<h.params.LogN, h.params.R, h.params.P, 32)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	computed := make([]byte, len(h.signerKey))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(computed, h.signerKey)
+	return compareKeys(computed, h.key)
+}
+
+func (firebaseScryptVerifier) check(encoded string) error {
+	h, err := decodeFirebaseScrypt(encoded)
+	if err != nil {
+		return err
+	}
+	return h.params.checkImported()
+}
+
+func decodeFirebaseScrypt(encoded string) (*firebaseScryptHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 7 || parts[1] != "firebase-scrypt" {
+		return nil, errors.New("invalid firebase-scrypt hash")
+	}
+	values, err := parsePHCParams(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	h := &firebaseScryptHash{params: ScryptHasher{P: 1}}
+	if err := setPHCParams(values, map[string]*int{"ln": &h.params.LogN, "r": &h.params.R}); err != nil {
+		return nil, err
+	}
+	if err := h.params.validate(); err != nil {
+		return nil, err
+	}
+
+	fields := []*[]byte{&h.signerKey, &h.saltSeparator, &h.salt, &h.key}
+	for i, field := range fields {
+		if *field, err = decodeImportedBase64(parts[3+i]); err != nil {
+			return nil, errors.New("invalid base64 in firebase-scrypt hash")
+		}
+	}
+	if len(h.signerKey) == 0 || len(h.key) != len(h.signerKey) {
+		return nil, errors.New("invalid key in firebase-scrypt hash")
+	}
+	return h, nil
+}
+
+// pbkdf2Verifier reads PBKDF2 hashes in the PHC and passlib form
+// $pbkdf2-<digest>$[i=]<iterations>$<salt>$<hash>, where a bare $pbkdf2$ is
+// SHA-1, and in the Django form pbkdf2_<digest>$<iterations>$<salt>$<hash>,
+// whose salt is used as written. Digests are sha1, sha256 and sha512.
+type pbkdf2Verifier struct{}
+
+type pbkdf2Hash struct {
+	digest     func() hash.Hash
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+func (pbkdf2Verifier) Verify(encoded, password string) error {
+	h, err := decodePBKDF2(encoded)
+	if err != nil {
+		return err
+	}
+	computed := pbkdf2.Key([]byte(password), h.salt, h.iterations, len(h.key), h.digest)
+	return compareKeys(computed, h.key)
+}
+
+func (pbkdf2Verifier) check(encoded string) error {
+	h, err := decodePBKDF2(encoded)
+	if err != nil {
+		return err
+	}
+	if h.iterations > maxImportedPBKDF2Iterations {
+		return fmt.Errorf("imported pbkdf2 hashes need iterations <= %d", maxImportedPBKDF2Iterations)
+	}
+	return nil
+}
+
+func decodePBKDF2(encoded string) (*pbkdf2Hash, error) {
+	invalid := errors.New("invalid pbkdf2 hash")
+
+	django := !strings.HasPrefix(encoded, "$")
+	parts := strings.Split(strings.TrimPrefix(encoded, "$"), "$")
+	if len(parts) != 4 {
+		return nil, invalid
+	}
+	algorithm, iterations, salt, key := parts[0], parts[1], parts[2], parts[3]
+
+	var name string
+	if django {
+		name = strings.TrimPrefix(algorithm, "pbkdf2_")
+	} else if algorithm == "pbkdf2" {
+		name = "sha1"
+	} else {
+		name = strings.TrimPrefix(algorithm, "pbkdf2-")
+	}
+	h := &pbkdf2Hash{}
+	switch name {
+	case "sha1":
+		h.digest = sha1.New
+	case "sha256":
+		h.digest = sha256.New
+	case "sha512":
+		h.digest = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported pbkdf2 digest %q", name)
+	}
+
+	n, err := strconv.Atoi(strings.TrimPrefix(iterations, "i="))
+	if err != nil || n < 1 || n > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("pbkdf2 needs 1 <= iterations <= %d", maxPBKDF2Iterations)
+	}
+	h.iterations = n
+
+	if django {
+		h.salt = []byte(salt)
+	} else if h.salt, err = decodeImportedBase64(salt); err != nil {
+		return nil, invalid
+	}
+	if h.key, err = decodeImportedBase64(key); err != nil || len(h.key) == 0 {
+		return nil, invalid
+	}
+	return h, nil
+}
+
+// saltedSHAVerifier reads the LDAP salted SHA hashes {SSHA}, {SSHA256},
+// {SSHA384} and {SSHA512}: the base64 of the digest of the password and
+// salt, followed by the salt.
+type saltedSHAVerifier struct{}
+
+type saltedSHAHash struct {
+	digest func() hash.Hash
+	salt   []byte
+	key    []byte
+}
+
+func (saltedSHAVerifier) Verify(encoded, password string) error {
+	h, err := decodeSaltedSHA(encoded)
+	if err != nil {
+		return err
+	}
+	digest := h.digest()
+	digest.Write([]byte(password))
+	digest.Write(h.salt)
+	return compareKeys(digest.Sum(nil), h.key)
+}
+
+func (saltedSHAVerifier) check(encoded string) error {
+	_, err := decodeSaltedSHA(encoded)
+	return err
+}
+
+func decodeSaltedSHA(encoded string) (*saltedSHAHash, error) {
+	scheme, value, ok := strings.Cut(strings.TrimPrefix(encoded, "{"), "}")
+	if !ok {
+		return nil, errors.New("invalid salted SHA hash")
+	}
+
+	h := &saltedSHAHash{}
+	switch strings.ToUpper(scheme) {
+	case "SSHA":
+		h.digest = sha1.New
+	case "SSHA256":
+		h.digest = sha256.New
+	case "SSHA384":
+		h.digest = sha512.New384
+	case "SSHA512":
+		h.digest = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported salted SHA scheme %q", scheme)
+	}
+
+	decoded, err := decodeImportedBase64(value)
+	size := h.digest().Size()
+	if err != nil || len(decoded) <= size {
+		return nil, errors.New("invalid salted SHA hash")
+	}
+	h.key, h.salt = decoded[:size], decoded[size:]
+	return h, nil
+}
+
+// decodeImportedBase64 decodes base64 with or without padding, in the
+// standard, URL safe or passlib alphabet.
+func decodeImportedBase64(value string) ([]byte, error) {
+	value = strings.TrimRight(value, "=")
+	value = strings.NewReplacer(".", "+", "-", "+", "_", "/").Replace(value)
+	return base64.RawStdEncoding.DecodeString(value)
+}
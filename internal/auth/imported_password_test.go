@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+// The Firebase hash is the example of the firebase/scrypt README. The argon2i
+// hashes are from the argon2 test suite and README. The PBKDF2 and salted SHA
+// hashes were made with Python's hashlib, the passlib one with the salt of
+// the passlib documentation's example.
+var importedPasswordHashes = []struct {
+	name     string
+	encoded  string
+	password string
+}{
+	{"firebase-scrypt", "$firebase-scrypt$ln=14,r=8" +
+		"$jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVMXAn210wjLNmdZJzxUECKbm0QsEmYUSDzZvpjeJ9WmXA==" +
+		"$Bw==$42xEC+ixf3L2lw==" +
+		"$lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ==", "user1password"},
+	{"argon2i", "$argon2i$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$wWKIMhR9lyDFvRz9YTZweHKfbftvj+qf+YFY4NeBbtA", "password"},
+	{"argon2i with 24 byte key", "$argon2i$v=19$m=65536,t=2,p=4$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG", "password"},
+	{"Django pbkdf2_sha256", "pbkdf2_sha256$10000$seasalt$CWWFdHOWwPnki7HvkcqN9iA2T3KLW1cf2uZ5kvArtVY=", "lètmein"},
+	{"Django pbkdf2_sha1", "pbkdf2_sha1$1000$saltsalt$6f6/9Uv85mj94wGsyFVjzJ3HHvY=", "password"},
+	{"passlib pbkdf2-sha256", "$pbkdf2-sha256$29000$9t7be09prfXee2/NOUeotQ$yIPphVdF97u.XzygN1ToroW.pJoenSO2MPWQIaDuH7Y", "password"},
+	{"passlib pbkdf2", "$pbkdf2$131000$MDEyMzQ1Njc4OWFiY2RlZg$psSehCxCX0ECqfbI2etYTXYpLDM", "password"},
+	{"PHC pbkdf2-sha512", "$pbkdf2-sha512$i=25000$MDEyMzQ1Njc4OWFiY2RlZg" +
+		"$uxdHU+JH8vyHyz/DeXpgS7H6Tjz9A+lBWgSoOvvbH2iwTOWkhoRip9yMdB0AZCvZtIdBg46qUM3yPQkKDTKUSg", "password"},
+	{"SSHA", "{SSHA}bQL5TNs2RgAqaAf+lnQNLf3mGBYSNFZ4mrze8A==", "secret"},
+	{"SSHA256", "{SSHA256}+B94UBVGzjDYWaKdsj3s/AW+sH/OrYJvEG0zvwkJFtISNFZ4mrze8A==", "secret"},
+	{"SSHA384", "{SSHA384}L+GMS8dPgFqHLFva2p/WooxoWFroC9wjp24MmmmoOhmXzez9YbQFoE527GXyfF0tEjRWeJq83vA=", "secret"},
+	{"SSHA512", "{SSHA512}FPMAusYc6LqCJg2Y00PKU9HUzENA/ONdqTtowL3hLJFnF3YEWDSutM3r5oZWH0+yPrfF1rMzEMhJTotMrdEhFxI0VniavN7w", "secret"},
+	{"lowercase ssha", "{ssha}bQL5TNs2RgAqaAf+lnQNLf3mGBYSNFZ4mrze8A==", "secret"},
+}
+
+func TestVerifyImportedPasswordHash(t *testing.T) {
+	for _, tt := range importedPasswordHashes {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidatePasswordHash(tt.encoded); err != nil {
+				t.Fatalf("ValidatePasswordHash: %v", err)
+			}
+			if err := VerifyPasswordHash(tt.encoded, tt.password); err != nil {
+				t.Fatalf("VerifyPasswordHash: %v", err)
+			}
+			if err := VerifyPasswordHash(tt.encoded, tt.password+"1"); !errors.Is(err, ErrPasswordMismatch) {
+				t.Fatalf("VerifyPasswordHash with a wrong password = %v, want ErrPasswordMismatch", err)
+			}
+			if !PasswordNeedsRehash(tt.encoded) {
+				t.Fatal("PasswordNeedsRehash = false, imported hashes are always replaced")
+			}
+		})
+	}
+}
+
+func TestValidateImportedPasswordHashErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"firebase-scrypt missing salt", "$firebase-scrypt$ln=14,r=8$jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVM$Bw==$lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1Q"},
+		{"firebase-scrypt key length", "$firebase-scrypt$ln=14,r=8$jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVM$Bw==$42xEC+ixf3L2lw==$lSrfV15cpx95"},
+		{"firebase-scrypt huge memory cost", "$firebase-scrypt$ln=40,r=8$jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVM$Bw==$42xEC+ixf3L2lw==$lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1Q"},
+		{"firebase-scrypt parallelism", "$firebase-scrypt$ln=14,r=8,p=2$jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVM$Bw==$42xEC+ixf3L2lw==$lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1Q"},
+		{"firebase-scrypt invalid base64", "$firebase-scrypt$ln=14,r=8$jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVM$B!==$42xEC+ixf3L2lw==$lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1Q"},
+		{"argon2i huge memory", "$argon2i$v=19$m=8388608,t=2,p=1$c29tZXNhbHQ$wWKIMhR9lyDFvRz9YTZweHKfbftvj+qf+YFY4NeBbtA"},
+		{"argon2d", "$argon2d$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$wWKIMhR9lyDFvRz9YTZweHKfbftvj+qf+YFY4NeBbtA"},
+		{"pbkdf2 md5", "pbkdf2_md5$1000$saltsalt$6f6/9Uv85mj94wGsyFVjzJ3HHvY="},
+		{"pbkdf2 too many iterations", "pbkdf2_sha256$100000000$seasalt$CWWFdHOWwPnki7HvkcqN9iA2T3KLW1cf2uZ5kvArtVY="},
+		{"pbkdf2 zero iterations", "$pbkdf2-sha256$i=0$MDEyMzQ1Njc4OWFiY2RlZg$yIPphVdF97u.XzygN1ToroW.pJoenSO2MPWQIaDuH7Y"},
+		{"pbkdf2 missing salt", "$pbkdf2-sha256$29000$yIPphVdF97u.XzygN1ToroW.pJoenSO2MPWQIaDuH7Y"},
+		{"pbkdf2 empty key", "pbkdf2_sha256$10000$seasalt$"},
+		{"bcrypt-sha256", "bcrypt_sha256$$2b$12$abcdefghijklmnopqrstuv"},
+		{"SSHA without salt", "{SSHA}bQL5TNs2RgAqaAf+lnQNLf3mGBY="},
+		{"SSHA invalid base64", "{SSHA}bQL5TNs2!gAqaAf+lnQNLf3mGBYSNFZ4mrze8A=="},
+		{"unsalted SHA", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="},
+		{"crypt MD5", "$1$saltsalt$qjXMvbEw8oaL.CzflDugX/"},
+		{"unterminated scheme", "{SSHA bQL5TNs2RgAqaAf+lnQNLf3mGBYSNFZ4mrze8A=="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidatePasswordHash(tt.encoded); err == nil {
+				t.Fatal("ValidatePasswordHash succeeded")
+			}
+		})
+	}
+}
+
+// Imported hashes have to be cheap enough to check at every login. Only the
+// parameters are out of bounds, the hashes are otherwise well formed.
+func TestValidateImportedPasswordHashCost(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		valid   bool
+	}{
+		{"argon2id at the memory limit", "$argon2id$v=19$m=262144,t=1,p=1$c29tZXNhbHQ$a2V5", true},
+		{"argon2id 512 MiB", "$argon2id$v=19$m=524288,t=1,p=1$c29tZXNhbHQ$a2V5", false},
+		{"argon2id 4 GiB", "$argon2id$v=19$m=4194304,t=1,p=1$c29tZXNhbHQ$a2V5", false},
+		{"argon2i too many iterations", "$argon2i$v=19$m=65536,t=11,p=1$c29tZXNhbHQ$a2V5", false},
+		{"scrypt at the memory limit", "$scrypt$ln=18,r=8,p=1$c29tZXNhbHQ$a2V5", true},
+		{"scrypt 1 GiB", "$scrypt$ln=20,r=8,p=1$c29tZXNhbHQ$a2V5", false},
+		{"scrypt high parallelism", "$scrypt$ln=14,r=8,p=64$c29tZXNhbHQ$a2V5", false},
+		{"firebase-scrypt 512 MiB", "$firebase-scrypt$ln=19,r=8$jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVM$Bw==$42xEC+ixf3L2lw==$lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1Q", false},
+		{"bcrypt at the cost limit", "$2a$14$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", true},
+		{"bcrypt cost 20", "$2a$20$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", false},
+		{"pbkdf2 at the iteration limit", "pbkdf2_sha256$1000000$seasalt$CWWFdHOWwPnki7HvkcqN9iA2T3KLW1cf2uZ5kvArtVY=", true},
+		{"pbkdf2 10M iterations", "$pbkdf2-sha256$i=10000000$MDEyMzQ1Njc4OWFiY2RlZg$yIPphVdF97u.XzygN1ToroW.pJoenSO2MPWQIaDuH7Y", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePasswordHash(tt.encoded)
+			if tt.valid && err != nil {
+				t.Fatalf("ValidatePasswordHash: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("ValidatePasswordHash succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// Issuer returns the public base URL of the identity service, used as the iss
+// claim and as the base of the OpenID Connect endpoints. It is read from
+// ISSUER_URL and falls back to localhost on the configured port.
+func Issuer() string {
+	if issuer := os.Getenv("ISSUER_URL"); issuer != "" {
+		return strings.TrimSuffix(issuer, "/")
+	}
+	return "http://localhost:" + os.Getenv("PORT")
+}
+
+// SigningAlgorithms returns the algorithms of the keys that may sign new
+// tokens in the jwt key ring, the default algorithm first.
+func SigningAlgorithms() ([]string, error) {
+	ring, err := keyRing(jwtKeyRing)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithms := []string{ring.active.Algorithm}
+	for algorithm := range ring.activeByAlg {
+		if algorithm != ring.active.Algorithm {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	sort.Strings(algorithms[1:])
+	return algorithms, nil
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"sort"
+)
+
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+
+	// RSA public keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP public keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys of every asymmetric key that is not retired,
+// so resource servers can verify tokens without holding a shared secret.
+func JWKS() (*JSONWebKeySet, error) {
+	if err := LoadKeys(); err != nil {
+		return nil, err
+	}
+
+	set := &JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, ring := range keyRings {
+		for _, key := range ring.keys {
+			if key.Status == KeyStatusRetired || key.PublicKey() == nil {
+				continue
+			}
+			set.Keys = append(set.Keys, toJSONWebKey(key))
+		}
+	}
+
+	// Map iteration order is random, keep the document stable for caches
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].KeyID < set.Keys[j].KeyID
+	})
+
+	return set, nil
+}
+
+func toJSONWebKey(key *Key) JSONWebKey {
+	jwk := JSONWebKey{
+		KeyID:     key.ID,
+		Algorithm: key.Algorithm,
+		Use:       "sig",
+	}
+
+	switch publicKey := key.PublicKey().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encodeSegment(publicKey.N.Bytes())
+		jwk.E = encodeSegment(big.NewInt(int64(publicKey.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = publicKey.Curve.Params().Name
+		jwk.X = encodeSegment(publicKey.X.FillBytes(make([]byte, size)))
+		jwk.Y = encodeSegment(publicKey.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = encodeSegment(publicKey)
+	}
+
+	return jwk
+}
+
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -7,29 +7,111 @@ import (
 	"github.com/wbrijesh/identity/internal/models"
 )
 
-var jwtSecret = []byte("your_secret_key_here") // Replace with a secure secret key
-
-func GenerateAdminJWT(admin *models.ResponseAdmin) (string, error) {
-	claims := jwt.MapClaims{
-		"id":    admin.ID,
-		"email": admin.Email,
-		"role":  "admin",
-		"exp":   time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
+// Lifetime of admin tokens and default lifetime of user tokens. User tokens
+// are short lived and renewed with the refresh token of their session.
+const (
+	AdminTokenTTL = 24 * time.Hour
+	UserTokenTTL  = 15 * time.Minute
+)
+
+// Roles of the tokens signed by the jwt key ring. ID tokens have no role.
+// RoleAdminMFA marks the challenge handed to an admin whose password was
+// accepted but who still has to pass the second factor.
+const (
+	RoleAdmin    = "admin"
+	RoleAdminMFA = "admin_mfa"
+	RoleUser     = "user"
+)
+
+// Authentication methods recorded in the amr claim of admin tokens, from
+// RFC 8176. AuthMethodRecoveryCode is not registered there and marks a login
+// completed with a recovery code instead of a TOTP code.
+const (
+	AuthMethodPassword     = "pwd"
+	AuthMethodOTP          = "otp"
+	AuthMethodRecoveryCode = "rec"
+	AuthMethodMFA          = "mfa"
+)
+
+// AdminClaims are the claims of an admin token. The subject is the admin ID
+// and the audience is the issuer itself, admin tokens are only accepted by
+// the admin API of this service. AMR lists the methods the admin
+// authenticated with.
+type AdminClaims struct {
+	Email string   `json:"email"`
+	Role  string   `json:"role"`
+	AMR   []string `json:"amr,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// UserClaims are the claims of a user token. The subject is the user ID and
+// the audience is the application the user belongs to.
+type UserClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	ApplicationID string `json:"application_id"`
+	SessionID     string `json:"sid"`
+	Role          string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// GenerateAdminJWT issues an admin token recording the methods the admin
+// authenticated with.
+func GenerateAdminJWT(admin *models.ResponseAdmin, authMethods []string) (string, error) {
+	return generateAdminToken(admin, RoleAdmin, authMethods, AdminTokenTTL)
+}
+
+// GenerateAdminMFAToken issues the challenge an admin exchanges for an admin
+// token by passing the second factor.
+func GenerateAdminMFAToken(admin *models.ResponseAdmin) (string, error) {
+	return generateAdminToken(admin, RoleAdminMFA, []string{AuthMethodPassword}, MFAChallengeTTL)
+}
+
+func generateAdminToken(admin *models.ResponseAdmin, role string, authMethods []string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := AdminClaims{
+		Email: admin.Email,
+		Role:  role,
+		AMR:   authMethods,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer(),
+			Subject:   admin.ID,
+			Audience:  jwt.ClaimStrings{Issuer()},
+			ID:        newTokenID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return sign(jwtKeyRing, claims)
 }
 
-func GenerateUserJWT(user *models.ResponseUser) (string, error) {
-	claims := jwt.MapClaims{
-		"id":             user.ID,
-		"email":          user.Email,
-		"application_id": user.ApplicationID,
-		"role":           "user",
-		"exp":            time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
+// GenerateUserJWT issues a user token following the token policy of the
+// user's application.
+func GenerateUserJWT(user *models.ResponseUser, sessionID string) (string, error) {
+	policy, err := ApplicationPolicy(user.ApplicationID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := UserClaims{
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		ApplicationID: user.ApplicationID,
+		SessionID:     sessionID,
+		Role:          RoleUser,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    policy.Issuer,
+			Subject:   user.ID,
+			Audience:  jwt.ClaimStrings{user.ApplicationID},
+			ID:        newTokenID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(policy.UserTokenTTL)),
+		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signWithAlgorithm(jwtKeyRing, policy.SigningAlgorithm, claims)
 }
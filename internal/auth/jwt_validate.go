@@ -2,63 +2,94 @@ package auth
 
 import (
 	"errors"
-	"fmt"
 
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/wbrijesh/identity/internal/models"
 )
 
-func ValidateAdminJWT(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
-
-	if err != nil {
-		return "", err
+// Options shared by admin and user token validation. Every registered claim
+// the generators set is required, nbf is checked whenever it is present. The
+// issuer of user tokens depends on their application, so it is checked after
+// parsing.
+func jwtParserOptions() []jwt.ParserOption {
+	return []jwt.ParserOption{
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
 	}
+}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if claims["role"] != "admin" {
-			return "", errors.New("token is not for an admin")
-		}
+// ValidateAdminJWT validates an admin token and returns its claims.
+func ValidateAdminJWT(tokenString string) (*AdminClaims, error) {
+	return validateAdminToken(tokenString, RoleAdmin)
+}
+
+// ValidateAdminMFAToken validates the MFA challenge of an admin login and
+// returns its claims.
+func ValidateAdminMFAToken(tokenString string) (*AdminClaims, error) {
+	return validateAdminToken(tokenString, RoleAdminMFA)
+}
 
-		admin := &models.Admin{
-			ID:    claims["id"].(string),
-			Email: claims["email"].(string),
-		}
-		return admin.ID, nil
+func validateAdminToken(tokenString, role string) (*AdminClaims, error) {
+	claims := &AdminClaims{}
+	options := append(jwtParserOptions(), jwt.WithIssuer(Issuer()), jwt.WithAudience(Issuer()))
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyfunc(jwtKeyRing), options...)
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid admin token")
 	}
 
-	return "", errors.New("invalid token")
+	if claims.Role != role {
+		return nil, errors.New("token is not for an admin")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	return claims, nil
 }
 
-func ValidateUserJWT(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
+// ValidateUserJWT validates a user token and returns its claims. The audience
+// must be the application the user belongs to.
+func ValidateUserJWT(tokenString string) (*UserClaims, error) {
+	claims := &UserClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyfunc(jwtKeyRing), jwtParserOptions()...)
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid user token")
+	}
 
-	if err != nil {
-		return "", err
+	if claims.Role != RoleUser {
+		return nil, errors.New("token is not for a user")
+	}
+	if claims.Subject == "" || claims.ApplicationID == "" {
+		return nil, errors.New("token has no subject")
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != claims.ApplicationID {
+		return nil, errors.New("token audience does not match its application")
+	}
+	if err := checkApplicationIssuer(claims.ApplicationID, claims.Issuer); err != nil {
+		return nil, err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if claims["role"] != "user" {
-			return "", errors.New("token is not for a user")
-		}
+	return claims, nil
+}
 
-		user := &models.User{
-			ID:            claims["id"].(string),
-			Email:         claims["email"].(string),
-			ApplicationID: claims["application_id"].(string),
-		}
-		return user.ID, nil
-	}
+// Info returns the identity of the admin token for revocation checks.
+func (c *AdminClaims) Info() (*TokenInfo, error) {
+	return tokenInfo(TokenTypeAdmin, c.Subject, "", &c.RegisteredClaims)
+}
 
-	return "", errors.New("invalid token")
+// Info returns the identity of the user token for revocation checks.
+func (c *UserClaims) Info() (*TokenInfo, error) {
+	return tokenInfo(TokenTypeUser, c.Subject, c.ApplicationID, &c.RegisteredClaims)
+}
+
+// checkApplicationIssuer checks the issuer of a user or ID token against the
+// token policy of its application.
+func checkApplicationIssuer(applicationID, issuer string) error {
+	policy, err := ApplicationPolicy(applicationID)
+	if err != nil {
+		return err
+	}
+	if issuer != policy.Issuer {
+		return errors.New("token has an unexpected issuer")
+	}
+	return nil
 }
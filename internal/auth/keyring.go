@@ -0,0 +1,383 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Names of the key rings used by the token generators. Each token type is
+// signed by its own ring so a token of one type can never be replayed as
+// another. The secret ring holds HS256 secrets that encrypt stored secrets,
+// such as TOTP secrets, rather than signing tokens.
+const (
+	jwtKeyRing     = "jwt"
+	refreshKeyRing = "refresh"
+	accessKeyRing  = "access"
+	secretKeyRing  = "secret"
+)
+
+// Minimum secret length accepted for HMAC keys.
+const minSecretLength = 32
+
+// Signing algorithms supported by the key rings.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+type KeyStatus string
+
+const (
+	// KeyStatusActive keys sign new tokens and verify existing ones.
+	KeyStatusActive KeyStatus = "active"
+	// KeyStatusVerify keys only verify tokens issued before a rotation.
+	KeyStatusVerify KeyStatus = "verify"
+	// KeyStatusRetired keys are kept in configuration but reject every token.
+	KeyStatusRetired KeyStatus = "retired"
+)
+
+type Key struct {
+	ID        string    `json:"kid"`
+	Algorithm string    `json:"alg"`
+	Status    KeyStatus `json:"status"`
+
+	// Secret is the shared secret of an HS256 key.
+	Secret string `json:"secret"`
+
+	// PrivateKey or PrivateKeyFile hold the PEM encoded private key of an
+	// RS256, ES256 or EdDSA key.
+	PrivateKey     string `json:"private_key"`
+	PrivateKeyFile string `json:"private_key_file"`
+
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeyRing holds the keys of one kind of token. A ring can have one active key
+// per algorithm so applications can choose how their tokens are signed, the
+// first active key is the default.
+type KeyRing struct {
+	keys        map[string]*Key
+	active      *Key
+	activeByAlg map[string]*Key
+}
+
+var (
+	keyRings    map[string]*KeyRing
+	keyRingsErr error
+	keyRingOnce sync.Once
+)
+
+// LoadKeys reads the signing keys from configuration. It is safe to call more
+// than once; the keys are only read the first time.
+//
+// When AUTH_KEY_FILE is set it must point to a JSON file of the form
+//
+//	{"jwt": [{"kid": "2024-10", "alg": "ES256", "private_key_file": "...", "status": "active"}], "refresh": [...], "access": [...], "secret": [...]}
+//
+// Otherwise the rings are read from AUTH_JWT_KEYS, AUTH_REFRESH_KEYS,
+// AUTH_ACCESS_KEYS and AUTH_SECRET_KEYS, each a comma separated list of kid:secret pairs for HS256
+// keys or kid:alg:path entries for asymmetric keys stored in PEM files. The
+// first entry is the active key and the rest are only used for verification.
+func LoadKeys() error {
+	keyRingOnce.Do(func() {
+		keyRings, keyRingsErr = loadKeyRings()
+	})
+	return keyRingsErr
+}
+
+func loadKeyRings() (map[string]*KeyRing, error) {
+	var config map[string][]*Key
+
+	if path := os.Getenv("AUTH_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse key file: %w", err)
+		}
+	} else {
+		config = map[string][]*Key{}
+		envs := map[string]string{
+			jwtKeyRing:     "AUTH_JWT_KEYS",
+			refreshKeyRing: "AUTH_REFRESH_KEYS",
+			accessKeyRing:  "AUTH_ACCESS_KEYS",
+			secretKeyRing:  "AUTH_SECRET_KEYS",
+		}
+		for name, env := range envs {
+			keys, err := parseKeyList(os.Getenv(env))
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", env, err)
+			}
+			config[name] = keys
+		}
+	}
+
+	// Key IDs must be unique across rings since public keys from every ring
+	// are published in the same JWKS document.
+	seen := make(map[string]string)
+
+	rings := make(map[string]*KeyRing)
+	for _, name := range []string{jwtKeyRing, refreshKeyRing, accessKeyRing, secretKeyRing} {
+		ring, err := newKeyRing(name, config[name])
+		if err != nil {
+			return nil, err
+		}
+		for kid := range ring.keys {
+			if other, exists := seen[kid]; exists {
+				return nil, fmt.Errorf("kid %s is used in both the %s and %s key rings", kid, other, name)
+			}
+			seen[kid] = name
+		}
+		rings[name] = ring
+	}
+
+	return rings, nil
+}
+
+func parseKeyList(value string) ([]*Key, error) {
+	var keys []*Key
+	for i, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kid, rest, found := strings.Cut(entry, ":")
+		if !found {
+			return nil, fmt.Errorf("key entry %d is not of the form kid:secret or kid:alg:path", i+1)
+		}
+
+		key := &Key{ID: kid, Algorithm: AlgorithmHS256, Secret: rest}
+		if alg, path, found := strings.Cut(rest, ":"); found && isAsymmetric(alg) {
+			key = &Key{ID: kid, Algorithm: alg, PrivateKeyFile: path}
+		}
+
+		key.Status = KeyStatusVerify
+		if len(keys) == 0 {
+			key.Status = KeyStatusActive
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func isAsymmetric(alg string) bool {
+	return alg == AlgorithmRS256 || alg == AlgorithmES256 || alg == AlgorithmEdDSA
+}
+
+func newKeyRing(name string, keys []*Key) (*KeyRing, error) {
+	ring := &KeyRing{
+		keys:        make(map[string]*Key),
+		activeByAlg: make(map[string]*Key),
+	}
+
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, fmt.Errorf("%s key ring: key without kid", name)
+		}
+		if _, exists := ring.keys[key.ID]; exists {
+			return nil, fmt.Errorf("%s key ring: duplicate kid %s", name, key.ID)
+		}
+		if key.Status == "" {
+			key.Status = KeyStatusVerify
+		}
+		if name == secretKeyRing && key.Algorithm != "" && key.Algorithm != AlgorithmHS256 {
+			return nil, fmt.Errorf("%s key ring: key %s must be an HS256 secret", name, key.ID)
+		}
+
+		switch key.Status {
+		case KeyStatusActive:
+			if _, exists := ring.activeByAlg[key.Algorithm]; exists {
+				return nil, fmt.Errorf("%s key ring: more than one active %s key", name, key.Algorithm)
+			}
+			if ring.active == nil {
+				ring.active = key
+			}
+			ring.activeByAlg[key.Algorithm] = key
+		case KeyStatusVerify, KeyStatusRetired:
+		default:
+			return nil, fmt.Errorf("%s key ring: unknown status %q for key %s", name, key.Status, key.ID)
+		}
+
+		if key.Status != KeyStatusRetired {
+			if err := key.load(); err != nil {
+				return nil, fmt.Errorf("%s key ring: key %s: %w", name, key.ID, err)
+			}
+		}
+
+		ring.keys[key.ID] = key
+	}
+
+	if ring.active == nil {
+		return nil, fmt.Errorf("%s key ring: no active signing key configured", name)
+	}
+
+	return ring, nil
+}
+
+// load resolves the signing method and key material of a configured key.
+func (k *Key) load() error {
+	if k.Algorithm == "" {
+		k.Algorithm = AlgorithmHS256
+	}
+
+	if k.Algorithm == AlgorithmHS256 {
+		if len(k.Secret) < minSecretLength {
+			return fmt.Errorf("secret must be at least %d bytes", minSecretLength)
+		}
+		k.method = jwt.SigningMethodHS256
+		k.signKey = []byte(k.Secret)
+		k.verifyKey = []byte(k.Secret)
+		return nil
+	}
+
+	if !isAsymmetric(k.Algorithm) {
+		return fmt.Errorf("unsupported algorithm %s", k.Algorithm)
+	}
+
+	pemData := []byte(k.PrivateKey)
+	if k.PrivateKeyFile != "" {
+		data, err := os.ReadFile(k.PrivateKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read private key: %w", err)
+		}
+		pemData = data
+	}
+	if len(pemData) == 0 {
+		return errors.New("private key is required")
+	}
+
+	switch k.Algorithm {
+	case AlgorithmRS256:
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return err
+		}
+		if privateKey.N.BitLen() < 2048 {
+			return errors.New("RSA keys must be at least 2048 bits")
+		}
+		k.method = jwt.SigningMethodRS256
+		k.signKey = privateKey
+		k.verifyKey = &privateKey.PublicKey
+	case AlgorithmES256:
+		privateKey, err := jwt.ParseECPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return err
+		}
+		if privateKey.Curve != elliptic.P256() {
+			return errors.New("ES256 keys must use the P-256 curve")
+		}
+		k.method = jwt.SigningMethodES256
+		k.signKey = privateKey
+		k.verifyKey = &privateKey.PublicKey
+	case AlgorithmEdDSA:
+		privateKey, err := jwt.ParseEdPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return err
+		}
+		k.method = jwt.SigningMethodEdDSA
+		k.signKey = privateKey
+		k.verifyKey = privateKey.(crypto.Signer).Public()
+	}
+
+	return nil
+}
+
+// PublicKey returns the public half of an asymmetric key, or nil for HMAC
+// keys which have no public part.
+func (k *Key) PublicKey() crypto.PublicKey {
+	switch key := k.verifyKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return key
+	}
+	return nil
+}
+
+func keyRing(name string) (*KeyRing, error) {
+	if err := LoadKeys(); err != nil {
+		return nil, err
+	}
+	return keyRings[name], nil
+}
+
+// sign signs the claims with the default active key of the named ring and
+// records the key ID in the kid header.
+func sign(ringName string, claims jwt.Claims) (string, error) {
+	return signWithAlgorithm(ringName, "", claims)
+}
+
+// signWithAlgorithm signs the claims with the active key of the named ring
+// for the algorithm, or with the default active key when it is empty.
+func signWithAlgorithm(ringName, algorithm string, claims jwt.Claims) (string, error) {
+	key, err := signingKey(ringName, algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+func signingKey(ringName, algorithm string) (*Key, error) {
+	ring, err := keyRing(ringName)
+	if err != nil {
+		return nil, err
+	}
+	if algorithm == "" {
+		return ring.active, nil
+	}
+
+	key, ok := ring.activeByAlg[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("%s key ring has no active %s key", ringName, algorithm)
+	}
+	return key, nil
+}
+
+// keyfunc returns a jwt.Keyfunc that resolves the verification key from the
+// kid header, accepting any key of the named ring that is not retired.
+func keyfunc(ringName string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		ring, err := keyRing(ringName)
+		if err != nil {
+			return nil, err
+		}
+
+		kid, ok := token.Header["kid"].(string)
+		if !ok || kid == "" {
+			return nil, errors.New("token has no kid header")
+		}
+
+		key, ok := ring.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %s", kid)
+		}
+		if key.Status == KeyStatusRetired {
+			return nil, fmt.Errorf("signing key %s is retired", kid)
+		}
+
+		// The algorithm is pinned by the key, never taken from the token
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return key.verifyKey, nil
+	}
+}
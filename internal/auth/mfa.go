@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"strings"
+	"time"
+)
+
+// MFAChallengeTTL is how long a user has to enter the second factor after
+// the password was accepted.
+const MFAChallengeTTL = 5 * time.Minute
+
+// MFA challenge tokens are opaque, like session refresh tokens, and embed
+// the challenge ID so the challenge can be looked up before the hash is
+// compared.
+const mfaChallengeTokenPrefix = "mfa_"
+
+// GenerateMFAChallengeToken returns a new token for an MFA challenge and the
+// hash to store on the challenge.
+func GenerateMFAChallengeToken(challengeID string) (string, string, error) {
+	secret, _, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	token := mfaChallengeTokenPrefix + challengeID + "." + secret
+	return token, HashOpaqueToken(token), nil
+}
+
+// ParseMFAChallengeToken returns the ID of the challenge a token was issued
+// for.
+func ParseMFAChallengeToken(token string) (string, bool) {
+	if !strings.HasPrefix(token, mfaChallengeTokenPrefix) {
+		return "", false
+	}
+	challengeID, secret, found := strings.Cut(strings.TrimPrefix(token, mfaChallengeTokenPrefix), ".")
+	if !found || challengeID == "" || secret == "" {
+		return "", false
+	}
+	return challengeID, true
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// How long the links and codes sent by email stay valid. Login and reset
+// links are short-lived, verification emails may sit in an inbox for a
+// while.
+const (
+	EmailLoginTTL        = 10 * time.Minute
+	EmailVerificationTTL = 24 * time.Hour
+	PasswordResetTTL     = 30 * time.Minute
+	EmailChangeTTL       = time.Hour
+)
+
+// oneTimeCodeDigits is the length of codes typed in by users.
+const oneTimeCodeDigits = 6
+
+// GenerateOneTimeCode returns a random numeric code for a user to copy from
+// an email. Codes are short, so they are only accepted a few times before
+// the token they belong to is burnt.
+func GenerateOneTimeCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < oneTimeCodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate code: %w", err)
+	}
+	return fmt.Sprintf("%0*d", oneTimeCodeDigits, n), nil
+}
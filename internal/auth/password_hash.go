@@ -0,0 +1,457 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrPasswordMismatch is returned when a password does not match a hash.
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// PasswordHasher hashes passwords into self-describing strings that carry
+// the algorithm and parameters, in the PHC string format
+// ($id$param=value,...$salt$hash). bcrypt keeps its own $2b$ format, which
+// every bcrypt library reads.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of the password with a new salt.
+	Hash(password string) (string, error)
+
+	// Verify checks the password against a hash made by this algorithm,
+	// with any parameters.
+	Verify(encoded, password string) error
+
+	// NeedsRehash reports whether the hash was made by another algorithm or
+	// with other parameters than the hasher's.
+	NeedsRehash(encoded string) bool
+}
+
+// Defaults follow the OWASP password storage recommendations.
+var (
+	DefaultArgon2idHasher = &Argon2idHasher{Memory: 19456, Iterations: 2, Parallelism: 1}
+	DefaultScryptHasher   = &ScryptHasher{LogN: 15, R: 8, P: 1}
+	DefaultBcryptHasher   = &BcryptHasher{Cost: bcrypt.DefaultCost}
+)
+
+const (
+	passwordSaltLength = 16
+	passwordKeyLength  = 32
+)
+
+// Most memory a hash may take to compute, so a stored hash with absurd
+// parameters can't exhaust the server
+const maxPasswordHashMemory = 4 << 30
+
+// Imported hashes come from any application allowed to write users, and
+// every login attempt against one pays for computing it, so
+// ValidatePasswordHash only accepts costs a login can afford. They are still
+// well above what any of the supported systems uses by default.
+const (
+	maxImportedHashMemory       = 256 << 20
+	maxImportedArgon2Iterations = 10
+	maxImportedScryptP          = 16
+	maxImportedBcryptCost       = 14
+	maxImportedPBKDF2Iterations = 1000000
+)
+
+// phcEncoding is the unpadded standard base64 of PHC strings.
+var phcEncoding = base64.RawStdEncoding
+
+var (
+	passwordHasherOnce sync.Once
+	passwordHasher     PasswordHasher
+)
+
+// CurrentPasswordHasher returns the hasher new passwords are hashed with,
+// chosen with PASSWORD_HASHER (argon2id, the default, scrypt or bcrypt) and
+// PASSWORD_HASH_PARAMS in the parameter syntax of the algorithm's hashes,
+// e.g. "m=65536,t=3,p=4", "ln=16,r=8,p=1" or "cost=12".
+func CurrentPasswordHasher() PasswordHasher {
+	passwordHasherOnce.Do(func() {
+		hasher, err := NewPasswordHasher(os.Getenv("PASSWORD_HASHER"), os.Getenv("PASSWORD_HASH_PARAMS"))
+		if err != nil {
+			log.Printf("invalid password hasher configuration, using argon2id defaults: %v", err)
+			hasher = DefaultArgon2idHasher
+		}
+		passwordHasher = hasher
+	})
+	return passwordHasher
+}
+
+// NewPasswordHasher returns the hasher for an algorithm with the given
+// parameters, the defaults filling in the ones left out.
+func NewPasswordHasher(algorithm, params string) (PasswordHasher, error) {
+	values, err := parsePHCParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	switch algorithm {
+	case "", "argon2id":
+		hasher := *DefaultArgon2idHasher
+		if err := setPHCParams(values, map[string]*int{
+			"m": &hasher.Memory,
+			"t": &hasher.Iterations,
+			"p": &hasher.Parallelism,
+		}); err != nil {
+			return nil, err
+		}
+		return &hasher, hasher.validate()
+	case "scrypt":
+		hasher := *DefaultScryptHasher
+		if err := setPHCParams(values, map[string]*int{
+			"ln": &hasher.LogN,
+			"r":  &hasher.R,
+			"p":  &hasher.P,
+		}); err != nil {
+			return nil, err
+		}
+		return &hasher, hasher.validate()
+	case "bcrypt":
+		hasher := *DefaultBcryptHasher
+		if err := setPHCParams(values, map[string]*int{"cost": &hasher.Cost}); err != nil {
+			return nil, err
+		}
+		return &hasher, hasher.validate()
+	default:
+		return nil, fmt.Errorf("unknown password hasher %q", algorithm)
+	}
+}
+
+// HashPassword hashes a password with the current hasher.
+func HashPassword(password string) (string, error) {
+	return CurrentPasswordHasher().Hash(password)
+}
+
+// VerifyPasswordHash checks a password against a hash of any supported
+// algorithm, imported ones included.
+func VerifyPasswordHash(encoded, password string) error {
+	verifier, err := verifierFor(encoded)
+	if err != nil {
+		return err
+	}
+	return verifier.Verify(encoded, password)
+}
+
+// ValidatePasswordHash checks that a hash is in a supported format with
+// acceptable parameters, so passwords can be verified against it later. The
+// parameters must also stay within the limits of imported hashes.
+func ValidatePasswordHash(encoded string) error {
+	verifier, err := verifierFor(encoded)
+	if err != nil {
+		return err
+	}
+	return verifier.check(encoded)
+}
+
+// PasswordNeedsRehash reports whether a hash should be replaced by one from
+// the current hasher, the next time the plaintext is known.
+func PasswordNeedsRehash(encoded string) bool {
+	return CurrentPasswordHasher().NeedsRehash(encoded)
+}
+
+// passwordVerifier checks passwords against the hashes of one format.
+type passwordVerifier interface {
+	Verify(encoded, password string) error
+	check(encoded string) error
+}
+
+// verifierFor picks the verifier that reads a hash from its identifier.
+func verifierFor(encoded string) (passwordVerifier, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return DefaultArgon2idHasher, nil
+	case strings.HasPrefix(encoded, "$scrypt$"):
+		return DefaultScryptHasher, nil
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return DefaultBcryptHasher, nil
+	default:
+		return importedVerifierFor(encoded)
+	}
+}
+
+// Argon2idHasher hashes with argon2id. Memory is in KiB.
+type Argon2idHasher struct {
+	Memory      int
+	Iterations  int
+	Parallelism int
+}
+
+func (h *Argon2idHasher) validate() error {
+	if h.Iterations < 1 || h.Iterations > 100 || h.Parallelism < 1 || h.Parallelism > 255 ||
+		h.Memory < 8*h.Parallelism || h.Memory > maxPasswordHashMemory>>10 {
+		return errors.New("argon2 needs 1 <= t <= 100, 1 <= p <= 255 and 8*p <= m <= 4194304")
+	}
+	return nil
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt, err := passwordSalt()
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, uint32(h.Iterations), uint32(h.Memory), uint8(h.Parallelism), passwordKeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(encoded, password string) error {
+	params, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return err
+	}
+	computed := argon2.IDKey([]byte(password), salt, uint32(params.Iterations), uint32(params.Memory), uint8(params.Parallelism), uint32(len(key)))
+	return compareKeys(computed, key)
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, _, err := h.decode(encoded)
+	return err != nil || *params != *h
+}
+
+func (h *Argon2idHasher) check(encoded string) error {
+	params, _, _, err := h.decode(encoded)
+	if err != nil {
+		return err
+	}
+	return params.checkImported()
+}
+
+// checkImported checks the parameters of an argon2 hash against the limits of
+// imported hashes.
+func (h *Argon2idHasher) checkImported() error {
+	if h.Memory > maxImportedHashMemory>>10 || h.Iterations > maxImportedArgon2Iterations {
+		return fmt.Errorf("imported argon2 hashes need m <= %d and t <= %d", maxImportedHashMemory>>10, maxImportedArgon2Iterations)
+	}
+	return nil
+}
+
+func (h *Argon2idHasher) decode(encoded string) (*Argon2idHasher, []byte, []byte, error) {
+	return decodeArgon2(encoded, "argon2id")
+}
+
+// decodeArgon2 parses $<variant>$v=19$m=...,t=...,p=...$salt$hash.
+func decodeArgon2(encoded, variant string) (*Argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != variant || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return nil, nil, nil, fmt.Errorf("invalid %s hash", variant)
+	}
+	values, err := parsePHCParams(parts[3])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	params := &Argon2idHasher{}
+	if err := setPHCParams(values, map[string]*int{"m": &params.Memory, "t": &params.Iterations, "p": &params.Parallelism}); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := params.validate(); err != nil {
+		return nil, nil, nil, err
+	}
+	salt, key, err := decodeSaltAndKey(parts[4], parts[5])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return params, salt, key, nil
+}
+
+// ScryptHasher hashes with scrypt, N being 2^LogN.
+type ScryptHasher struct {
+	LogN int
+	R    int
+	P    int
+}
+
+func (h *ScryptHasher) validate() error {
+	if h.LogN < 1 || h.LogN > 30 || h.R < 1 || h.P < 1 || h.R*h.P >= 1<<30 ||
+		128*h.R > maxPasswordHashMemory>>h.LogN {
+		return errors.New("scrypt needs 1 <= ln <= 30, r >= 1, p >= 1, r*p < 2^30 and 128*r*2^ln <= 4 GiB")
+	}
+	return nil
+}
+
+func (h *ScryptHasher) Hash(password string) (string, error) {
+	salt, err := passwordSalt()
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt, 1<<h.LogN, h.R, h.P, passwordKeyLength)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", h.LogN, h.R, h.P,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key)), nil
+}
+
+func (h *ScryptHasher) Verify(encoded, password string) error {
+	params, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return err
+	}
+	computed, err := scrypt.Key([]byte(password), salt, 1<<params.LogN, params.R, params.P, len(key))
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	return compareKeys(computed, key)
+}
+
+func (h *ScryptHasher) NeedsRehash(encoded string) bool {
+	params, _, _, err := h.decode(encoded)
+	return err != nil || *params != *h
+}
+
+func (h *ScryptHasher) check(encoded string) error {
+	params, _, _, err := h.decode(encoded)
+	if err != nil {
+		return err
+	}
+	return params.checkImported()
+}
+
+// checkImported checks the parameters of a scrypt hash against the limits of
+// imported hashes.
+func (h *ScryptHasher) checkImported() error {
+	if 128*h.R > maxImportedHashMemory>>h.LogN || h.P > maxImportedScryptP {
+		return fmt.Errorf("imported scrypt hashes need 128*r*2^ln <= 256 MiB and p <= %d", maxImportedScryptP)
+	}
+	return nil
+}
+
+// decode parses $scrypt$ln=...,r=...,p=...$salt$hash.
+func (h *ScryptHasher) decode(encoded string) (*ScryptHasher, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[1] != "scrypt" {
+		return nil, nil, nil, errors.New("invalid scrypt hash")
+	}
+	values, err := parsePHCParams(parts[2])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	params := &ScryptHasher{}
+	if err := setPHCParams(values, map[string]*int{"ln": &params.LogN, "r": &params.R, "p": &params.P}); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := params.validate(); err != nil {
+		return nil, nil, nil, err
+	}
+	salt, key, err := decodeSaltAndKey(parts[3], parts[4])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return params, salt, key, nil
+}
+
+// BcryptHasher hashes with bcrypt. bcrypt only reads the first 72 bytes of
+// a password and refuses longer ones.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) validate() error {
+	if h.Cost < bcrypt.MinCost || h.Cost > bcrypt.MaxCost {
+		return fmt.Errorf("bcrypt needs %d <= cost <= %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return nil
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(encoded, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+func (h *BcryptHasher) check(encoded string) error {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return err
+	}
+	if cost > maxImportedBcryptCost {
+		return fmt.Errorf("imported bcrypt hashes need cost <= %d", maxImportedBcryptCost)
+	}
+	return nil
+}
+
+func passwordSalt() ([]byte, error) {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return salt, nil
+}
+
+func decodeSaltAndKey(encodedSalt, encodedKey string) ([]byte, []byte, error) {
+	salt, err := phcEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return nil, nil, errors.New("invalid salt in password hash")
+	}
+	key, err := phcEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) == 0 {
+		return nil, nil, errors.New("invalid key in password hash")
+	}
+	return salt, key, nil
+}
+
+func compareKeys(computed, key []byte) error {
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// parsePHCParams parses "name=value,..." parameters into integers.
+func parsePHCParams(params string) (map[string]int, error) {
+	values := make(map[string]int)
+	if params == "" {
+		return values, nil
+	}
+	for _, param := range strings.Split(params, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid hash parameter %q", param)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("hash parameter %s must be an integer", name)
+		}
+		values[name] = n
+	}
+	return values, nil
+}
+
+// setPHCParams copies parsed parameters into the fields of a hasher,
+// refusing parameters the algorithm does not have.
+func setPHCParams(values map[string]int, fields map[string]*int) error {
+	for name, value := range values {
+		field, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("unknown hash parameter %s", name)
+		}
+		*field = value
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Hashes from the reference implementations: the argon2 test suite,
+// RFC 7914 section 12 and the Openwall crypt_blowfish tests
+var knownPasswordHashes = []struct {
+	name     string
+	encoded  string
+	password string
+}{
+	{"argon2id", "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "password"},
+	{"scrypt", "$scrypt$ln=10,r=8,p=16$TmFDbA$/bq+HJ00cgB4VucZDQHp/nxq18vII3gw53N2Y0s3MWIurzDZLiKjiG/xCSedmDDaxyevuUqD7m2DYMvfoswGQA", "password"},
+	{"bcrypt", "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "U*U"},
+}
+
+func TestVerifyPasswordHashKnownHashes(t *testing.T) {
+	for _, tt := range knownPasswordHashes {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyPasswordHash(tt.encoded, tt.password); err != nil {
+				t.Fatalf("VerifyPasswordHash: %v", err)
+			}
+			if err := VerifyPasswordHash(tt.encoded, tt.password+"x"); !errors.Is(err, ErrPasswordMismatch) {
+				t.Fatalf("VerifyPasswordHash with a wrong password = %v, want ErrPasswordMismatch", err)
+			}
+			if err := ValidatePasswordHash(tt.encoded); err != nil {
+				t.Fatalf("ValidatePasswordHash: %v", err)
+			}
+		})
+	}
+}
+
+// Cheap parameters keep the round trips fast
+var testPasswordHashers = []struct {
+	name   string
+	hasher PasswordHasher
+	prefix string
+}{
+	{"argon2id", &Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1}, "$argon2id$v=19$m=64,t=1,p=1$"},
+	{"scrypt", &ScryptHasher{LogN: 4, R: 8, P: 1}, "$scrypt$ln=4,r=8,p=1$"},
+	{"bcrypt", &BcryptHasher{Cost: 4}, "$2a$04$"},
+}
+
+func TestPasswordHasherRoundTrip(t *testing.T) {
+	for _, tt := range testPasswordHashers {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if !strings.HasPrefix(encoded, tt.prefix) {
+				t.Fatalf("Hash = %s, want prefix %s", encoded, tt.prefix)
+			}
+
+			if err := tt.hasher.Verify(encoded, "correct horse"); err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if err := tt.hasher.Verify(encoded, "correct horsE"); !errors.Is(err, ErrPasswordMismatch) {
+				t.Fatalf("Verify with a wrong password = %v, want ErrPasswordMismatch", err)
+			}
+			if err := VerifyPasswordHash(encoded, "correct horse"); err != nil {
+				t.Fatalf("VerifyPasswordHash: %v", err)
+			}
+
+			again, err := tt.hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if again == encoded {
+				t.Fatal("Hash reused the salt")
+			}
+		})
+	}
+}
+
+func TestPasswordHasherNeedsRehash(t *testing.T) {
+	hashes := make(map[string]string)
+	for _, tt := range testPasswordHashers {
+		encoded, err := tt.hasher.Hash("password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[tt.name] = encoded
+	}
+
+	for _, tt := range testPasswordHashers {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, encoded := range hashes {
+				if got, want := tt.hasher.NeedsRehash(encoded), name != tt.name; got != want {
+					t.Errorf("NeedsRehash of a %s hash = %v, want %v", name, got, want)
+				}
+			}
+			if !tt.hasher.NeedsRehash("{SSHA}bQL5TNs2RgAqaAf+lnQNLf3mGBYSNFZ4mrze8A==") {
+				t.Error("NeedsRehash of an imported hash = false")
+			}
+			if !tt.hasher.NeedsRehash("") {
+				t.Error("NeedsRehash of an empty hash = false")
+			}
+		})
+	}
+
+	// The same algorithm with other parameters needs a rehash too
+	changed := []struct {
+		hasher  PasswordHasher
+		encoded string
+	}{
+		{&Argon2idHasher{Memory: 64, Iterations: 2, Parallelism: 1}, hashes["argon2id"]},
+		{&Argon2idHasher{Memory: 128, Iterations: 1, Parallelism: 1}, hashes["argon2id"]},
+		{&ScryptHasher{LogN: 5, R: 8, P: 1}, hashes["scrypt"]},
+		{&BcryptHasher{Cost: 5}, hashes["bcrypt"]},
+	}
+	for _, tt := range changed {
+		if !tt.hasher.NeedsRehash(tt.encoded) {
+			t.Errorf("%+v NeedsRehash(%s) = false", tt.hasher, tt.encoded)
+		}
+	}
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		params    string
+		want      PasswordHasher
+	}{
+		{"", "", DefaultArgon2idHasher},
+		{"argon2id", "m=65536,t=3,p=4", &Argon2idHasher{Memory: 65536, Iterations: 3, Parallelism: 4}},
+		{"argon2id", "t=3", &Argon2idHasher{Memory: 19456, Iterations: 3, Parallelism: 1}},
+		{"scrypt", "", DefaultScryptHasher},
+		{"scrypt", "ln=16, r=8, p=2", &ScryptHasher{LogN: 16, R: 8, P: 2}},
+		{"bcrypt", "cost=12", &BcryptHasher{Cost: 12}},
+	}
+
+	for _, tt := range tests {
+		hasher, err := NewPasswordHasher(tt.algorithm, tt.params)
+		if err != nil {
+			t.Errorf("NewPasswordHasher(%q, %q): %v", tt.algorithm, tt.params, err)
+			continue
+		}
+		if !reflect.DeepEqual(hasher, tt.want) {
+			t.Errorf("NewPasswordHasher(%q, %q) = %+v, want %+v", tt.algorithm, tt.params, hasher, tt.want)
+		}
+	}
+}
+
+func TestNewPasswordHasherErrors(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		params    string
+	}{
+		{"md5", ""},
+		{"argon2id", "m=65536,t=3,x=1"},
+		{"argon2id", "m=lots"},
+		{"argon2id", "m65536"},
+		{"argon2id", "t=0"},
+		{"argon2id", "t=101"},
+		{"argon2id", "p=0"},
+		{"argon2id", "m=4,p=1"},
+		{"argon2id", "m=4194305"},
+		{"scrypt", "ln=0"},
+		{"scrypt", "ln=31"},
+		{"scrypt", "r=0"},
+		{"scrypt", "ln=30,r=8"},
+		{"scrypt", "cost=12"},
+		{"bcrypt", "cost=3"},
+		{"bcrypt", "cost=32"},
+		{"bcrypt", "m=65536"},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewPasswordHasher(tt.algorithm, tt.params); err == nil {
+			t.Errorf("NewPasswordHasher(%q, %q) succeeded", tt.algorithm, tt.params)
+		}
+	}
+}
+
+// Stored hashes with parameters outside the bounds are refused before any
+// work is done, so a bad row or import can't exhaust the server
+func TestValidatePasswordHashErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"empty", ""},
+		{"plaintext", "password"},
+		{"unknown algorithm", "$md5$salt$hash"},
+		{"argon2id without version", "$argon2id$m=64,t=1,p=1$c29tZXNhbHQ$a2V5"},
+		{"argon2id old version", "$argon2id$v=16$m=64,t=1,p=1$c29tZXNhbHQ$a2V5"},
+		{"argon2id huge memory", "$argon2id$v=19$m=8388608,t=1,p=1$c29tZXNhbHQ$a2V5"},
+		{"argon2id huge iterations", "$argon2id$v=19$m=64,t=1000000,p=1$c29tZXNhbHQ$a2V5"},
+		{"argon2id unknown parameter", "$argon2id$v=19$m=64,t=1,p=1,k=2$c29tZXNhbHQ$a2V5"},
+		{"argon2id invalid salt", "$argon2id$v=19$m=64,t=1,p=1$c29t!ZXNhbHQ$a2V5"},
+		{"argon2id empty key", "$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHQ$"},
+		{"argon2id missing field", "$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHQ"},
+		{"scrypt huge N", "$scrypt$ln=40,r=8,p=1$c29tZXNhbHQ$a2V5"},
+		{"scrypt huge memory", "$scrypt$ln=24,r=512,p=1$c29tZXNhbHQ$a2V5"},
+		{"scrypt padded key", "$scrypt$ln=4,r=8,p=1$c29tZXNhbHQ$a2V5=="},
+		{"bcrypt truncated", "$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0Xou"},
+		{"bcrypt huge cost", "$2a$99$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidatePasswordHash(tt.encoded); err == nil {
+				t.Fatal("ValidatePasswordHash succeeded")
+			}
+			if err := VerifyPasswordHash(tt.encoded, "password"); err == nil || errors.Is(err, ErrPasswordMismatch) {
+				t.Fatalf("VerifyPasswordHash = %v, want a format error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/wbrijesh/identity/internal/models"
+)
+
+// Bounds of password policies. bcrypt only uses the first 72 bytes of a
+// password, so longer passwords would be accepted but not fully checked.
+const (
+	MaxPasswordBytes       = 72
+	MaxPasswordHistory     = 24
+	defaultMinPasswordSize = 8
+)
+
+// AdminPasswordPolicy applies to admin passwords, which guard every
+// application of the admin.
+var AdminPasswordPolicy = &models.PasswordPolicy{
+	MinLength:     12,
+	HistoryCount:  5,
+	CheckBreached: true,
+}
+
+// Rules a password can fail, as reported in PasswordViolation.Rule
+const (
+	PasswordRuleMinLength = "min_length"
+	PasswordRuleMaxLength = "max_length"
+	PasswordRuleUppercase = "uppercase"
+	PasswordRuleLowercase = "lowercase"
+	PasswordRuleDigit     = "digit"
+	PasswordRuleSymbol    = "symbol"
+	PasswordRuleHistory   = "history"
+	PasswordRuleBreached  = "breached"
+)
+
+// PasswordViolation is one rule of a password policy a password failed.
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError lists every rule a password failed.
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+	return "password does not meet the policy: " + strings.Join(messages, "; ")
+}
+
+// EffectivePasswordPolicy returns the policy with the defaults filled in.
+func EffectivePasswordPolicy(policy *models.PasswordPolicy) models.PasswordPolicy {
+	var effective models.PasswordPolicy
+	if policy != nil {
+		effective = *policy
+	}
+	if effective.MinLength == 0 {
+		effective.MinLength = defaultMinPasswordSize
+	}
+	if effective.MaxLength == 0 {
+		effective.MaxLength = MaxPasswordBytes
+	}
+	return effective
+}
+
+// ValidatePasswordPolicy checks that the lengths and history count are within
+// bounds, and that breached passwords can be checked when asked for.
+func ValidatePasswordPolicy(policy *models.PasswordPolicy) error {
+	if policy == nil {
+		return nil
+	}
+
+	if policy.MaxLength < 0 || policy.MaxLength > MaxPasswordBytes {
+		return fmt.Errorf("MaxLength must be between 1 and %d", MaxPasswordBytes)
+	}
+	effective := EffectivePasswordPolicy(policy)
+	if policy.MinLength < 0 || effective.MinLength > effective.MaxLength {
+		return fmt.Errorf("MinLength must be between 1 and MaxLength")
+	}
+	if policy.HistoryCount < 0 || policy.HistoryCount > MaxPasswordHistory {
+		return fmt.Errorf("HistoryCount must be between 0 and %d", MaxPasswordHistory)
+	}
+	if policy.CheckBreached && !BreachedPasswordsLoaded() {
+		return fmt.Errorf("CheckBreached needs a breached password list, set BREACHED_PASSWORDS_FILE")
+	}
+
+	return nil
+}
+
+// CheckPassword returns the rules of the policy the password fails, except
+// for the history, which needs the account's previous passwords.
+func CheckPassword(policy *models.PasswordPolicy, password string) []PasswordViolation {
+	effective := EffectivePasswordPolicy(policy)
+	var violations []PasswordViolation
+
+	if length := len([]rune(password)); length < effective.MinLength {
+		violations = append(violations, PasswordViolation{
+			Rule:    PasswordRuleMinLength,
+			Message: fmt.Sprintf("must be at least %d characters", effective.MinLength),
+		})
+	}
+	if len(password) > effective.MaxLength {
+		violations = append(violations, PasswordViolation{
+			Rule:    PasswordRuleMaxLength,
+			Message: fmt.Sprintf("must be at most %d bytes", effective.MaxLength),
+		})
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+	if effective.RequireUppercase && !upper {
+		violations = append(violations, PasswordViolation{Rule: PasswordRuleUppercase, Message: "must contain an uppercase letter"})
+	}
+	if effective.RequireLowercase && !lower {
+		violations = append(violations, PasswordViolation{Rule: PasswordRuleLowercase, Message: "must contain a lowercase letter"})
+	}
+	if effective.RequireDigit && !digit {
+		violations = append(violations, PasswordViolation{Rule: PasswordRuleDigit, Message: "must contain a digit"})
+	}
+	if effective.RequireSymbol && !symbol {
+		violations = append(violations, PasswordViolation{Rule: PasswordRuleSymbol, Message: "must contain a symbol"})
+	}
+
+	if effective.CheckBreached && IsBreachedPassword(password) {
+		violations = append(violations, PasswordViolation{
+			Rule:    PasswordRuleBreached,
+			Message: "appears in a list of breached passwords",
+		})
+	}
+
+	return violations
+}
+
+// HistoryViolation is reported when a password was used recently.
+func HistoryViolation(count int) PasswordViolation {
+	return PasswordViolation{
+		Rule:    PasswordRuleHistory,
+		Message: fmt.Sprintf("must not be one of the last %d passwords", count),
+	}
+}
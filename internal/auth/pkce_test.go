@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+// Example of RFC 7636 Appendix B
+const (
+	rfc7636Verifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	rfc7636Challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		want      bool
+	}{
+		{"RFC 7636 example", rfc7636Verifier, rfc7636Challenge, true},
+		{"plain challenge", rfc7636Verifier, rfc7636Verifier, false},
+		{"other verifier", strings.Replace(rfc7636Verifier, "d", "e", 1), rfc7636Challenge, false},
+		{"padded challenge", rfc7636Verifier, rfc7636Challenge + "=", false},
+		{"verifier too short", rfc7636Verifier[:42], rfc7636Challenge, false},
+		{"verifier too long", strings.Repeat("a", 129), rfc7636Challenge, false},
+		{"verifier with reserved characters", rfc7636Verifier[:42] + "+", rfc7636Challenge, false},
+		{"empty challenge", rfc7636Verifier, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyCodeChallenge(tt.verifier, tt.challenge); got != tt.want {
+				t.Fatalf("VerifyCodeChallenge = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidCodeChallenge(t *testing.T) {
+	tests := []struct {
+		challenge string
+		want      bool
+	}{
+		{rfc7636Challenge, true},
+		{rfc7636Challenge + "=", false},
+		{rfc7636Challenge[:42], false},
+		{strings.Replace(rfc7636Challenge, "-", "+", 1), false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidCodeChallenge(tt.challenge); got != tt.want {
+			t.Errorf("ValidCodeChallenge(%q) = %v, want %v", tt.challenge, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/wbrijesh/identity/internal/models"
+)
+
+// Bounds for the lifetimes an application can choose
+const (
+	minUserTokenTTL    = time.Minute
+	maxUserTokenTTL    = 24 * time.Hour
+	minRefreshTokenTTL = time.Hour
+	maxRefreshTokenTTL = 90 * 24 * time.Hour
+	minSessionTTL      = time.Hour
+	maxSessionTTL      = 365 * 24 * time.Hour
+)
+
+// Policy is the token policy of an application with the defaults filled in.
+type Policy struct {
+	UserTokenTTL     time.Duration
+	RefreshTokenTTL  time.Duration
+	SessionTTL       time.Duration
+	SigningAlgorithm string
+	Issuer           string
+}
+
+// PolicyResolver looks up the token policy of an application. A nil policy
+// means the application uses the defaults.
+type PolicyResolver func(applicationID string) (*models.TokenPolicy, error)
+
+var policyResolver PolicyResolver
+
+// SetPolicyResolver sets how the generators and validators find the token
+// policy of an application. Without a resolver every application uses the
+// defaults.
+func SetPolicyResolver(resolver PolicyResolver) {
+	policyResolver = resolver
+}
+
+// ApplicationPolicy returns the token policy of an application.
+func ApplicationPolicy(applicationID string) (*Policy, error) {
+	var tokenPolicy *models.TokenPolicy
+	if policyResolver != nil {
+		var err error
+		tokenPolicy, err = policyResolver(applicationID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load token policy: %w", err)
+		}
+	}
+	return effectivePolicy(tokenPolicy), nil
+}
+
+func effectivePolicy(tokenPolicy *models.TokenPolicy) *Policy {
+	policy := &Policy{
+		UserTokenTTL:    UserTokenTTL,
+		RefreshTokenTTL: RefreshTokenTTL,
+		SessionTTL:      SessionTTL,
+		Issuer:          Issuer(),
+	}
+	if tokenPolicy == nil {
+		return policy
+	}
+
+	if tokenPolicy.UserTokenTTLSeconds > 0 {
+		policy.UserTokenTTL = time.Duration(tokenPolicy.UserTokenTTLSeconds) * time.Second
+	}
+	if tokenPolicy.RefreshTokenTTLSeconds > 0 {
+		policy.RefreshTokenTTL = time.Duration(tokenPolicy.RefreshTokenTTLSeconds) * time.Second
+	}
+	if tokenPolicy.SessionLifetimeSeconds > 0 {
+		policy.SessionTTL = time.Duration(tokenPolicy.SessionLifetimeSeconds) * time.Second
+	}
+	policy.SigningAlgorithm = tokenPolicy.SigningAlgorithm
+	if tokenPolicy.Issuer != "" {
+		policy.Issuer = tokenPolicy.Issuer
+	}
+	return policy
+}
+
+// ValidateTokenPolicy checks that the lifetimes are within bounds, that the
+// jwt key ring has an active key for the signing algorithm and that the
+// issuer is an absolute https URL.
+func ValidateTokenPolicy(tokenPolicy *models.TokenPolicy) error {
+	if tokenPolicy == nil {
+		return nil
+	}
+
+	if err := checkTTL("UserTokenTTLSeconds", tokenPolicy.UserTokenTTLSeconds, minUserTokenTTL, maxUserTokenTTL); err != nil {
+		return err
+	}
+	if err := checkTTL("RefreshTokenTTLSeconds", tokenPolicy.RefreshTokenTTLSeconds, minRefreshTokenTTL, maxRefreshTokenTTL); err != nil {
+		return err
+	}
+	if err := checkTTL("SessionLifetimeSeconds", tokenPolicy.SessionLifetimeSeconds, minSessionTTL, maxSessionTTL); err != nil {
+		return err
+	}
+
+	if tokenPolicy.SigningAlgorithm != "" {
+		if _, err := signingKey(jwtKeyRing, tokenPolicy.SigningAlgorithm); err != nil {
+			return fmt.Errorf("SigningAlgorithm %s is not available: %w", tokenPolicy.SigningAlgorithm, err)
+		}
+	}
+
+	if tokenPolicy.Issuer != "" {
+		issuer, err := url.Parse(tokenPolicy.Issuer)
+		if err != nil || issuer.Scheme != "https" || issuer.Host == "" || issuer.RawQuery != "" || issuer.Fragment != "" {
+			return fmt.Errorf("Issuer must be an https URL without query or fragment")
+		}
+	}
+
+	return nil
+}
+
+func checkTTL(name string, seconds int, min, max time.Duration) error {
+	if seconds == 0 {
+		return nil
+	}
+	ttl := time.Duration(seconds) * time.Second
+	if seconds < 0 || ttl < min || ttl > max {
+		return fmt.Errorf("%s must be between %d and %d", name, int(min.Seconds()), int(max.Seconds()))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// Number of recovery codes handed out at a time and their length. Each code
+// carries 80 random bits, so a fast hash is sufficient to store them.
+const (
+	RecoveryCodeCount  = 10
+	recoveryCodeLength = 16
+)
+
+// GenerateRecoveryCodes returns a new set of recovery codes, formatted in
+// groups of four characters, and the hashes to store in their place.
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, recoveryCodeLength*5/8)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		code := totpEncoding.EncodeToString(buf)
+
+		groups := make([]string, 0, recoveryCodeLength/4)
+		for j := 0; j < len(code); j += 4 {
+			groups = append(groups, code[j:j+4])
+		}
+		codes[i] = strings.Join(groups, "-")
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code for storage and lookup. Dashes,
+// spaces and case are ignored, so codes can be typed the way they read.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return HashOpaqueToken(normalized)
+}
@@ -1,40 +1,90 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var refreshSecretKey = []byte("my_refresh_secret_key")
+// Lifetime of application access tokens and default lifetime of application
+// refresh tokens
+const (
+	RefreshTokenTTL = 7 * 24 * time.Hour
+	AccessTokenTTL  = 15 * time.Minute
+)
+
+// Length of the part of a refresh token kept for display
+const refreshTokenPrefixLength = 8
 
+// Default time a rotated refresh token keeps working
+const defaultRefreshTokenGracePeriod = 30 * time.Second
+
+// RefreshTokenClaims identify the application credential a refresh token
+// belongs to. The scopes live on the credential so the admin can change them
+// without reissuing the token. Tokens issued before applications had
+// credentials have no CredentialID, they are found by their hash on the
+// credential they were migrated to.
 type RefreshTokenClaims struct {
 	ApplicationID string `json:"app_id"`
+	CredentialID  string `json:"cid"`
+	Family        string `json:"fam"`
 	jwt.RegisteredClaims
 }
 
-var accessSecretKey = []byte("my_access_secret_key")
-
+// AccessTokenClaims carry the space separated scopes granted to the access
+// token, the scopes of its credential or fewer.
 type AccessTokenClaims struct {
 	ApplicationID string `json:"app_id"`
+	CredentialID  string `json:"cid"`
+	Scope         string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateRefreshToken(applicationID string) (string, error) {
+// RefreshTokenGracePeriod returns how long the previous refresh token stays
+// valid after a rotation, read from REFRESH_TOKEN_GRACE_PERIOD (e.g. "30s").
+func RefreshTokenGracePeriod() time.Duration {
+	if value := os.Getenv("REFRESH_TOKEN_GRACE_PERIOD"); value != "" {
+		if gracePeriod, err := time.ParseDuration(value); err == nil && gracePeriod >= 0 {
+			return gracePeriod
+		}
+		log.Printf("invalid REFRESH_TOKEN_GRACE_PERIOD %q, using %s", value, defaultRefreshTokenGracePeriod)
+	}
+	return defaultRefreshTokenGracePeriod
+}
+
+// GenerateRefreshToken issues a refresh token for an application credential.
+// The token expires after the refresh token TTL of the application's token
+// policy or at notAfter, whichever is first.
+func GenerateRefreshToken(applicationID, credentialID, family string, notAfter *time.Time) (string, error) {
+	policy, err := ApplicationPolicy(applicationID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(policy.RefreshTokenTTL)
+	if notAfter != nil && notAfter.Before(expiresAt) {
+		expiresAt = *notAfter
+	}
+
 	claims := RefreshTokenClaims{
 		ApplicationID: applicationID,
+		CredentialID:  credentialID,
+		Family:        family,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour * 7)), // Valid for 7 days
+			ID:        newTokenID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 		},
 	}
 
-	// Create a new token object
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// Sign the token with the refresh secret key
-	refreshToken, err := token.SignedString(refreshSecretKey)
+	// Sign the token with the active refresh key
+	refreshToken, err := sign(refreshKeyRing, claims)
 	if err != nil {
 		return "", err
 	}
@@ -42,34 +92,23 @@ func GenerateRefreshToken(applicationID string) (string, error) {
 	return refreshToken, nil
 }
 
-func GenerateAccessTokenFromRefreshToken(refreshToken string) (string, error) {
-	// Parse and validate the refresh token
-	token, err := jwt.ParseWithClaims(refreshToken, &RefreshTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return refreshSecretKey, nil
-	})
-	if err != nil || !token.Valid {
-		return "", fmt.Errorf("invalid refresh token")
-	}
-
-	// Extract the application ID from the refresh token
-	claims, ok := token.Claims.(*RefreshTokenClaims)
-	if !ok {
-		return "", fmt.Errorf("invalid token claims")
-	}
-
-	// Create a new access token with a shorter expiry time (e.g., 15 minutes)
+// GenerateAccessToken issues a short lived access token for an application
+// credential with the given scopes.
+func GenerateAccessToken(applicationID, credentialID string, scopes []string) (string, error) {
+	now := time.Now()
 	accessTokenClaims := AccessTokenClaims{
-		ApplicationID: claims.ApplicationID,
+		ApplicationID: applicationID,
+		CredentialID:  credentialID,
+		Scope:         FormatScope(scopes),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
+			ID:        newTokenID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
 		},
 	}
 
-	// Create a new token object
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims)
-
-	// Sign the token with the access secret key
-	signedAccessToken, err := accessToken.SignedString(accessSecretKey)
+	// Sign the token with the active access key
+	signedAccessToken, err := sign(accessKeyRing, accessTokenClaims)
 	if err != nil {
 		return "", err
 	}
@@ -77,45 +116,89 @@ func GenerateAccessTokenFromRefreshToken(refreshToken string) (string, error) {
 	return signedAccessToken, nil
 }
 
-func ValidateAccessToken(accessToken string) (string, error) {
-	// Parse and validate the access token
-	token, err := jwt.ParseWithClaims(accessToken, &AccessTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return accessSecretKey, nil
-	})
+// RefreshTokenPrefix returns a short part of a refresh token to identify it in
+// listings without revealing it. Every refresh token starts with the same JWT
+// header, so the part is taken from the start of the signature.
+func RefreshTokenPrefix(refreshToken string) string {
+	signature := refreshToken[strings.LastIndex(refreshToken, ".")+1:]
+	if len(signature) > refreshTokenPrefixLength {
+		signature = signature[:refreshTokenPrefixLength]
+	}
+	return signature
+}
+
+func ValidateRefreshToken(refreshToken string) (*RefreshTokenClaims, error) {
+	// Parse and validate the refresh token
+	token, err := jwt.ParseWithClaims(refreshToken, &RefreshTokenClaims{}, keyfunc(refreshKeyRing))
 	if err != nil || !token.Valid {
-		return "", fmt.Errorf("invalid access token")
+		return nil, fmt.Errorf("invalid refresh token")
 	}
 
-	// Extract the application ID from the access token
-	claims, ok := token.Claims.(*AccessTokenClaims)
-	if !ok {
-		return "", fmt.Errorf("invalid token claims")
+	claims, ok := token.Claims.(*RefreshTokenClaims)
+	if !ok || claims.ApplicationID == "" {
+		return nil, fmt.Errorf("invalid token claims")
 	}
 
-	// Return the application ID
-	return claims.ApplicationID, nil
+	return claims, nil
 }
 
-func TestRefreshTokens() {
-	// Generate a refresh token
-	refreshToken, err := GenerateRefreshToken("app_12345")
-	if err != nil {
-		log.Fatal("Error generating refresh token:", err)
+// Info returns the identity of the refresh token for revocation checks.
+func (c *RefreshTokenClaims) Info() (*TokenInfo, error) {
+	if c.CredentialID == "" {
+		return c.legacyInfo()
 	}
-	fmt.Println("Refresh Token:", refreshToken)
 
-	// Generate an access token from the refresh token
-	accessToken, err := GenerateAccessTokenFromRefreshToken(refreshToken)
+	info, err := tokenInfo(TokenTypeRefresh, c.ApplicationID, c.ApplicationID, &c.RegisteredClaims)
 	if err != nil {
-		log.Fatal("Error generating access token:", err)
+		return nil, err
 	}
-	fmt.Println("Access Token:", accessToken)
+	info.CredentialID = c.CredentialID
+	return info, nil
+}
 
-	// Validate the access token and get the application ID
-	appID, err := ValidateAccessToken(accessToken)
-	if err != nil {
-		log.Fatal("Error validating access token:", err)
+// legacyInfo returns the identity of a refresh token issued before
+// applications had credentials. The oldest have no iat or jti: they count as
+// issued at the epoch, so any revocation of their application covers them,
+// and cannot be revoked by jti.
+func (c *RefreshTokenClaims) legacyInfo() (*TokenInfo, error) {
+	if c.ExpiresAt == nil {
+		return nil, errors.New("token has no exp claim")
+	}
 
+	info := &TokenInfo{
+		Type:          TokenTypeRefresh,
+		ID:            c.ID,
+		Issuer:        c.Issuer,
+		Subject:       c.ApplicationID,
+		ApplicationID: c.ApplicationID,
+		IssuedAt:      time.Unix(0, 0),
+		ExpiresAt:     c.ExpiresAt.Time,
+	}
+	if c.IssuedAt != nil {
+		info.IssuedAt = c.IssuedAt.Time
+	}
+	return info, nil
+}
+
+func ValidateAccessToken(accessToken string) (*TokenInfo, error) {
+	// Parse and validate the access token
+	token, err := jwt.ParseWithClaims(accessToken, &AccessTokenClaims{}, keyfunc(accessKeyRing))
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid access token")
+	}
+
+	// Extract the application ID from the access token
+	claims, ok := token.Claims.(*AccessTokenClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	// The application is the subject of its access tokens
+	info, err := tokenInfo(TokenTypeAccess, claims.ApplicationID, claims.ApplicationID, &claims.RegisteredClaims)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("Valid Access Token belongs to Application ID:", appID)
+	info.CredentialID = claims.CredentialID
+	info.Scope = claims.Scope
+	return info, nil
 }
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scopes an admin can grant to application credentials. Each route an
+// application can call requires one of them.
+const (
+	ScopeUsersRead      = "users:read"
+	ScopeUsersWrite     = "users:write"
+	ScopeUsersLogin     = "users:login"
+	ScopeSessionsRead   = "sessions:read"
+	ScopeSessionsRevoke = "sessions:revoke"
+)
+
+// ApplicationScopes lists every scope that can be granted to an application
+var ApplicationScopes = []string{
+	ScopeUsersRead,
+	ScopeUsersWrite,
+	ScopeUsersLogin,
+	ScopeSessionsRead,
+	ScopeSessionsRevoke,
+}
+
+// ValidateScopes returns an error naming the first scope that is not an
+// application scope.
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !containsScope(ApplicationScopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// ParseScope splits a space separated scope claim or parameter.
+func ParseScope(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// FormatScope joins scopes into a space separated scope claim.
+func FormatScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// ScopesGranted reports whether every requested scope is among the granted
+// ones.
+func ScopesGranted(requested, granted []string) bool {
+	for _, scope := range requested {
+		if !containsScope(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Encrypted secrets are stored as enc:<kid>:<base64url nonce and ciphertext>.
+// Base32 TOTP secrets stored before encryption never contain a colon.
+const encryptedSecretPrefix = "enc:"
+
+// EncryptSecret encrypts a secret that has to be read back, such as a TOTP
+// secret, with AES-256-GCM under the active key of the secret ring. The
+// secret is bound to associatedData, the ID of its owner, so it cannot be
+// copied to another account.
+func EncryptSecret(secret, associatedData string) (string, error) {
+	ring, err := keyRing(secretKeyRing)
+	if err != nil {
+		return "", err
+	}
+	return encryptSecret(ring, secret, associatedData)
+}
+
+// DecryptSecret decrypts a secret stored by EncryptSecret with the key it
+// names, which may be any key of the secret ring that is not retired.
+func DecryptSecret(encrypted, associatedData string) (string, error) {
+	ring, err := keyRing(secretKeyRing)
+	if err != nil {
+		return "", err
+	}
+	return decryptSecret(ring, encrypted, associatedData)
+}
+
+// ReencryptSecret returns the secret encrypted under the active key of the
+// secret ring, or an empty string when it already is. Secrets stored in
+// plaintext before they were encrypted are accepted as well.
+func ReencryptSecret(stored, associatedData string) (string, error) {
+	ring, err := keyRing(secretKeyRing)
+	if err != nil {
+		return "", err
+	}
+
+	secret := stored
+	if kid, _, ok := splitEncryptedSecret(stored); ok {
+		if kid == ring.active.ID {
+			return "", nil
+		}
+		if secret, err = decryptSecret(ring, stored, associatedData); err != nil {
+			return "", err
+		}
+	}
+	return encryptSecret(ring, secret, associatedData)
+}
+
+func encryptSecret(ring *KeyRing, secret, associatedData string) (string, error) {
+	aead, err := ring.active.secretCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(secret)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), []byte(associatedData))
+
+	return encryptedSecretPrefix + ring.active.ID + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSecret(ring *KeyRing, encrypted, associatedData string) (string, error) {
+	kid, data, ok := splitEncryptedSecret(encrypted)
+	if !ok {
+		return "", errors.New("secret is not encrypted")
+	}
+
+	key, ok := ring.keys[kid]
+	if !ok {
+		return "", fmt.Errorf("unknown secret key %s", kid)
+	}
+	if key.Status == KeyStatusRetired {
+		return "", fmt.Errorf("secret key %s is retired", kid)
+	}
+	aead, err := key.secretCipher()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted secret")
+	}
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(associatedData))
+	if err != nil {
+		return "", errors.New("failed to decrypt secret")
+	}
+	return string(secret), nil
+}
+
+// splitEncryptedSecret returns the key ID and the encoded nonce and
+// ciphertext of an encrypted secret. Key IDs from a key file may contain
+// colons, the encoded data cannot.
+func splitEncryptedSecret(encrypted string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(encrypted, encryptedSecretPrefix)
+	if !ok {
+		return "", "", false
+	}
+	separator := strings.LastIndex(rest, ":")
+	if separator < 0 {
+		return "", "", false
+	}
+	return rest[:separator], rest[separator+1:], true
+}
+
+// secretCipher derives the AES-256 key of a secret ring key from its
+// configured secret, which may be any string of at least minSecretLength
+// bytes.
+func (k *Key) secretCipher() (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, []byte(k.Secret))
+	mac.Write([]byte("identity secret encryption"))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
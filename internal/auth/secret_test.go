@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func testSecretRing(t *testing.T, keys ...*Key) *KeyRing {
+	t.Helper()
+	ring, err := newKeyRing(secretKeyRing, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
+func TestEncryptSecret(t *testing.T) {
+	ring := testSecretRing(t, &Key{ID: "2024-10", Secret: strings.Repeat("a", 32), Status: KeyStatusActive})
+
+	encrypted, err := encryptSecret(ring, rfc6238Secret, "user_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, "enc:2024-10:") || strings.Contains(encrypted, rfc6238Secret) {
+		t.Fatalf("encryptSecret = %s", encrypted)
+	}
+
+	again, err := encryptSecret(ring, rfc6238Secret, "user_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == encrypted {
+		t.Error("encrypting twice gave the same ciphertext")
+	}
+
+	secret, err := decryptSecret(ring, encrypted, "user_1")
+	if err != nil || secret != rfc6238Secret {
+		t.Errorf("decryptSecret = %q, %v, want %q", secret, err, rfc6238Secret)
+	}
+	if _, err := decryptSecret(ring, encrypted, "user_2"); err == nil {
+		t.Error("decryptSecret accepted the secret of another account")
+	}
+}
+
+func TestDecryptSecretRotation(t *testing.T) {
+	old := &Key{ID: "2024-09", Secret: strings.Repeat("b", 32), Status: KeyStatusActive}
+	encrypted, err := encryptSecret(testSecretRing(t, old), rfc6238Secret, "admin_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := &Key{ID: "2024-10", Secret: strings.Repeat("a", 32), Status: KeyStatusActive}
+	verify := &Key{ID: "2024-09", Secret: strings.Repeat("b", 32), Status: KeyStatusVerify}
+	if secret, err := decryptSecret(testSecretRing(t, current, verify), encrypted, "admin_1"); err != nil || secret != rfc6238Secret {
+		t.Errorf("decryptSecret with a verify key = %q, %v", secret, err)
+	}
+
+	retired := &Key{ID: "2024-09", Status: KeyStatusRetired}
+	if _, err := decryptSecret(testSecretRing(t, current, retired), encrypted, "admin_1"); err == nil {
+		t.Error("decryptSecret accepted a retired key")
+	}
+	if _, err := decryptSecret(testSecretRing(t, current), encrypted, "admin_1"); err == nil {
+		t.Error("decryptSecret accepted an unknown key")
+	}
+}
+
+func TestDecryptSecretErrors(t *testing.T) {
+	ring := testSecretRing(t, &Key{ID: "2024-10", Secret: strings.Repeat("a", 32), Status: KeyStatusActive})
+	encrypted, err := encryptSecret(ring, rfc6238Secret, "user_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := []byte(encrypted)
+	tampered[len(tampered)-10] ^= 1
+
+	for name, value := range map[string]string{
+		"plaintext":    rfc6238Secret,
+		"no data":      "enc:2024-10",
+		"bad encoding": "enc:2024-10:!!!",
+		"too short":    "enc:2024-10:AAAA",
+		"tampered":     string(tampered),
+	} {
+		if _, err := decryptSecret(ring, value, "user_1"); err == nil {
+			t.Errorf("%s: decryptSecret(%q) succeeded", name, value)
+		}
+	}
+}
+
+func TestSecretKeyRingRejectsAsymmetricKeys(t *testing.T) {
+	_, err := newKeyRing(secretKeyRing, []*Key{{ID: "2024-10", Algorithm: AlgorithmES256, Status: KeyStatusActive}})
+	if err == nil {
+		t.Error("newKeyRing accepted an ES256 key in the secret ring")
+	}
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"strings"
+	"time"
+)
+
+// Default absolute lifetime of a user session, after which the user has to
+// log in again regardless of how often the refresh token was used.
+const SessionTTL = 30 * 24 * time.Hour
+
+// Session refresh tokens are opaque, the prefix tells them apart from the
+// JWT refresh tokens issued to applications.
+const sessionRefreshTokenPrefix = "srt_"
+
+// GenerateSessionRefreshToken returns a new refresh token for a user session
+// and the hash to store on the session. The token embeds the session ID so a
+// token that was already rotated out can be traced back to its session.
+func GenerateSessionRefreshToken(sessionID string) (string, string, error) {
+	secret, _, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	token := sessionRefreshTokenPrefix + sessionID + "." + secret
+	return token, HashOpaqueToken(token), nil
+}
+
+func IsSessionRefreshToken(token string) bool {
+	return strings.HasPrefix(token, sessionRefreshTokenPrefix)
+}
+
+// ParseSessionRefreshToken returns the ID of the session a refresh token was
+// issued for.
+func ParseSessionRefreshToken(token string) (string, bool) {
+	sessionID, secret, found := strings.Cut(strings.TrimPrefix(token, sessionRefreshTokenPrefix), ".")
+	if !IsSessionRefreshToken(token) || !found || sessionID == "" || secret == "" {
+		return "", false
+	}
+	return sessionID, true
+}
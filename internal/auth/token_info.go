@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token types issued by the auth package
+const (
+	TokenTypeAdmin   = "admin"
+	TokenTypeUser    = "user"
+	TokenTypeID      = "id_token"
+	TokenTypeAccess  = "access_token"
+	TokenTypeRefresh = "refresh_token"
+)
+
+// TokenInfo identifies a validated token. ID is the jti claim, used to revoke
+// a single token, and Subject with IssuedAt is used to revoke every token of
+// a subject issued before a point in time. Scope is the space separated list
+// of scopes granted to the token and CredentialID the application credential
+// it was issued for, if any. Issuer is empty for tokens without an iss claim.
+type TokenInfo struct {
+	Type          string
+	ID            string
+	Issuer        string
+	Subject       string
+	ApplicationID string
+	CredentialID  string
+	Scope         string
+	IssuedAt      time.Time
+	ExpiresAt     time.Time
+}
+
+// newTokenID returns a random jti for a new token.
+func newTokenID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic("failed to read random bytes: " + err.Error())
+	}
+	return hex.EncodeToString(buf)
+}
+
+func tokenInfo(tokenType, subject, applicationID string, claims *jwt.RegisteredClaims) (*TokenInfo, error) {
+	if claims.IssuedAt == nil {
+		return nil, errors.New("token has no iat claim")
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("token has no exp claim")
+	}
+	if claims.ID == "" {
+		return nil, errors.New("token has no jti claim")
+	}
+
+	return &TokenInfo{
+		Type:          tokenType,
+		ID:            claims.ID,
+		Issuer:        claims.Issuer,
+		Subject:       subject,
+		ApplicationID: applicationID,
+		IssuedAt:      claims.IssuedAt.Time,
+		ExpiresAt:     claims.ExpiresAt.Time,
+	}, nil
+}
+
+// ParseToken validates a token of any type issued by the auth package.
+func ParseToken(tokenString string) (*TokenInfo, error) {
+	if info, err := ValidateAccessToken(tokenString); err == nil {
+		return info, nil
+	}
+	if claims, err := ValidateUserJWT(tokenString); err == nil {
+		return claims.Info()
+	}
+	if claims, err := ValidateAdminJWT(tokenString); err == nil {
+		return claims.Info()
+	}
+	if claims, err := ValidateRefreshToken(tokenString); err == nil {
+		return claims.Info()
+	}
+	if info, err := ValidateIDToken(tokenString); err == nil {
+		return info, nil
+	}
+	return nil, errors.New("invalid token")
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Base32 of the SHA-1 seed of RFC 6238 Appendix B, "12345678901234567890"
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// Test vectors of RFC 6238 Appendix B for SHA-1. The RFC lists 8-digit
+// codes, the 6-digit codes are their last six digits.
+var rfc6238Vectors = []struct {
+	unix    int64
+	counter int64
+	code    string
+}{
+	{59, 0x1, "287082"},
+	{1111111109, 0x23523ec, "081804"},
+	{1111111111, 0x23523ed, "050471"},
+	{1234567890, 0x273ef07, "005924"},
+	{2000000000, 0x3f940aa, "279037"},
+	{20000000000, 0x27bc86aa, "353130"},
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range rfc6238Vectors {
+		if got := totpCode(key, v.counter); got != v.code {
+			t.Errorf("totpCode at %d = %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestVerifyTOTPRFC6238(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		counter, ok := VerifyTOTP(rfc6238Secret, v.code, time.Unix(v.unix, 0), 0)
+		if !ok || counter != v.counter {
+			t.Errorf("VerifyTOTP at %d = %d, %v, want %d", v.unix, counter, ok, v.counter)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	// 287082 is the code of step 1, seconds 30 to 59
+	tests := []struct {
+		name        string
+		secret      string
+		code        string
+		unix        int64
+		lastCounter int64
+		want        bool
+	}{
+		{"current step", rfc6238Secret, "287082", 45, 0, true},
+		{"one step early", rfc6238Secret, "287082", 29, 0, true},
+		{"one step late", rfc6238Secret, "287082", 60 + 29, 0, true},
+		{"two steps late", rfc6238Secret, "287082", 90, 0, false},
+		{"lowercase secret", strings.ToLower(rfc6238Secret), "287082", 45, 0, true},
+		{"already used step", rfc6238Secret, "287082", 45, 1, false},
+		{"later step used", rfc6238Secret, "287082", 45, 2, false},
+		{"wrong code", rfc6238Secret, "287083", 45, 0, false},
+		{"8 digits", rfc6238Secret, "94287082", 45, 0, false},
+		{"5 digits", rfc6238Secret, "87082", 45, 0, false},
+		{"invalid secret", "not base32!", "287082", 45, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, ok := VerifyTOTP(tt.secret, tt.code, time.Unix(tt.unix, 0), tt.lastCounter)
+			if ok != tt.want {
+				t.Fatalf("VerifyTOTP = %d, %v, want %v", counter, ok, tt.want)
+			}
+			if ok && counter != 1 {
+				t.Fatalf("VerifyTOTP matched step %d, want 1", counter)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != totpSecretSize {
+		t.Fatalf("secret %q decodes to %d bytes, %v", secret, len(key), err)
+	}
+
+	now := time.Now()
+	code := totpCode(key, now.Unix()/int64(totpPeriod.Seconds()))
+	if _, ok := VerifyTOTP(secret, code, now, 0); !ok {
+		t.Fatal("VerifyTOTP rejected the current code")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	got := TOTPProvisioningURI("My App", "ada@example.com", rfc6238Secret)
+	want := "otpauth://totp/My%20App:ada@example.com?algorithm=SHA1&digits=6&issuer=My%20App&period=30&secret=" + rfc6238Secret
+	if got != want {
+		t.Fatalf("TOTPProvisioningURI = %s, want %s", got, want)
+	}
+}
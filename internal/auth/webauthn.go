@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// WebAuthnChallengeTTL is how long a registration or login ceremony may take,
+// and the timeout passed to the browser.
+const WebAuthnChallengeTTL = 5 * time.Minute
+
+// Client data types of the two ceremonies
+const (
+	WebAuthnCeremonyRegistration = "webauthn.create"
+	WebAuthnCeremonyLogin        = "webauthn.get"
+)
+
+// Authenticator data flags
+const (
+	authDataUserPresent        = 0x01
+	authDataUserVerified       = 0x04
+	authDataAttestedCredential = 0x40
+)
+
+// Authenticator data starts with the RP ID hash, the flags and the signature
+// counter
+const authDataMinLength = 32 + 1 + 4
+
+// ClientData is the part of the client data JSON the server checks.
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// WebAuthnRegistration is a credential created by a registration ceremony.
+// PublicKey is the COSE key as sent by the authenticator.
+type WebAuthnRegistration struct {
+	CredentialID []byte
+	PublicKey    []byte
+	Algorithm    int
+	SignCount    uint32
+}
+
+// DecodeWebAuthnBase64 decodes the base64url values of the WebAuthn JSON
+// encoding, with or without padding.
+func DecodeWebAuthnBase64(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// ParseClientData decodes the client data JSON of a ceremony, so its
+// challenge can be looked up before the response is verified.
+func ParseClientData(clientDataJSON []byte) (*ClientData, error) {
+	var clientData ClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return nil, errors.New("invalid client data")
+	}
+	if clientData.Challenge == "" {
+		return nil, errors.New("client data has no challenge")
+	}
+	return &clientData, nil
+}
+
+func (c *ClientData) verify(ceremony string, origins []string) error {
+	if c.Type != ceremony {
+		return fmt.Errorf("client data type must be %s", ceremony)
+	}
+	if !slices.Contains(origins, c.Origin) {
+		return fmt.Errorf("origin %s is not allowed", c.Origin)
+	}
+	if c.CrossOrigin {
+		return errors.New("cross origin ceremonies are not allowed")
+	}
+	return nil
+}
+
+// VerifyRegistration checks the response of a registration ceremony and
+// returns the new credential. The caller has already matched the challenge.
+// Attestation statements are not verified, registration asks for no
+// attestation and the authenticator's model is not restricted.
+func VerifyRegistration(rpID string, origins []string, clientDataJSON, attestationObject []byte) (*WebAuthnRegistration, error) {
+	clientData, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	if err := clientData.verify(WebAuthnCeremonyRegistration, origins); err != nil {
+		return nil, err
+	}
+
+	item, rest, err := decodeCBOR(attestationObject)
+	if err != nil || len(rest) != 0 {
+		return nil, errors.New("invalid attestation object")
+	}
+	attestation, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid attestation object")
+	}
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object has no authenticator data")
+	}
+
+	signCount, err := verifyAuthenticatorData(rpID, authData)
+	if err != nil {
+		return nil, err
+	}
+	if authData[32]&authDataAttestedCredential == 0 {
+		return nil, errors.New("authenticator data has no attested credential")
+	}
+
+	// Attested credential data: AAGUID, credential ID length and ID, then the
+	// COSE key
+	credentialData := authData[authDataMinLength:]
+	if len(credentialData) < 18 {
+		return nil, errors.New("attested credential data is truncated")
+	}
+	idLength := int(binary.BigEndian.Uint16(credentialData[16:18]))
+	credentialData = credentialData[18:]
+	if idLength == 0 || idLength > 1023 || len(credentialData) < idLength {
+		return nil, errors.New("invalid credential ID")
+	}
+	credentialID := credentialData[:idLength]
+
+	keyItem, extensions, err := decodeCBOR(credentialData[idLength:])
+	if err != nil {
+		return nil, errors.New("invalid credential public key")
+	}
+	if _, ok := keyItem.(map[interface{}]interface{}); !ok {
+		return nil, errors.New("invalid credential public key")
+	}
+	publicKey := credentialData[idLength : len(credentialData)-len(extensions)]
+	_, algorithm, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebAuthnRegistration{
+		CredentialID: append([]byte(nil), credentialID...),
+		PublicKey:    append([]byte(nil), publicKey...),
+		Algorithm:    algorithm,
+		SignCount:    signCount,
+	}, nil
+}
+
+// VerifyAssertion checks the response of a login ceremony against the stored
+// public key of the credential and returns the authenticator's new signature
+// counter. The caller has already matched the challenge.
+func VerifyAssertion(rpID string, origins []string, publicKey, clientDataJSON, authData, signature []byte) (uint32, error) {
+	clientData, err := ParseClientData(clientDataJSON)
+	if err != nil {
+		return 0, err
+	}
+	if err := clientData.verify(WebAuthnCeremonyLogin, origins); err != nil {
+		return 0, err
+	}
+
+	signCount, err := verifyAuthenticatorData(rpID, authData)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+	if err := verifyCOSESignature(publicKey, signed, signature); err != nil {
+		return 0, err
+	}
+
+	return signCount, nil
+}
+
+// verifyAuthenticatorData checks that the authenticator data is for the RP
+// ID and that the user was present and verified, and returns the signature
+// counter. Passkeys replace the password, so user verification is required.
+func verifyAuthenticatorData(rpID string, authData []byte) (uint32, error) {
+	if len(authData) < authDataMinLength {
+		return 0, errors.New("authenticator data is truncated")
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(authData[:32], rpIDHash[:]) {
+		return 0, errors.New("authenticator data is for another relying party")
+	}
+
+	flags := authData[32]
+	if flags&authDataUserPresent == 0 {
+		return 0, errors.New("user was not present")
+	}
+	if flags&authDataUserVerified == 0 {
+		return 0, errors.New("user was not verified")
+	}
+
+	return binary.BigEndian.Uint32(authData[33:37]), nil
+}
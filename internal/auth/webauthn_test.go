@@ -0,0 +1,398 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Responses of real authenticators, from the go-webauthn test suite
+const (
+	// Packed self attestation by macOS for localhost, with user verification
+	macOSClientData        = "eyJjaGFsbGVuZ2UiOiJyV2lleDh4RE9QZmlDZ3lGdTRCTFc2dlZPbVhLZ1B3SHJsTUNnRXM5U0JBIiwib3JpZ2luIjoiaHR0cDovL2xvY2FsaG9zdDo5MDA1IiwidHlwZSI6IndlYmF1dGhuLmNyZWF0ZSJ9"
+	macOSAttestationObject = "o2NmbXRmcGFja2VkZ2F0dFN0bXSiY2FsZyZjc2lnWEcwRQIhAJgdgw5x8JzE4JfR6x1RBO8eCHNE8eW_L1VTV03zpyL5AiBv8eUzua3XSS3bPYC7m8eXzJhcaRyeGe7UcuqIrDSvC2hhdXRoRGF0YVi3SZYN5YgOjGh0NBcPZHZgW4_krrmihjLHmVzzuoMdl2NFXJE5zK3OAAI1vMYKZIsLJfHwVQMAMwDserxRhiE7ZcI4ahRbwJCZgc0s38BNXQWtX1Ufy7auS9-RSUTXYJF3vOL9_tExFTQkqaUBAgMmIAEhWCCm9OYidwiIoH9SwVQqUAnH8Gj5ZJ2_qr8gjbg41q4M1SJYIA07XKpHSgS1mE7R1MjotVIQqyHi9WAxGwHQsCteVK2V"
+	macOSCredentialID      = "00ec7abc5186213b65c2386a145bc0909981cd2cdfc04d5d05ad5f551fcbb6ae4bdf914944d7609177bce2fdfed131153424a9"
+
+	// No attestation by a Titan security key for webauthn.io, without user
+	// verification
+	titanClientData        = "eyJjaGFsbGVuZ2UiOiJzVnQ0U2NjZU16cUZTbmZBcThoZ0x6Ymx2bzNmYTRfYUZWRWNJRVNISUowIiwib3JpZ2luIjoiaHR0cHM6Ly93ZWJhdXRobi5pbyIsInR5cGUiOiJ3ZWJhdXRobi5jcmVhdGUifQ"
+	titanAttestationObject = "o2NmbXRkbm9uZWdhdHRTdG10oGhhdXRoRGF0YVjEdKbqkhPJnC90siSSsyDPQCYqlMGpUKA5fyklC2CEHvBBAAAAAAAAAAAAAAAAAAAAAAAAAAAAQOia8u9zP1lVg6Fy7BsUbAVVR6T1g6TctRExl1BLyS3UwJ-RMOpwxlOlvIjt2ZHCxKq_ggcL8dKdlgMc7fEYsEGlAQIDJiABIVgg--n_QvZithDycYmnifk6vMHiwBP6kugn2PlsnvkrcSgiWCBAlBYm2B-rMtQlp5MxGTLoGDHoktxb0p364Hy2BH9U2Q"
+
+	// Login on webauthn.io with the credential of webauthnIOCOSEKey
+	webauthnIOClientData = "eyJjaGFsbGVuZ2UiOiJFNFBUY0lIX0hmWDFwQzZTaWdrMVNDOU5BbGdlenROMDQzOXZpOHpfYzlrIiwibmV3X2tleXNfbWF5X2JlX2FkZGVkX2hlcmUiOiJkbyBub3QgY29tcGFyZSBjbGllbnREYXRhSlNPTiBhZ2FpbnN0IGEgdGVtcGxhdGUuIFNlZSBodHRwczovL2dvby5nbC95YWJQZXgiLCJvcmlnaW4iOiJodHRwczovL3dlYmF1dGhuLmlvIiwidHlwZSI6IndlYmF1dGhuLmdldCJ9"
+	webauthnIOAuthData   = "dKbqkhPJnC90siSSsyDPQCYqlMGpUKA5fyklC2CEHvBFXJJiGa3OAAI1vMYKZIsLJfHwVQMANwCOw-atj9C0vhWpfWU-whzNjeQS21Lpxfdk_G-omAtffWztpGoErlNOfuXWRqm9Uj9ANJck1p6lAQIDJiABIVggKAhfsdHcBIc0KPgAcRyAIK_-Vi-nCXHkRHPNaCMBZ-4iWCBxB8fGYQSBONi9uvq0gv95dGWlhJrBwCsj_a4LJQKVHQ"
+	webauthnIOSignature  = "MEUCIBtIVOQxzFYdyWQyxaLR0tik1TnuPhGVhXVSNgFwLmN5AiEAnxXdCq0UeAVGWxOaFcjBZ_mEZoXqNboY5IkQDdlWZYc"
+)
+
+func mustWebAuthnBase64(t *testing.T, value string) []byte {
+	t.Helper()
+	data, err := DecodeWebAuthnBase64(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestVerifyRegistrationMacOS(t *testing.T) {
+	registration, err := VerifyRegistration("localhost", []string{"http://localhost:9005"},
+		mustWebAuthnBase64(t, macOSClientData), mustWebAuthnBase64(t, macOSAttestationObject))
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	if got := hex.EncodeToString(registration.CredentialID); got != macOSCredentialID {
+		t.Fatalf("credential ID = %s, want %s", got, macOSCredentialID)
+	}
+	if registration.Algorithm != COSEAlgorithmES256 {
+		t.Fatalf("algorithm = %d, want %d", registration.Algorithm, COSEAlgorithmES256)
+	}
+	if registration.SignCount != 1553021388 {
+		t.Fatalf("sign count = %d, want 1553021388", registration.SignCount)
+	}
+	if _, _, err := parseCOSEKey(registration.PublicKey); err != nil {
+		t.Fatalf("stored public key: %v", err)
+	}
+}
+
+func TestVerifyRegistrationRealErrors(t *testing.T) {
+	clientData := mustWebAuthnBase64(t, macOSClientData)
+	attestationObject := mustWebAuthnBase64(t, macOSAttestationObject)
+
+	tests := []struct {
+		name              string
+		rpID              string
+		origins           []string
+		clientData        []byte
+		attestationObject []byte
+		err               string
+	}{
+		{"wrong origin", "localhost", []string{"https://localhost:9005"}, clientData, attestationObject, "not allowed"},
+		{"wrong rpId", "example.com", []string{"http://localhost:9005"}, clientData, attestationObject, "another relying party"},
+		{"truncated attestation object", "localhost", []string{"http://localhost:9005"}, clientData, attestationObject[:len(attestationObject)-1], "invalid attestation object"},
+		{"trailing data", "localhost", []string{"http://localhost:9005"}, clientData, append(append([]byte(nil), attestationObject...), 0x00), "invalid attestation object"},
+		{"user not verified", "webauthn.io", []string{"https://webauthn.io"},
+			mustWebAuthnBase64(t, titanClientData), mustWebAuthnBase64(t, titanAttestationObject), "not verified"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := VerifyRegistration(tt.rpID, tt.origins, tt.clientData, tt.attestationObject)
+			if err == nil {
+				t.Fatal("VerifyRegistration succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("VerifyRegistration error = %q, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionWebAuthnIO(t *testing.T) {
+	publicKey := mustWebAuthnBase64(t, webauthnIOCOSEKey)
+	clientData := mustWebAuthnBase64(t, webauthnIOClientData)
+	authData := mustWebAuthnBase64(t, webauthnIOAuthData)
+	signature := mustWebAuthnBase64(t, webauthnIOSignature)
+
+	signCount, err := VerifyAssertion("webauthn.io", []string{"https://webauthn.io"}, publicKey, clientData, authData, signature)
+	if err != nil {
+		t.Fatalf("VerifyAssertion: %v", err)
+	}
+	if signCount != 1553097241 {
+		t.Fatalf("sign count = %d, want 1553097241", signCount)
+	}
+
+	if _, err := VerifyAssertion("webauthn.io", []string{"https://webauthn.org"}, publicKey, clientData, authData, signature); err == nil {
+		t.Fatal("VerifyAssertion accepted the wrong origin")
+	}
+	if _, err := VerifyAssertion("www.webauthn.io", []string{"https://webauthn.io"}, publicKey, clientData, authData, signature); err == nil {
+		t.Fatal("VerifyAssertion accepted the wrong rpId")
+	}
+	tampered := append([]byte(nil), authData...)
+	tampered[36]++
+	if _, err := VerifyAssertion("webauthn.io", []string{"https://webauthn.io"}, publicKey, clientData, tampered, signature); err == nil {
+		t.Fatal("VerifyAssertion accepted a changed sign count")
+	}
+}
+
+// testAuthenticator signs ceremonies like a platform authenticator would,
+// so each check of the verifier can be failed on its own.
+type testAuthenticator struct {
+	coseKey []byte
+	sign    func(data []byte) []byte
+}
+
+func newTestAuthenticator(t *testing.T, algorithm int) *testAuthenticator {
+	t.Helper()
+	switch algorithm {
+	case COSEAlgorithmES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &testAuthenticator{
+			coseKey: ec2COSEKey(&key.PublicKey),
+			sign: func(data []byte) []byte {
+				digest := sha256.Sum256(data)
+				signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signature
+			},
+		}
+	case COSEAlgorithmEdDSA:
+		publicKey, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &testAuthenticator{
+			coseKey: okpCOSEKey(publicKey),
+			sign:    func(data []byte) []byte { return ed25519.Sign(key, data) },
+		}
+	}
+	t.Fatalf("unsupported algorithm %d", algorithm)
+	return nil
+}
+
+// authData builds authenticator data for the RP ID, with attested credential
+// data when a credential ID is given.
+func (a *testAuthenticator) authData(rpID string, flags byte, signCount uint32, credentialID []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, signCount)
+	if credentialID != nil {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(credentialID)))
+		data = append(data, credentialID...)
+		data = append(data, a.coseKey...)
+	}
+	return data
+}
+
+func testClientData(t *testing.T, clientData ClientData) []byte {
+	t.Helper()
+	data, err := json.Marshal(clientData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testAttestationObject(authData []byte) []byte {
+	return encodeCBOR(cborMap{{"fmt", "none"}, {"attStmt", cborMap{}}, {"authData", authData}})
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	const (
+		rpID   = "id.example.com"
+		origin = "https://id.example.com"
+	)
+	credentialID := []byte("credential-id")
+	okClientData := ClientData{Type: WebAuthnCeremonyRegistration, Challenge: "Y2hhbGxlbmdl", Origin: origin}
+
+	for _, algorithm := range []int{COSEAlgorithmES256, COSEAlgorithmEdDSA} {
+		authenticator := newTestAuthenticator(t, algorithm)
+		okAuthData := authenticator.authData(rpID, authDataUserPresent|authDataUserVerified|authDataAttestedCredential, 7, credentialID)
+
+		registration, err := VerifyRegistration(rpID, []string{origin}, testClientData(t, okClientData), testAttestationObject(okAuthData))
+		if err != nil {
+			t.Fatalf("VerifyRegistration with algorithm %d: %v", algorithm, err)
+		}
+		if string(registration.CredentialID) != string(credentialID) || registration.Algorithm != algorithm ||
+			registration.SignCount != 7 || string(registration.PublicKey) != string(authenticator.coseKey) {
+			t.Fatalf("VerifyRegistration = %+v", registration)
+		}
+	}
+
+	authenticator := newTestAuthenticator(t, COSEAlgorithmES256)
+	withClientData := func(change func(*ClientData)) []byte {
+		clientData := okClientData
+		change(&clientData)
+		return testClientData(t, clientData)
+	}
+	withFlags := func(flags byte) []byte {
+		return testAttestationObject(authenticator.authData(rpID, flags, 0, credentialID))
+	}
+	okAttestationObject := withFlags(authDataUserPresent | authDataUserVerified | authDataAttestedCredential)
+	withExtensions := append(authenticator.authData(rpID, authDataUserPresent|authDataUserVerified|authDataAttestedCredential|0x80, 0, credentialID),
+		encodeCBOR(cborMap{{"credProtect", 2}})...)
+
+	tests := []struct {
+		name              string
+		clientData        []byte
+		attestationObject []byte
+		err               string
+	}{
+		{"wrong origin", withClientData(func(c *ClientData) { c.Origin = "https://evil.example.com" }), okAttestationObject, "not allowed"},
+		{"login client data", withClientData(func(c *ClientData) { c.Type = WebAuthnCeremonyLogin }), okAttestationObject, "must be webauthn.create"},
+		{"cross origin", withClientData(func(c *ClientData) { c.CrossOrigin = true }), okAttestationObject, "cross origin"},
+		{"no challenge", withClientData(func(c *ClientData) { c.Challenge = "" }), okAttestationObject, "no challenge"},
+		{"invalid client data", []byte("{"), okAttestationObject, "invalid client data"},
+		{"wrong rpId", testClientData(t, okClientData),
+			testAttestationObject(authenticator.authData("example.com", authDataUserPresent|authDataUserVerified|authDataAttestedCredential, 0, credentialID)),
+			"another relying party"},
+		{"user present cleared", testClientData(t, okClientData), withFlags(authDataUserVerified | authDataAttestedCredential), "not present"},
+		{"user verified cleared", testClientData(t, okClientData), withFlags(authDataUserPresent | authDataAttestedCredential), "not verified"},
+		{"attested credential cleared", testClientData(t, okClientData), withFlags(authDataUserPresent | authDataUserVerified), "no attested credential"},
+		{"not a map", testClientData(t, okClientData), encodeCBOR([]interface{}{"none"}), "invalid attestation object"},
+		{"no authenticator data", testClientData(t, okClientData), encodeCBOR(cborMap{{"fmt", "none"}}), "no authenticator data"},
+		{"truncated authenticator data", testClientData(t, okClientData), testAttestationObject(make([]byte, authDataMinLength-1)), "truncated"},
+		{"truncated credential data", testClientData(t, okClientData),
+			testAttestationObject(authenticator.authData(rpID, authDataUserPresent|authDataUserVerified|authDataAttestedCredential, 0, nil)),
+			"truncated"},
+		{"truncated public key", testClientData(t, okClientData),
+			testAttestationObject(authenticator.authData(rpID, authDataUserPresent|authDataUserVerified|authDataAttestedCredential, 0, credentialID)[:authDataMinLength+18+len(credentialID)+10]),
+			"invalid credential public key"},
+		{"empty credential ID", testClientData(t, okClientData),
+			testAttestationObject(authenticator.authData(rpID, authDataUserPresent|authDataUserVerified|authDataAttestedCredential, 0, []byte{})),
+			"invalid credential ID"},
+		{"indefinite-length attestation object", testClientData(t, okClientData),
+			append([]byte{0xbf}, append(encodeCBOR(cborMap{{"fmt", "none"}})[1:], 0xff)...),
+			"invalid attestation object"},
+		{"extensions after the public key", testClientData(t, okClientData), testAttestationObject(withExtensions), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registration, err := VerifyRegistration(rpID, []string{origin}, tt.clientData, tt.attestationObject)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("VerifyRegistration: %v", err)
+				}
+				if string(registration.PublicKey) != string(authenticator.coseKey) {
+					t.Fatal("public key includes the extensions")
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("VerifyRegistration succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("VerifyRegistration error = %q, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	const (
+		rpID   = "id.example.com"
+		origin = "https://id.example.com"
+	)
+	okClientData := ClientData{Type: WebAuthnCeremonyLogin, Challenge: "Y2hhbGxlbmdl", Origin: origin}
+	okFlags := byte(authDataUserPresent | authDataUserVerified)
+
+	for _, algorithm := range []int{COSEAlgorithmES256, COSEAlgorithmEdDSA} {
+		t.Run(fmt.Sprintf("algorithm %d", algorithm), func(t *testing.T) {
+			authenticator := newTestAuthenticator(t, algorithm)
+
+			// assertion signs the authenticator data and the hash of the client
+			// data, and returns the three
+			assertion := func(clientData ClientData, rpID string, flags byte) ([]byte, []byte, []byte) {
+				clientDataJSON := testClientData(t, clientData)
+				authData := authenticator.authData(rpID, flags, 42, nil)
+				clientDataHash := sha256.Sum256(clientDataJSON)
+				return clientDataJSON, authData, authenticator.sign(append(append([]byte(nil), authData...), clientDataHash[:]...))
+			}
+
+			clientDataJSON, authData, signature := assertion(okClientData, rpID, okFlags)
+			signCount, err := VerifyAssertion(rpID, []string{origin}, authenticator.coseKey, clientDataJSON, authData, signature)
+			if err != nil {
+				t.Fatalf("VerifyAssertion with algorithm %d: %v", algorithm, err)
+			}
+			if signCount != 42 {
+				t.Fatalf("sign count = %d, want 42", signCount)
+			}
+
+			withClientData := func(change func(*ClientData)) ClientData {
+				clientData := okClientData
+				change(&clientData)
+				return clientData
+			}
+			otherClientData := testClientData(t, withClientData(func(c *ClientData) { c.Challenge = "b3RoZXI" }))
+
+			tests := []struct {
+				name       string
+				clientData ClientData
+				rpID       string
+				flags      byte
+				tamper     func(clientDataJSON, authData, signature []byte) ([]byte, []byte, []byte)
+				err        string
+			}{
+				{name: "wrong origin", clientData: withClientData(func(c *ClientData) { c.Origin = "https://evil.example.com" }), err: "not allowed"},
+				{name: "registration client data", clientData: withClientData(func(c *ClientData) { c.Type = WebAuthnCeremonyRegistration }), err: "must be webauthn.get"},
+				{name: "cross origin", clientData: withClientData(func(c *ClientData) { c.CrossOrigin = true }), err: "cross origin"},
+				{name: "wrong rpId", rpID: "example.com", err: "another relying party"},
+				{name: "user present cleared", flags: authDataUserVerified, err: "not present"},
+				{name: "user verified cleared", flags: authDataUserPresent, err: "not verified"},
+				{name: "flags cleared after signing", tamper: func(c, a, s []byte) ([]byte, []byte, []byte) {
+					a = append([]byte(nil), a...)
+					a[32] &^= authDataUserVerified
+					return c, a, s
+				}, err: "not verified"},
+				{name: "flags set after signing", flags: authDataUserPresent, tamper: func(c, a, s []byte) ([]byte, []byte, []byte) {
+					a = append([]byte(nil), a...)
+					a[32] |= authDataUserVerified
+					return c, a, s
+				}, err: "invalid signature"},
+				{name: "other client data", tamper: func(c, a, s []byte) ([]byte, []byte, []byte) {
+					return otherClientData, a, s
+				}, err: "invalid signature"},
+				{name: "truncated authenticator data", tamper: func(c, a, s []byte) ([]byte, []byte, []byte) {
+					return c, a[:authDataMinLength-1], s
+				}, err: "truncated"},
+				{name: "truncated signature", tamper: func(c, a, s []byte) ([]byte, []byte, []byte) {
+					return c, a, s[:len(s)-1]
+				}, err: "invalid signature"},
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					clientData := tt.clientData
+					if clientData == (ClientData{}) {
+						clientData = okClientData
+					}
+					signedRPID := tt.rpID
+					if signedRPID == "" {
+						signedRPID = rpID
+					}
+					flags := tt.flags
+					if flags == 0 {
+						flags = okFlags
+					}
+					clientDataJSON, authData, signature := assertion(clientData, signedRPID, flags)
+					if tt.tamper != nil {
+						clientDataJSON, authData, signature = tt.tamper(clientDataJSON, authData, signature)
+					}
+
+					_, err := VerifyAssertion(rpID, []string{origin}, authenticator.coseKey, clientDataJSON, authData, signature)
+					if err == nil {
+						t.Fatal("VerifyAssertion succeeded")
+					}
+					if !strings.Contains(err.Error(), tt.err) {
+						t.Fatalf("VerifyAssertion error = %q, want it to contain %q", err, tt.err)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestDecodeWebAuthnBase64(t *testing.T) {
+	for _, value := range []string{"-_8", "-_8="} {
+		data, err := DecodeWebAuthnBase64(value)
+		if err != nil || hex.EncodeToString(data) != "fbff" {
+			t.Fatalf("DecodeWebAuthnBase64(%q) = %x, %v", value, data, err)
+		}
+	}
+	if _, err := DecodeWebAuthnBase64("+/8"); err == nil {
+		t.Fatal("DecodeWebAuthnBase64 accepted standard base64")
+	}
+}
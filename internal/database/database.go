@@ -3,14 +3,18 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"slices"
 	"strconv"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/wbrijesh/identity/buid"
+	"github.com/wbrijesh/identity/internal/auth"
 	"github.com/wbrijesh/identity/internal/models"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -116,5 +120,234 @@ func (s *service) Close() error {
 }
 
 func (s *service) RunMigrations() error {
-	return s.db.AutoMigrate(&models.Admin{}, &models.Application{}, &models.User{})
+	err := s.db.AutoMigrate(
+		&models.Admin{},
+		&models.AdminRecoveryCode{},
+		&models.Application{},
+		&models.ApplicationCredential{},
+		&models.User{},
+		&models.AuthorizationCode{},
+		&models.Session{},
+		&models.MFAChallenge{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnChallenge{},
+		&models.OneTimeToken{},
+		&models.PasswordHistory{},
+		&models.RevokedToken{},
+		&models.SubjectRevocation{},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Refresh tokens moved from applications to application_credentials, and
+	// are stored hashed there. Copy them over before dropping the old columns,
+	// so existing integrations keep working and no plaintext secrets are left
+	// behind.
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := migrateApplicationRefreshTokens(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := migrateCredentialRefreshTokens(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, table := range []string{"admins", "users"} {
+		if err := encryptTOTPSecrets(tx, table); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	legacyColumns := []struct {
+		model  interface{}
+		column string
+	}{
+		{&models.Application{}, "refresh_token"},
+		{&models.Application{}, "previous_refresh_token"},
+		{&models.Application{}, "previous_refresh_token_expires_at"},
+		{&models.Application{}, "refresh_token_family"},
+		{&models.Application{}, "refresh_token_scopes"},
+		{&models.ApplicationCredential{}, "refresh_token"},
+		{&models.ApplicationCredential{}, "previous_refresh_token"},
+	}
+	for _, legacy := range legacyColumns {
+		if tx.Migrator().HasColumn(legacy.model, legacy.column) {
+			if err := tx.Migrator().DropColumn(legacy.model, legacy.column); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// migrateApplicationRefreshTokens gives every application that still has a
+// refresh token on its row a "Default" credential holding that token, so it
+// keeps working. The columns that came later may be missing on older
+// databases.
+func migrateApplicationRefreshTokens(tx *gorm.DB) error {
+	migrator := tx.Migrator()
+	if !migrator.HasColumn(&models.Application{}, "refresh_token") {
+		return nil
+	}
+	column := func(name, expression, missing string) string {
+		if migrator.HasColumn(&models.Application{}, name) {
+			return expression + " AS " + name
+		}
+		return missing + " AS " + name
+	}
+
+	var rows []struct {
+		ID                            string
+		RefreshToken                  string
+		PreviousRefreshToken          string
+		PreviousRefreshTokenExpiresAt *time.Time
+		RefreshTokenFamily            string
+		RefreshTokenScopes            string
+	}
+	err := tx.Table("applications").
+		Select([]string{
+			"id",
+			"refresh_token",
+			column("previous_refresh_token", "COALESCE(previous_refresh_token, '')", "''"),
+			column("previous_refresh_token_expires_at", "previous_refresh_token_expires_at", "NULL::timestamptz"),
+			column("refresh_token_family", "COALESCE(refresh_token_family, '')", "''"),
+			column("refresh_token_scopes", "COALESCE(refresh_token_scopes::text, '')", "''"),
+		}).
+		Where("deleted_at IS NULL AND refresh_token IS NOT NULL AND refresh_token <> ''").
+		Scan(&rows).Error
+	if err != nil {
+		return fmt.Errorf("failed to read application refresh tokens: %w", err)
+	}
+
+	now := time.Now()
+	for _, row := range rows {
+		// Before scopes existed an application token had every scope
+		scopes := slices.Clone(auth.ApplicationScopes)
+		if row.RefreshTokenScopes != "" && row.RefreshTokenScopes != "null" {
+			if err := json.Unmarshal([]byte(row.RefreshTokenScopes), &scopes); err != nil {
+				return fmt.Errorf("invalid refresh token scopes of application %s: %w", row.ID, err)
+			}
+		}
+
+		credential := models.ApplicationCredential{
+			ID:                 buid.GenerateBUID(),
+			CreatedAt:          now,
+			UpdatedAt:          now,
+			Label:              "Default",
+			Scopes:             scopes,
+			RefreshTokenPrefix: auth.RefreshTokenPrefix(row.RefreshToken),
+			RefreshTokenHash:   auth.HashOpaqueToken(row.RefreshToken),
+			RefreshTokenFamily: row.RefreshTokenFamily,
+			ApplicationID:      row.ID,
+		}
+		if row.PreviousRefreshToken != "" && row.PreviousRefreshTokenExpiresAt != nil {
+			credential.PreviousRefreshTokenHash = auth.HashOpaqueToken(row.PreviousRefreshToken)
+			credential.PreviousRefreshTokenExpiresAt = row.PreviousRefreshTokenExpiresAt
+		}
+		if credential.RefreshTokenFamily == "" {
+			credential.RefreshTokenFamily = buid.GenerateBUID()
+		}
+
+		if err := tx.Create(&credential).Error; err != nil {
+			return fmt.Errorf("failed to migrate refresh token of application %s: %w", row.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// migrateCredentialRefreshTokens hashes the refresh tokens credentials
+// stored in plaintext before only hashes were kept.
+func migrateCredentialRefreshTokens(tx *gorm.DB) error {
+	migrator := tx.Migrator()
+	if !migrator.HasColumn(&models.ApplicationCredential{}, "refresh_token") {
+		return nil
+	}
+	previous := "''"
+	if migrator.HasColumn(&models.ApplicationCredential{}, "previous_refresh_token") {
+		previous = "COALESCE(previous_refresh_token, '')"
+	}
+
+	var rows []struct {
+		ID                   string
+		RefreshToken         string
+		PreviousRefreshToken string
+	}
+	err := tx.Table("application_credentials").
+		Select("id", "COALESCE(refresh_token, '') AS refresh_token", previous+" AS previous_refresh_token").
+		Where("refresh_token_hash IS NULL OR refresh_token_hash = ''").
+		Scan(&rows).Error
+	if err != nil {
+		return fmt.Errorf("failed to read credential refresh tokens: %w", err)
+	}
+
+	for _, row := range rows {
+		updates := map[string]interface{}{
+			"refresh_token_hash":          "",
+			"refresh_token_prefix":        "",
+			"previous_refresh_token_hash": "",
+		}
+		if row.RefreshToken != "" {
+			updates["refresh_token_hash"] = auth.HashOpaqueToken(row.RefreshToken)
+			updates["refresh_token_prefix"] = auth.RefreshTokenPrefix(row.RefreshToken)
+		}
+		if row.PreviousRefreshToken != "" {
+			updates["previous_refresh_token_hash"] = auth.HashOpaqueToken(row.PreviousRefreshToken)
+		}
+		if err := tx.Table("application_credentials").Where("id = ?", row.ID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to hash refresh token of credential %s: %w", row.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// encryptTOTPSecrets encrypts the TOTP secrets stored in plaintext before they
+// were encrypted, and those encrypted with a key that is no longer the active
+// one of the secret ring, so the old key can be retired.
+func encryptTOTPSecrets(tx *gorm.DB, table string) error {
+	var rows []struct {
+		ID         string
+		TOTPSecret string
+	}
+	err := tx.Table(table).
+		Select("id", "totp_secret").
+		Where("totp_secret IS NOT NULL AND totp_secret <> ''").
+		Scan(&rows).Error
+	if err != nil {
+		return fmt.Errorf("failed to read TOTP secrets of %s: %w", table, err)
+	}
+
+	for _, row := range rows {
+		encryptedSecret, err := auth.ReencryptSecret(row.TOTPSecret, row.ID)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt TOTP secret of %s: %w", row.ID, err)
+		}
+		if encryptedSecret == "" {
+			continue
+		}
+		if err := tx.Table(table).Where("id = ?", row.ID).Update("totp_secret", encryptedSecret).Error; err != nil {
+			return fmt.Errorf("failed to encrypt TOTP secret of %s: %w", row.ID, err)
+		}
+	}
+
+	return nil
 }
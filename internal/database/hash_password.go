@@ -1,21 +1,48 @@
 package database
 
 import (
-	"fmt"
+	"errors"
+	"log"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/wbrijesh/identity/internal/auth"
+	"gorm.io/gorm"
 )
 
-// HashPassword generates a bcrypt hash of the password
+// ErrInvalidPassword is returned when a current password given to confirm a
+// change does not match.
+var ErrInvalidPassword = errors.New("invalid password")
+
+// HashPassword hashes a password with the configured password hasher
 func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", fmt.Errorf("failed to hash password: %w", err)
-	}
-	return string(hashedPassword), nil
+	return auth.HashPassword(password)
 }
 
-// verifyPassword compares a bcrypt hashed password with its possible plaintext equivalent
+// VerifyPassword compares a hashed password of any supported algorithm with
+// its possible plaintext equivalent
 func VerifyPassword(hashedPassword string, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	return auth.VerifyPasswordHash(hashedPassword, password)
+}
+
+// rehashPassword replaces a password hash made with another algorithm or
+// other parameters than the configured ones, after the password was checked
+// against it. The hash only changes form, so no history entry is recorded
+// and a failure is logged rather than failing the login.
+func rehashPassword(db *gorm.DB, model interface{}, id, hashedPassword, password string) {
+	if !auth.PasswordNeedsRehash(hashedPassword) {
+		return
+	}
+
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		log.Printf("failed to rehash password of %s: %v", id, err)
+		return
+	}
+
+	// Only replace the hash that was checked, a concurrent change wins
+	err = db.Model(model).
+		Where("id = ? AND password_hash = ?", id, hashedPassword).
+		Update("password_hash", passwordHash).Error
+	if err != nil {
+		log.Printf("failed to rehash password of %s: %v", id, err)
+	}
 }
@@ -54,6 +54,11 @@ func (s *service) CreateAdmin(ctx context.Context, admin *models.Admin) (*models
 		return nil, fmt.Errorf("failed to create admin: %w", err)
 	}
 
+	if err := recordPasswordHistory(tx, admin.ID, admin.PasswordHash); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	// Commit the transaction
 	if err := tx.Commit().Error; err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
@@ -106,6 +111,16 @@ func (s *service) UpdateAdmin(ctx context.Context, admin *models.Admin) (*models
 		return nil, fmt.Errorf("error fetching admin: %w", err)
 	}
 
+	// Like CreateAdmin, PasswordHash carries the new plaintext password
+	if admin.PasswordHash != "" {
+		passwordHash, err := HashPassword(admin.PasswordHash)
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		admin.PasswordHash = passwordHash
+	}
+
 	// Update the admin
 	admin.UpdatedAt = time.Now()
 	if err := tx.Model(&existingAdmin).Updates(admin).Error; err != nil {
@@ -113,6 +128,13 @@ func (s *service) UpdateAdmin(ctx context.Context, admin *models.Admin) (*models
 		return nil, fmt.Errorf("failed to update admin: %w", err)
 	}
 
+	if admin.PasswordHash != "" {
+		if err := recordPasswordHistory(tx, existingAdmin.ID, admin.PasswordHash); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
@@ -190,6 +212,74 @@ func (s *service) AuthenticateAdmin(ctx context.Context, email, password string)
 	if err := VerifyPassword(admin.PasswordHash, password); err != nil {
 		return nil, err
 	}
+	rehashPassword(s.db.WithContext(ctx), &models.Admin{}, admin.ID, admin.PasswordHash, password)
+
+	return admin.ToResponseAdmin(), nil
+}
+
+// CheckAdminPassword checks the current password of an admin before a
+// change to their account.
+func (s *service) CheckAdminPassword(ctx context.Context, adminID, password string) error {
+	var admin models.Admin
+	if err := s.db.WithContext(ctx).Select("password_hash").First(&admin, "id = ?", adminID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("admin with ID %s not found", adminID)
+		}
+		return fmt.Errorf("error fetching admin: %w", err)
+	}
+
+	if err := VerifyPassword(admin.PasswordHash, password); err != nil {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// ChangeAdminEmail sets a new email that the admin confirmed they received.
+func (s *service) ChangeAdminEmail(ctx context.Context, adminID, email string) (*models.ResponseAdmin, error) {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var admin models.Admin
+	if err := tx.First(&admin, "id = ?", adminID).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("admin with ID %s not found", adminID)
+		}
+		return nil, fmt.Errorf("error fetching admin: %w", err)
+	}
+
+	var taken int64
+	if err := tx.Model(&models.Admin{}).Where("email = ? AND id <> ?", email, adminID).Count(&taken).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("error checking for existing admin: %w", err)
+	}
+	if taken > 0 {
+		tx.Rollback()
+		return nil, ErrEmailTaken
+	}
+
+	admin.Email = email
+	admin.UpdatedAt = time.Now()
+	err := tx.Model(&admin).Updates(map[string]interface{}{
+		"email":      admin.Email,
+		"updated_at": admin.UpdatedAt,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to update admin: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
 
 	return admin.ToResponseAdmin(), nil
 }
@@ -7,14 +7,10 @@ import (
 	"time"
 
 	"github.com/wbrijesh/identity/buid"
-	"github.com/wbrijesh/identity/internal/auth"
 	"github.com/wbrijesh/identity/internal/models"
 	"gorm.io/gorm"
 )
 
-// Assuming this is defined somewhere in your package
-var secretKey = []byte("your-secret-key-here")
-
 func (s *service) CreateApplication(ctx context.Context, app *models.Application) (*models.Application, error) {
 	tx := s.db.WithContext(ctx).Begin()
 	if tx.Error != nil {
@@ -147,85 +143,3 @@ func (s *service) ListApplications(ctx context.Context, offset, limit int, admin
 
 	return apps, total, nil
 }
-
-func (s *service) GenerateRefreshToken(ctx context.Context, id string) (string, error) {
-	tx := s.db.WithContext(ctx).Begin()
-	if tx.Error != nil {
-		return "", fmt.Errorf("failed to begin transaction: %w", tx.Error)
-	}
-
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
-	// Fetch the application
-	var app models.Application
-	if err := tx.First(&app, "id = ?", id).Error; err != nil {
-		tx.Rollback()
-		return "", fmt.Errorf("failed to find application: %w", err)
-	}
-
-	if app.RefreshToken != "" {
-		tx.Rollback()
-		return "", fmt.Errorf("application already has a refresh token")
-	}
-
-	// Generate a new refresh token
-	refreshToken, err := auth.GenerateRefreshToken(app.ID)
-	if err != nil {
-		tx.Rollback()
-		return "", fmt.Errorf("failed to generate refresh token: %w", err)
-	}
-
-	// Update the application with the new refresh token
-	app.RefreshToken = refreshToken
-	app.UpdatedAt = time.Now()
-
-	if err := tx.Save(&app).Error; err != nil {
-		tx.Rollback()
-		return "", fmt.Errorf("failed to update application with refresh token: %w", err)
-	}
-
-	if err := tx.Commit().Error; err != nil {
-		return "", fmt.Errorf("failed to commit transaction: %w", err)
-	}
-
-	return refreshToken, nil
-}
-
-func (s *service) DeleteRefreshToken(ctx context.Context, id string) error {
-	tx := s.db.WithContext(ctx).Begin()
-	if tx.Error != nil {
-		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
-	}
-
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
-	// Fetch the application
-	var app models.Application
-	if err := tx.First(&app, "id = ?", id).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to find application: %w", err)
-	}
-
-	// Clear the refresh token
-	app.RefreshToken = ""
-	app.UpdatedAt = time.Now()
-
-	if err := tx.Save(&app).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to update application and remove refresh token: %w", err)
-	}
-
-	if err := tx.Commit().Error; err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
-	}
-
-	return nil
-}
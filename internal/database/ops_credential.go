@@ -0,0 +1,353 @@
+package database
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wbrijesh/identity/buid"
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCredentialNotFound  = errors.New("credential not found")
+	ErrCredentialRevoked   = errors.New("credential has been revoked")
+	ErrCredentialExpired   = errors.New("credential has expired")
+	ErrRefreshTokenInvalid = errors.New("refresh token is not valid for this credential")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been rotated, token family revoked")
+	ErrScopeNotGranted     = errors.New("requested scope was not granted to the credential")
+)
+
+// CreateCredential stores a new credential for an application and returns its
+// first refresh token. Only the hash of the token is stored, so this is the
+// only time it is available.
+func (s *service) CreateCredential(ctx context.Context, credential *models.ApplicationCredential) (string, error) {
+	now := time.Now()
+	credential.ID = buid.GenerateBUID()
+	credential.CreatedAt = now
+	credential.UpdatedAt = now
+	credential.RefreshTokenFamily = buid.GenerateBUID()
+
+	refreshToken, err := auth.GenerateRefreshToken(credential.ApplicationID, credential.ID, credential.RefreshTokenFamily, credential.ExpiresAt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	credential.RefreshTokenHash = auth.HashOpaqueToken(refreshToken)
+	credential.RefreshTokenPrefix = auth.RefreshTokenPrefix(refreshToken)
+
+	if err := s.db.WithContext(ctx).Create(credential).Error; err != nil {
+		return "", fmt.Errorf("failed to create credential: %w", err)
+	}
+
+	return refreshToken, nil
+}
+
+func (s *service) GetCredential(ctx context.Context, applicationID, id string) (*models.ApplicationCredential, error) {
+	var credential models.ApplicationCredential
+	err := s.db.WithContext(ctx).First(&credential, "id = ? AND application_id = ?", id, applicationID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCredentialNotFound
+		}
+		return nil, fmt.Errorf("error fetching credential: %w", err)
+	}
+	return &credential, nil
+}
+
+// ListCredentials returns the credentials of an application that have not
+// been revoked.
+func (s *service) ListCredentials(ctx context.Context, applicationID string, offset, limit int) ([]*models.ApplicationCredential, int64, error) {
+	var credentials []*models.ApplicationCredential
+	var total int64
+
+	query := s.db.WithContext(ctx).Model(&models.ApplicationCredential{}).
+		Where("application_id = ? AND revoked_at IS NULL", applicationID)
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("error counting credentials: %w", err)
+	}
+
+	if limit == 0 {
+		offset = 0
+		limit = 20
+	}
+
+	if err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&credentials).Error; err != nil {
+		return nil, 0, fmt.Errorf("error fetching credentials: %w", err)
+	}
+
+	return credentials, total, nil
+}
+
+// UpdateCredential saves the label, scopes and expiry of a credential. Scope
+// changes apply to the next access token, the refresh token stays the same.
+func (s *service) UpdateCredential(ctx context.Context, credential *models.ApplicationCredential) (*models.ApplicationCredential, error) {
+	credential.UpdatedAt = time.Now()
+	result := s.db.WithContext(ctx).Model(&models.ApplicationCredential{}).
+		Where("id = ? AND application_id = ? AND revoked_at IS NULL", credential.ID, credential.ApplicationID).
+		Select("Label", "Scopes", "ExpiresAt", "UpdatedAt").
+		Updates(credential)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update credential: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrCredentialNotFound
+	}
+
+	return s.GetCredential(ctx, credential.ApplicationID, credential.ID)
+}
+
+// RevokeCredential revokes a credential and clears its refresh tokens. In the
+// same transaction the access tokens issued from it are revoked, keyed by the
+// credential's ID, and the time of the revocation is returned so it can be
+// applied to the revocation cache. Other credentials of the application are
+// not affected.
+func (s *service) RevokeCredential(ctx context.Context, applicationID, id, revokedBy string) (time.Time, error) {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return time.Time{}, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	now := time.Now()
+	result := tx.Model(&models.ApplicationCredential{}).
+		Where("id = ? AND application_id = ? AND revoked_at IS NULL", id, applicationID).
+		Updates(map[string]interface{}{
+			"revoked_at":                        now,
+			"refresh_token_hash":                "",
+			"previous_refresh_token_hash":       "",
+			"previous_refresh_token_expires_at": nil,
+			"updated_at":                        now,
+		})
+	if result.Error != nil {
+		tx.Rollback()
+		return time.Time{}, fmt.Errorf("failed to revoke credential: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return time.Time{}, ErrCredentialNotFound
+	}
+
+	err := revokeSubjectTokens(tx, &models.SubjectRevocation{
+		Subject:       id,
+		RevokedBefore: now,
+		RevokedBy:     revokedBy,
+	})
+	if err != nil {
+		tx.Rollback()
+		return time.Time{}, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return time.Time{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return now, nil
+}
+
+// checkCredential returns an error when the credential can no longer be used.
+func checkCredential(credential *models.ApplicationCredential, now time.Time) error {
+	if credential.RevokedAt != nil {
+		return ErrCredentialRevoked
+	}
+	if credential.ExpiresAt != nil && now.After(*credential.ExpiresAt) {
+		return ErrCredentialExpired
+	}
+	return nil
+}
+
+// rotateRefreshToken replaces the current refresh token of the credential
+// with a new one of the same family. The replaced token stays valid for the
+// grace period.
+func rotateRefreshToken(tx *gorm.DB, credential *models.ApplicationCredential) (string, error) {
+	refreshToken, err := auth.GenerateRefreshToken(credential.ApplicationID, credential.ID, credential.RefreshTokenFamily, credential.ExpiresAt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	now := time.Now()
+	graceDeadline := now.Add(auth.RefreshTokenGracePeriod())
+	credential.PreviousRefreshTokenHash = credential.RefreshTokenHash
+	credential.PreviousRefreshTokenExpiresAt = &graceDeadline
+	if credential.PreviousRefreshTokenHash == "" {
+		credential.PreviousRefreshTokenExpiresAt = nil
+	}
+	credential.RefreshTokenHash = auth.HashOpaqueToken(refreshToken)
+	credential.RefreshTokenPrefix = auth.RefreshTokenPrefix(refreshToken)
+	credential.UpdatedAt = now
+
+	if err := tx.Save(credential).Error; err != nil {
+		return "", fmt.Errorf("failed to update credential with refresh token: %w", err)
+	}
+
+	return refreshToken, nil
+}
+
+// RotateCredential replaces the refresh token of a credential in a single
+// transaction, so the credential is never left without a refresh token.
+func (s *service) RotateCredential(ctx context.Context, applicationID, id string) (string, error) {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var credential models.ApplicationCredential
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&credential, "id = ? AND application_id = ?", id, applicationID).Error
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrCredentialNotFound
+		}
+		return "", fmt.Errorf("failed to find credential: %w", err)
+	}
+
+	if err := checkCredential(&credential, time.Now()); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	// Without a current token there is nothing to rotate, start a new family
+	if credential.RefreshTokenHash == "" {
+		credential.RefreshTokenFamily = buid.GenerateBUID()
+	}
+
+	refreshToken, err := rotateRefreshToken(tx, &credential)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return refreshToken, nil
+}
+
+// UseRefreshToken checks a refresh token presented by an application and
+// returns its credential. With rotate set the current token is replaced by a
+// new one, which is returned as well. Requested scopes, when any, must have
+// been granted to the credential. They are checked before the token is
+// rotated, so a refused request leaves the client's token working.
+//
+// The previous token is accepted during the grace period, so a client can
+// retry a refresh whose response was lost, and is rotated again. Any other
+// token of the current family has already been rotated out, so it is treated
+// as stolen and the whole family is revoked.
+func (s *service) UseRefreshToken(ctx context.Context, refreshToken string, rotate bool, requestedScopes []string) (*models.ApplicationCredential, string, error) {
+	claims, err := auth.ValidateRefreshToken(refreshToken)
+	if err != nil {
+		return nil, "", ErrRefreshTokenInvalid
+	}
+
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, "", fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	refreshTokenHash := auth.HashOpaqueToken(refreshToken)
+
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("application_id = ?", claims.ApplicationID)
+	if claims.CredentialID != "" {
+		query = query.Where("id = ?", claims.CredentialID)
+	} else {
+		// Tokens issued before applications had credentials are found on
+		// the credential they were migrated to
+		query = query.Where("refresh_token_hash = ? OR previous_refresh_token_hash = ? OR (refresh_token_family <> '' AND refresh_token_family = ?)",
+			refreshTokenHash, refreshTokenHash, claims.Family)
+	}
+
+	var credential models.ApplicationCredential
+	if err := query.First(&credential).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrRefreshTokenInvalid
+		}
+		return nil, "", fmt.Errorf("failed to find credential: %w", err)
+	}
+
+	now := time.Now()
+	if err := checkCredential(&credential, now); err != nil {
+		tx.Rollback()
+		return nil, "", err
+	}
+
+	isCurrent := credential.RefreshTokenHash != "" && tokensEqual(credential.RefreshTokenHash, refreshTokenHash)
+	inGrace := credential.PreviousRefreshTokenHash != "" && tokensEqual(credential.PreviousRefreshTokenHash, refreshTokenHash) &&
+		credential.PreviousRefreshTokenExpiresAt != nil && now.Before(*credential.PreviousRefreshTokenExpiresAt)
+
+	if !isCurrent && !inGrace {
+		if credential.RefreshTokenFamily == "" || claims.Family != credential.RefreshTokenFamily {
+			tx.Rollback()
+			return nil, "", ErrRefreshTokenInvalid
+		}
+
+		// Reuse of a rotated token, revoke every token of the family
+		credential.RefreshTokenHash = ""
+		credential.RefreshTokenPrefix = ""
+		credential.RefreshTokenFamily = ""
+		credential.PreviousRefreshTokenHash = ""
+		credential.PreviousRefreshTokenExpiresAt = nil
+		credential.UpdatedAt = now
+		if err := tx.Save(&credential).Error; err != nil {
+			tx.Rollback()
+			return nil, "", fmt.Errorf("failed to revoke refresh token family: %w", err)
+		}
+		if err := tx.Commit().Error; err != nil {
+			return nil, "", fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	if len(requestedScopes) > 0 && !auth.ScopesGranted(requestedScopes, credential.Scopes) {
+		tx.Rollback()
+		return nil, "", ErrScopeNotGranted
+	}
+
+	credential.LastUsedAt = &now
+
+	var newRefreshToken string
+	if rotate {
+		newRefreshToken, err = rotateRefreshToken(tx, &credential)
+		if err != nil {
+			tx.Rollback()
+			return nil, "", err
+		}
+	} else if err := tx.Model(&credential).UpdateColumn("last_used_at", now).Error; err != nil {
+		tx.Rollback()
+		return nil, "", fmt.Errorf("failed to update credential: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &credential, newRefreshToken, nil
+}
+
+func tokensEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
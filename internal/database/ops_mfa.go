@@ -0,0 +1,416 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wbrijesh/identity/buid"
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrMFAChallengeNotFound = errors.New("MFA challenge not found")
+	ErrMFAChallengeUsed     = errors.New("MFA challenge has already been used")
+	ErrMFAChallengeExpired  = errors.New("MFA challenge has expired")
+	ErrTOTPNotEnrolled      = errors.New("TOTP is not enrolled")
+	ErrTOTPAlreadyEnabled   = errors.New("TOTP is already enabled")
+	ErrTOTPCodeInvalid      = errors.New("invalid TOTP code")
+	ErrTOTPLocked           = errors.New("too many invalid TOTP codes, try again later")
+	ErrRecoveryCodeInvalid  = errors.New("invalid recovery code")
+)
+
+// After maxTOTPAttempts invalid codes in a row, codes are refused until
+// totpLockout has passed since the last one. This caps guessing at a handful
+// of codes per lockout period whichever endpoint they are sent to.
+const (
+	maxTOTPAttempts = 5
+	totpLockout     = 5 * time.Minute
+)
+
+// CreateMFAChallenge stores a new challenge and returns its token.
+func (s *service) CreateMFAChallenge(ctx context.Context, challenge *models.MFAChallenge) (string, error) {
+	now := time.Now()
+	challenge.ID = buid.GenerateBUID()
+	challenge.CreatedAt = now
+	challenge.UpdatedAt = now
+	challenge.ExpiresAt = now.Add(auth.MFAChallengeTTL)
+
+	token, tokenHash, err := auth.GenerateMFAChallengeToken(challenge.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate MFA challenge token: %w", err)
+	}
+	challenge.TokenHash = tokenHash
+
+	if err := s.db.WithContext(ctx).Create(challenge).Error; err != nil {
+		return "", fmt.Errorf("failed to create MFA challenge: %w", err)
+	}
+
+	return token, nil
+}
+
+// GetMFAChallenge returns the challenge a token was issued for, as long as it
+// has not been used and has not expired.
+func (s *service) GetMFAChallenge(ctx context.Context, token string) (*models.MFAChallenge, error) {
+	challengeID, ok := auth.ParseMFAChallengeToken(token)
+	if !ok {
+		return nil, ErrMFAChallengeNotFound
+	}
+
+	var challenge models.MFAChallenge
+	if err := s.db.WithContext(ctx).First(&challenge, "id = ?", challengeID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMFAChallengeNotFound
+		}
+		return nil, fmt.Errorf("error fetching MFA challenge: %w", err)
+	}
+
+	if !tokensEqual(challenge.TokenHash, auth.HashOpaqueToken(token)) {
+		return nil, ErrMFAChallengeNotFound
+	}
+	if challenge.UsedAt != nil {
+		return nil, ErrMFAChallengeUsed
+	}
+	if time.Now().After(challenge.ExpiresAt) {
+		return nil, ErrMFAChallengeExpired
+	}
+
+	return &challenge, nil
+}
+
+// ConsumeMFAChallenge marks a challenge as used. A challenge can only be
+// consumed once, so two requests completing the same challenge cannot both
+// start a session.
+func (s *service) ConsumeMFAChallenge(ctx context.Context, id string) error {
+	now := time.Now()
+	result := s.db.WithContext(ctx).Model(&models.MFAChallenge{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Updates(map[string]interface{}{"used_at": now, "updated_at": now})
+	if result.Error != nil {
+		return fmt.Errorf("failed to consume MFA challenge: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrMFAChallengeUsed
+	}
+	return nil
+}
+
+// EnrollTOTP generates a new TOTP secret for a user and returns it. The
+// secret is pending until a code generated from it is verified, replacing
+// any earlier pending secret.
+func (s *service) EnrollTOTP(ctx context.Context, userID string) (string, error) {
+	return s.enrollTOTP(ctx, &models.User{}, userID)
+}
+
+// VerifyUserTOTP checks a code against the user's TOTP secret. With
+// allowPending a pending secret is accepted as well, and verifying a code
+// from it enables TOTP for the user.
+func (s *service) VerifyUserTOTP(ctx context.Context, userID, code string, allowPending bool) error {
+	return s.verifyTOTP(ctx, &models.User{}, userID, code, allowPending)
+}
+
+// DisableTOTP removes the TOTP secret of a user, enabled or pending.
+func (s *service) DisableTOTP(ctx context.Context, userID string) error {
+	return disableTOTP(s.db.WithContext(ctx), &models.User{}, userID)
+}
+
+// EnrollAdminTOTP generates a new TOTP secret for an admin, like EnrollTOTP
+// does for users.
+func (s *service) EnrollAdminTOTP(ctx context.Context, adminID string) (string, error) {
+	return s.enrollTOTP(ctx, &models.Admin{}, adminID)
+}
+
+// VerifyAdminTOTP checks a code against the admin's TOTP secret, like
+// VerifyUserTOTP does for users.
+func (s *service) VerifyAdminTOTP(ctx context.Context, adminID, code string, allowPending bool) error {
+	return s.verifyTOTP(ctx, &models.Admin{}, adminID, code, allowPending)
+}
+
+// DisableAdminTOTP removes the TOTP secret and the recovery codes of an
+// admin.
+func (s *service) DisableAdminTOTP(ctx context.Context, adminID string) error {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := disableTOTP(tx, &models.Admin{}, adminID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Unscoped().Where("admin_id = ?", adminID).Delete(&models.AdminRecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ReplaceAdminRecoveryCodes stores new recovery code hashes for an admin and
+// drops the previous codes, used or not.
+func (s *service) ReplaceAdminRecoveryCodes(ctx context.Context, adminID string, codeHashes []string) error {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err := tx.Unscoped().Where("admin_id = ?", adminID).Delete(&models.AdminRecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	now := time.Now()
+	codes := make([]*models.AdminRecoveryCode, len(codeHashes))
+	for i, codeHash := range codeHashes {
+		codes[i] = &models.AdminRecoveryCode{
+			ID:        buid.GenerateBUID(),
+			CreatedAt: now,
+			UpdatedAt: now,
+			CodeHash:  codeHash,
+			AdminID:   adminID,
+		}
+	}
+	if err := tx.Create(codes).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create recovery codes: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UseAdminRecoveryCode checks a recovery code of an admin and marks it as
+// used. Invalid codes count towards the same lockout as invalid TOTP codes.
+func (s *service) UseAdminRecoveryCode(ctx context.Context, adminID, code string) error {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	factor, err := lockTOTPFactor(tx, &models.Admin{}, adminID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if factor.TOTPEnabledAt == nil {
+		tx.Rollback()
+		return ErrTOTPNotEnrolled
+	}
+
+	now := time.Now()
+	if totpLocked(factor, now) {
+		tx.Rollback()
+		return ErrTOTPLocked
+	}
+
+	result := tx.Model(&models.AdminRecoveryCode{}).
+		Where("admin_id = ? AND code_hash = ? AND used_at IS NULL", adminID, auth.HashRecoveryCode(code)).
+		Updates(map[string]interface{}{"used_at": now, "updated_at": now})
+	if result.Error != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to use recovery code: %w", result.Error)
+	}
+
+	var verifyErr error
+	if result.RowsAffected == 0 {
+		verifyErr = ErrRecoveryCodeInvalid
+	}
+	if err := recordTOTPResult(tx, &models.Admin{}, adminID, factor, verifyErr == nil, now); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return verifyErr
+}
+
+func (s *service) enrollTOTP(ctx context.Context, model interface{}, id string) (string, error) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	encryptedSecret, err := auth.EncryptSecret(secret, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt TOTP secret: %w", err)
+	}
+
+	result := s.db.WithContext(ctx).Model(model).
+		Where("id = ? AND totp_enabled_at IS NULL", id).
+		Updates(map[string]interface{}{
+			"totp_secret":       encryptedSecret,
+			"totp_last_counter": 0,
+			"updated_at":        time.Now(),
+		})
+	if result.Error != nil {
+		return "", fmt.Errorf("failed to enroll TOTP: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		if err := s.db.WithContext(ctx).Model(model).Where("id = ?", id).Count(&count).Error; err != nil {
+			return "", fmt.Errorf("failed to enroll TOTP: %w", err)
+		}
+		if count == 0 {
+			return "", fmt.Errorf("account not found with id %s", id)
+		}
+		return "", ErrTOTPAlreadyEnabled
+	}
+
+	return secret, nil
+}
+
+func (s *service) verifyTOTP(ctx context.Context, model interface{}, id, code string, allowPending bool) error {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	factor, err := lockTOTPFactor(tx, model, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if factor.TOTPSecret == "" || (factor.TOTPEnabledAt == nil && !allowPending) {
+		tx.Rollback()
+		return ErrTOTPNotEnrolled
+	}
+
+	now := time.Now()
+	if totpLocked(factor, now) {
+		tx.Rollback()
+		return ErrTOTPLocked
+	}
+
+	secret, err := auth.DecryptSecret(factor.TOTPSecret, id)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+
+	counter, ok := auth.VerifyTOTP(secret, code, now, factor.TOTPLastCounter)
+	if ok {
+		factor.TOTPLastCounter = counter
+	}
+	if err := recordTOTPResult(tx, model, id, factor, ok, now); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if !ok {
+		return ErrTOTPCodeInvalid
+	}
+	return nil
+}
+
+// lockTOTPFactor loads the TOTP factor of an admin or user and locks the row
+// for the rest of the transaction.
+func lockTOTPFactor(tx *gorm.DB, model interface{}, id string) (*models.TOTPFactor, error) {
+	var factor models.TOTPFactor
+	err := tx.Model(model).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", id).Take(&factor).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("account not found with id %s", id)
+		}
+		return nil, fmt.Errorf("error fetching account: %w", err)
+	}
+	return &factor, nil
+}
+
+func totpLocked(factor *models.TOTPFactor, now time.Time) bool {
+	return factor.TOTPFailedAttempts >= maxTOTPAttempts && factor.TOTPLastFailureAt != nil &&
+		now.Before(factor.TOTPLastFailureAt.Add(totpLockout))
+}
+
+// recordTOTPResult resets the failed attempts after a valid code, and
+// enables a pending secret, or counts an invalid code towards the lockout.
+func recordTOTPResult(tx *gorm.DB, model interface{}, id string, factor *models.TOTPFactor, valid bool, now time.Time) error {
+	var updates map[string]interface{}
+	if valid {
+		updates = map[string]interface{}{
+			"totp_last_counter":    factor.TOTPLastCounter,
+			"totp_failed_attempts": 0,
+			"updated_at":           now,
+		}
+		if factor.TOTPEnabledAt == nil {
+			updates["totp_enabled_at"] = now
+		}
+	} else {
+		failedAttempts := factor.TOTPFailedAttempts
+		if failedAttempts >= maxTOTPAttempts {
+			// The lockout has passed, start counting again
+			failedAttempts = 0
+		}
+		updates = map[string]interface{}{
+			"totp_failed_attempts": failedAttempts + 1,
+			"totp_last_failure_at": now,
+		}
+	}
+
+	if err := tx.Model(model).Where("id = ?", id).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to record TOTP verification: %w", err)
+	}
+	return nil
+}
+
+func disableTOTP(tx *gorm.DB, model interface{}, id string) error {
+	result := tx.Model(model).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"totp_secret":          "",
+			"totp_enabled_at":      nil,
+			"totp_last_counter":    0,
+			"totp_failed_attempts": 0,
+			"totp_last_failure_at": nil,
+			"updated_at":           time.Now(),
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to disable TOTP: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("account not found with id %s", id)
+	}
+	return nil
+}
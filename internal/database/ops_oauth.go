@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wbrijesh/identity/buid"
+	"github.com/wbrijesh/identity/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrAuthorizationCodeNotFound = errors.New("authorization code not found")
+	ErrAuthorizationCodeUsed     = errors.New("authorization code has already been used")
+	ErrAuthorizationCodeExpired  = errors.New("authorization code has expired")
+)
+
+func (s *service) CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	now := time.Now()
+	code.ID = buid.GenerateBUID()
+	code.CreatedAt = now
+	code.UpdatedAt = now
+
+	if err := s.db.WithContext(ctx).Create(code).Error; err != nil {
+		return fmt.Errorf("failed to create authorization code: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeAuthorizationCode marks the code with the given hash as used and
+// returns it. A code can only be consumed once.
+func (s *service) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error) {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var code models.AuthorizationCode
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&code, "code_hash = ?", codeHash).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAuthorizationCodeNotFound
+		}
+		return nil, fmt.Errorf("error fetching authorization code: %w", err)
+	}
+
+	if code.UsedAt != nil {
+		tx.Rollback()
+		return nil, ErrAuthorizationCodeUsed
+	}
+
+	now := time.Now()
+	if now.After(code.ExpiresAt) {
+		tx.Rollback()
+		return nil, ErrAuthorizationCodeExpired
+	}
+
+	code.UsedAt = &now
+	code.UpdatedAt = now
+	if err := tx.Save(&code).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to mark authorization code as used: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &code, nil
+}
@@ -0,0 +1,288 @@
+package database
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wbrijesh/identity/buid"
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrOneTimeTokenNotFound    = errors.New("token not found")
+	ErrOneTimeTokenUsed        = errors.New("token has already been used")
+	ErrOneTimeTokenExpired     = errors.New("token has expired")
+	ErrOneTimeTokenRateLimited = errors.New("too many tokens requested, try again later")
+	ErrOneTimeCodeInvalid      = errors.New("invalid code")
+)
+
+// At most maxOneTimeTokens tokens are issued per subject and purpose within
+// oneTimeTokenWindow, so the endpoints cannot be used to flood a mailbox. A
+// code is burnt after maxOneTimeCodeAttempts wrong guesses.
+const (
+	maxOneTimeTokens       = 5
+	oneTimeTokenWindow     = 15 * time.Minute
+	maxOneTimeCodeAttempts = 5
+)
+
+// CreateOneTimeToken stores a new token and expires the unused tokens of the
+// same subject and purpose. The caller sets the hashes and ExpiresAt.
+func (s *service) CreateOneTimeToken(ctx context.Context, token *models.OneTimeToken) error {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	now := time.Now()
+
+	var issued int64
+	err := tx.Model(&models.OneTimeToken{}).
+		Where("subject_id = ? AND purpose = ? AND created_at > ?", token.SubjectID, token.Purpose, now.Add(-oneTimeTokenWindow)).
+		Count(&issued).Error
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to count tokens: %w", err)
+	}
+	if issued >= maxOneTimeTokens {
+		tx.Rollback()
+		return ErrOneTimeTokenRateLimited
+	}
+
+	err = tx.Model(&models.OneTimeToken{}).
+		Where("subject_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", token.SubjectID, token.Purpose, now).
+		Updates(map[string]interface{}{"expires_at": now, "updated_at": now}).Error
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to expire previous tokens: %w", err)
+	}
+
+	token.ID = buid.GenerateBUID()
+	token.CreatedAt = now
+	token.UpdatedAt = now
+	if err := tx.Create(token).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create token: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// CheckOneTimeToken returns the token with the given hash when it can still
+// be used, without using it, so a request can be checked before the token
+// is consumed, e.g. with ResetPassword.
+func (s *service) CheckOneTimeToken(ctx context.Context, purpose, applicationID, tokenHash string) (*models.OneTimeToken, error) {
+	var token models.OneTimeToken
+	err := s.db.WithContext(ctx).
+		First(&token, "token_hash = ? AND purpose = ? AND application_id = ?", tokenHash, purpose, applicationID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOneTimeTokenNotFound
+		}
+		return nil, fmt.Errorf("error fetching token: %w", err)
+	}
+
+	if err := checkOneTimeTokenUsable(&token, time.Now()); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// CheckOneTimeCode checks a code against the latest token of a subject like
+// ConsumeOneTimeCode, wrong codes counting against the token, but leaves a
+// matching token unused like CheckOneTimeToken.
+func (s *service) CheckOneTimeCode(ctx context.Context, purpose, applicationID, subjectID, code string) (*models.OneTimeToken, error) {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	token, err := matchOneTimeCode(tx, purpose, applicationID, subjectID, code)
+	if err == nil {
+		err = checkOneTimeTokenUsable(token, time.Now())
+	}
+	if err != nil && !errors.Is(err, ErrOneTimeCodeInvalid) {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// A wrong code is recorded as an attempt
+	if commitErr := tx.Commit().Error; commitErr != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", commitErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ConsumeOneTimeToken marks the token with the given hash as used and
+// returns it.
+func (s *service) ConsumeOneTimeToken(ctx context.Context, purpose, applicationID, tokenHash string) (*models.OneTimeToken, error) {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var token models.OneTimeToken
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&token, "token_hash = ? AND purpose = ? AND application_id = ?", tokenHash, purpose, applicationID).Error
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOneTimeTokenNotFound
+		}
+		return nil, fmt.Errorf("error fetching token: %w", err)
+	}
+
+	if err := useOneTimeToken(tx, &token); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &token, nil
+}
+
+// ConsumeOneTimeCode checks a code against the latest token of a subject and
+// marks the token as used when it matches. Wrong codes count against the
+// token, which stops accepting codes after maxOneTimeCodeAttempts.
+func (s *service) ConsumeOneTimeCode(ctx context.Context, purpose, applicationID, subjectID, code string) (*models.OneTimeToken, error) {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	token, err := matchOneTimeCode(tx, purpose, applicationID, subjectID, code)
+	if errors.Is(err, ErrOneTimeCodeInvalid) {
+		// Keep the failed attempt
+		if err := tx.Commit().Error; err != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil, ErrOneTimeCodeInvalid
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := useOneTimeToken(tx, token); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return token, nil
+}
+
+// matchOneTimeCode locks the latest token of a subject and checks a code
+// against it. A wrong code counts as an attempt, which the caller commits
+// before returning ErrOneTimeCodeInvalid.
+func matchOneTimeCode(tx *gorm.DB, purpose, applicationID, subjectID, code string) (*models.OneTimeToken, error) {
+	var token models.OneTimeToken
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("subject_id = ? AND purpose = ? AND application_id = ? AND code_hash <> ''", subjectID, purpose, applicationID).
+		Order("created_at DESC").
+		First(&token).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOneTimeTokenNotFound
+		}
+		return nil, fmt.Errorf("error fetching token: %w", err)
+	}
+
+	if token.Attempts >= maxOneTimeCodeAttempts {
+		return nil, ErrOneTimeCodeInvalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token.CodeHash), []byte(auth.HashOpaqueToken(code))) != 1 {
+		err := tx.Model(&token).Updates(map[string]interface{}{
+			"attempts":   gorm.Expr("attempts + 1"),
+			"updated_at": time.Now(),
+		}).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to record attempt: %w", err)
+		}
+		return nil, ErrOneTimeCodeInvalid
+	}
+
+	return &token, nil
+}
+
+// lockOneTimeToken loads a token by ID for update.
+func lockOneTimeToken(tx *gorm.DB, id string) (*models.OneTimeToken, error) {
+	var token models.OneTimeToken
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&token, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOneTimeTokenNotFound
+		}
+		return nil, fmt.Errorf("error fetching token: %w", err)
+	}
+	return &token, nil
+}
+
+// useOneTimeToken marks a locked token as used, unless it already was or
+// has expired.
+func useOneTimeToken(tx *gorm.DB, token *models.OneTimeToken) error {
+	now := time.Now()
+	if err := checkOneTimeTokenUsable(token, now); err != nil {
+		return err
+	}
+
+	token.UsedAt = &now
+	token.UpdatedAt = now
+	if err := tx.Save(token).Error; err != nil {
+		return fmt.Errorf("failed to mark token as used: %w", err)
+	}
+	return nil
+}
+
+// checkOneTimeTokenUsable reports why a token can't be used anymore.
+func checkOneTimeTokenUsable(token *models.OneTimeToken, now time.Time) error {
+	if token.UsedAt != nil {
+		return ErrOneTimeTokenUsed
+	}
+	if now.After(token.ExpiresAt) {
+		return ErrOneTimeTokenExpired
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wbrijesh/identity/buid"
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/models"
+	"gorm.io/gorm"
+)
+
+// recordPasswordHistory stores the hash of a password that was just set, and
+// drops entries older than the longest history a policy can ask for.
+func recordPasswordHistory(tx *gorm.DB, subjectID, passwordHash string) error {
+	now := time.Now()
+	entry := &models.PasswordHistory{
+		ID:           buid.GenerateBUID(),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		PasswordHash: passwordHash,
+		SubjectID:    subjectID,
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return fmt.Errorf("failed to record password history: %w", err)
+	}
+
+	keep := tx.Model(&models.PasswordHistory{}).
+		Select("id").
+		Where("subject_id = ?", subjectID).
+		Order("created_at DESC").
+		Limit(auth.MaxPasswordHistory)
+	err := tx.Unscoped().
+		Where("subject_id = ? AND id NOT IN (?)", subjectID, keep).
+		Delete(&models.PasswordHistory{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to prune password history: %w", err)
+	}
+
+	return nil
+}
+
+// PasswordInHistory reports whether the password matches one of the last
+// count passwords of a user or admin, the current one included.
+func (s *service) PasswordInHistory(ctx context.Context, subjectID, password string, count int) (bool, error) {
+	if count <= 0 {
+		return false, nil
+	}
+
+	var entries []*models.PasswordHistory
+	err := s.db.WithContext(ctx).
+		Where("subject_id = ?", subjectID).
+		Order("created_at DESC").
+		Limit(count).
+		Find(&entries).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to get password history: %w", err)
+	}
+
+	for _, entry := range entries {
+		if VerifyPassword(entry.PasswordHash, password) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ResetPassword uses a password reset token found by CheckOneTimeToken or
+// CheckOneTimeCode and, in the same transaction, sets the new password of
+// the user or admin it was sent to and revokes their access: every token
+// issued to them before revokedBefore, and the sessions of a user. Receiving
+// the email proves a user owns the address, so it is marked verified too.
+func (s *service) ResetPassword(ctx context.Context, tokenID, password string, revokedBefore time.Time) (*models.OneTimeToken, error) {
+	// Hashed before the transaction, so its locks are not held meanwhile
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	token, err := lockOneTimeToken(tx, tokenID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var subject interface{}
+	switch token.Purpose {
+	case models.OneTimeTokenPasswordReset:
+		subject = &models.User{}
+	case models.OneTimeTokenAdminPasswordReset:
+		subject = &models.Admin{}
+	default:
+		tx.Rollback()
+		return nil, ErrOneTimeTokenNotFound
+	}
+
+	if err := useOneTimeToken(tx, token); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	now := time.Now()
+	result := tx.Model(subject).Where("id = ?", token.SubjectID).
+		Updates(map[string]interface{}{"password_hash": passwordHash, "updated_at": now})
+	if result.Error != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to update password: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return nil, ErrOneTimeTokenNotFound
+	}
+	if err := recordPasswordHistory(tx, token.SubjectID, passwordHash); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if token.Purpose == models.OneTimeTokenPasswordReset {
+		err := tx.Model(&models.User{}).
+			Where("id = ? AND email_verified = ?", token.SubjectID, false).
+			Updates(map[string]interface{}{
+				"email_verified":    true,
+				"email_verified_at": now,
+				"updated_at":        now,
+			}).Error
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to verify email: %w", err)
+		}
+		if err := revokeUserSessions(tx, token.SubjectID, revokedBefore); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	// The subject revokes their own access, so they are recorded as the
+	// revoker
+	err = revokeSubjectTokens(tx, &models.SubjectRevocation{
+		Subject:       token.SubjectID,
+		RevokedBefore: revokedBefore,
+		RevokedBy:     token.SubjectID,
+	})
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return token, nil
+}
+
+// ChangeUserPassword sets a new password for a user and, in the same
+// transaction, revokes every token issued to them before revokedBefore and
+// their sessions created up to then.
+func (s *service) ChangeUserPassword(ctx context.Context, userID, password string, revokedBefore time.Time) error {
+	return s.changePassword(ctx, &models.User{}, userID, password, revokedBefore)
+}
+
+// ChangeAdminPassword sets a new password for an admin and, in the same
+// transaction, revokes every admin token issued before revokedBefore.
+func (s *service) ChangeAdminPassword(ctx context.Context, adminID, password string, revokedBefore time.Time) error {
+	return s.changePassword(ctx, &models.Admin{}, adminID, password, revokedBefore)
+}
+
+func (s *service) changePassword(ctx context.Context, model interface{}, id, password string, revokedBefore time.Time) error {
+	// Hashed before the transaction, so its locks are not held meanwhile
+	passwordHash, err := HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	result := tx.Model(model).Where("id = ?", id).
+		Updates(map[string]interface{}{"password_hash": passwordHash, "updated_at": time.Now()})
+	if result.Error != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update password: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return fmt.Errorf("account not found with id %s", id)
+	}
+	if err := recordPasswordHistory(tx, id, passwordHash); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, ok := model.(*models.User); ok {
+		if err := revokeUserSessions(tx, id, revokedBefore); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// The subject revokes their own access, so they are recorded as the
+	// revoker
+	err = revokeSubjectTokens(tx, &models.SubjectRevocation{
+		Subject:       id,
+		RevokedBefore: revokedBefore,
+		RevokedBy:     id,
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wbrijesh/identity/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrTokenUsed is returned by UseToken for a token that was already used or
+// revoked.
+var ErrTokenUsed = errors.New("token has already been used or revoked")
+
+func (s *service) RevokeToken(ctx context.Context, revokedToken *models.RevokedToken) error {
+	revokedToken.CreatedAt = time.Now()
+
+	// Revoking a token twice is not an error
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(revokedToken).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	return nil
+}
+
+// UseToken revokes a single-use token, such as an admin's MFA challenge, as it
+// is used. Only one of several requests presenting the same token succeeds,
+// the others get ErrTokenUsed.
+func (s *service) UseToken(ctx context.Context, revokedToken *models.RevokedToken) error {
+	revokedToken.CreatedAt = time.Now()
+
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(revokedToken)
+	if result.Error != nil {
+		return fmt.Errorf("failed to use token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrTokenUsed
+	}
+
+	return nil
+}
+
+// RevokeSubjectTokens revokes every token issued to the subject before the
+// given time. An earlier cut-off never replaces a later one.
+func (s *service) RevokeSubjectTokens(ctx context.Context, revocation *models.SubjectRevocation) error {
+	return revokeSubjectTokens(s.db.WithContext(ctx), revocation)
+}
+
+func revokeSubjectTokens(tx *gorm.DB, revocation *models.SubjectRevocation) error {
+	now := time.Now()
+	revocation.CreatedAt = now
+	revocation.UpdatedAt = now
+
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "subject"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"revoked_before": gorm.Expr("GREATEST(subject_revocations.revoked_before, excluded.revoked_before)"),
+			"revoked_by":     gorm.Expr("excluded.revoked_by"),
+			"updated_at":     now,
+		}),
+	}).Create(revocation).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke subject tokens: %w", err)
+	}
+
+	return nil
+}
+
+// ListActiveRevocations returns every revoked token that has not expired yet
+// and every subject revocation, to be cached in process.
+func (s *service) ListActiveRevocations(ctx context.Context) ([]*models.RevokedToken, []*models.SubjectRevocation, error) {
+	var revokedTokens []*models.RevokedToken
+	if err := s.db.WithContext(ctx).Where("expires_at > ?", time.Now()).Find(&revokedTokens).Error; err != nil {
+		return nil, nil, fmt.Errorf("error fetching revoked tokens: %w", err)
+	}
+
+	var subjectRevocations []*models.SubjectRevocation
+	if err := s.db.WithContext(ctx).Find(&subjectRevocations).Error; err != nil {
+		return nil, nil, fmt.Errorf("error fetching subject revocations: %w", err)
+	}
+
+	return revokedTokens, subjectRevocations, nil
+}
@@ -0,0 +1,212 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wbrijesh/identity/buid"
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionRevoked  = errors.New("session has been revoked")
+	ErrSessionExpired  = errors.New("session has expired")
+)
+
+// CreateSession stores a new session and returns its first refresh token.
+func (s *service) CreateSession(ctx context.Context, session *models.Session) (string, error) {
+	now := time.Now()
+	session.ID = buid.GenerateBUID()
+	session.CreatedAt = now
+	session.UpdatedAt = now
+	session.LastUsedAt = now
+
+	refreshToken, refreshTokenHash, err := auth.GenerateSessionRefreshToken(session.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	session.RefreshTokenHash = refreshTokenHash
+
+	if err := s.db.WithContext(ctx).Create(session).Error; err != nil {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return refreshToken, nil
+}
+
+func (s *service) GetSessionByID(ctx context.Context, id string) (*models.Session, error) {
+	var session models.Session
+	if err := s.db.WithContext(ctx).First(&session, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("error fetching session: %w", err)
+	}
+	return &session, nil
+}
+
+// RefreshSession rotates the refresh token of a session and returns the new
+// one. When applicationID is not empty the session must belong to that
+// application.
+//
+// The previous token is accepted during the grace period, so a client can
+// retry a refresh whose response was lost. Any other token of the session has
+// already been rotated out, so it is treated as stolen and the session is
+// revoked.
+func (s *service) RefreshSession(ctx context.Context, refreshToken, applicationID string) (*models.Session, string, error) {
+	sessionID, ok := auth.ParseSessionRefreshToken(refreshToken)
+	if !ok {
+		return nil, "", ErrSessionNotFound
+	}
+
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, "", fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var session models.Session
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&session, "id = ?", sessionID).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrSessionNotFound
+		}
+		return nil, "", fmt.Errorf("error fetching session: %w", err)
+	}
+
+	if applicationID != "" && session.ApplicationID != applicationID {
+		tx.Rollback()
+		return nil, "", ErrSessionNotFound
+	}
+
+	if session.RevokedAt != nil {
+		tx.Rollback()
+		return nil, "", ErrSessionRevoked
+	}
+
+	now := time.Now()
+	if now.After(session.ExpiresAt) {
+		tx.Rollback()
+		return nil, "", ErrSessionExpired
+	}
+
+	// Sessions that were not refreshed within the refresh token TTL of their
+	// application have gone idle
+	policy, err := auth.ApplicationPolicy(session.ApplicationID)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", err
+	}
+	if now.After(session.LastUsedAt.Add(policy.RefreshTokenTTL)) {
+		tx.Rollback()
+		return nil, "", ErrSessionExpired
+	}
+
+	refreshTokenHash := auth.HashOpaqueToken(refreshToken)
+	isCurrent := tokensEqual(session.RefreshTokenHash, refreshTokenHash)
+	inGrace := session.PreviousRefreshTokenHash != "" && tokensEqual(session.PreviousRefreshTokenHash, refreshTokenHash) &&
+		session.PreviousRefreshTokenExpiresAt != nil && now.Before(*session.PreviousRefreshTokenExpiresAt)
+
+	if !isCurrent && !inGrace {
+		// Reuse of a rotated token, revoke the session
+		session.RevokedAt = &now
+		session.RevokedReason = "refresh token reuse detected"
+		session.UpdatedAt = now
+		if err := tx.Save(&session).Error; err != nil {
+			tx.Rollback()
+			return nil, "", fmt.Errorf("failed to revoke session: %w", err)
+		}
+		if err := tx.Commit().Error; err != nil {
+			return nil, "", fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	newRefreshToken, newRefreshTokenHash, err := auth.GenerateSessionRefreshToken(session.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	graceDeadline := now.Add(auth.RefreshTokenGracePeriod())
+	session.PreviousRefreshTokenHash = session.RefreshTokenHash
+	session.PreviousRefreshTokenExpiresAt = &graceDeadline
+	session.RefreshTokenHash = newRefreshTokenHash
+	session.LastUsedAt = now
+	session.UpdatedAt = now
+	if err := tx.Save(&session).Error; err != nil {
+		tx.Rollback()
+		return nil, "", fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &session, newRefreshToken, nil
+}
+
+// ListSessions returns the sessions of a user that are neither revoked nor
+// expired, most recently used first.
+func (s *service) ListSessions(ctx context.Context, userID string, offset, limit int) ([]*models.Session, int64, error) {
+	var sessions []*models.Session
+	var total int64
+
+	query := s.db.WithContext(ctx).Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now())
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("error counting sessions: %w", err)
+	}
+
+	if limit == 0 {
+		offset = 0
+		limit = 20
+	}
+
+	if err := query.Order("last_used_at DESC").Offset(offset).Limit(limit).Find(&sessions).Error; err != nil {
+		return nil, 0, fmt.Errorf("error fetching sessions: %w", err)
+	}
+
+	return sessions, total, nil
+}
+
+func (s *service) RevokeSession(ctx context.Context, id string) error {
+	result := s.db.WithContext(ctx).Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "updated_at": time.Now()})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke session: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes every active session of a user that was created
+// before the given time.
+func (s *service) RevokeUserSessions(ctx context.Context, userID string, createdBefore time.Time) error {
+	return revokeUserSessions(s.db.WithContext(ctx), userID, createdBefore)
+}
+
+func revokeUserSessions(tx *gorm.DB, userID string, createdBefore time.Time) error {
+	err := tx.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL AND created_at <= ?", userID, createdBefore).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "updated_at": time.Now()}).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
@@ -7,11 +7,44 @@ import (
 	"time"
 
 	"github.com/wbrijesh/identity/buid"
+	"github.com/wbrijesh/identity/internal/auth"
 	"github.com/wbrijesh/identity/internal/models"
 	"gorm.io/gorm"
 )
 
+// ErrEmailNotVerified is returned by AuthenticateUser for a correct password
+// when the application requires verified emails and the user has not
+// confirmed theirs.
+var ErrEmailNotVerified = errors.New("email address is not verified")
+
+// ErrEmailTaken is returned when changing an email to one that already
+// belongs to another account.
+var ErrEmailTaken = errors.New("email address is already in use")
+
 func (s *service) CreateUser(ctx context.Context, user *models.User) (*models.ResponseUser, error) {
+	passwordHash, err := HashPassword(user.PasswordHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+	user.PasswordHash = passwordHash
+
+	return s.insertUser(ctx, user, false)
+}
+
+// ImportUser creates a user moved from another system. Unlike CreateUser,
+// PasswordHash is an existing hash in any supported format and is stored as
+// it is, to be replaced by a native hash on the user's first login. A dry
+// run makes every check without creating the user.
+func (s *service) ImportUser(ctx context.Context, user *models.User, dryRun bool) (*models.ResponseUser, error) {
+	if err := auth.ValidatePasswordHash(user.PasswordHash); err != nil {
+		return nil, fmt.Errorf("invalid password hash: %w", err)
+	}
+
+	return s.insertUser(ctx, user, dryRun)
+}
+
+// insertUser creates a user whose password is already hashed.
+func (s *service) insertUser(ctx context.Context, user *models.User, dryRun bool) (*models.ResponseUser, error) {
 	tx := s.db.WithContext(ctx).Begin()
 	if tx.Error != nil {
 		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
@@ -33,12 +66,10 @@ func (s *service) CreateUser(ctx context.Context, user *models.User) (*models.Re
 		return nil, fmt.Errorf("error checking for existing user: %w", err)
 	}
 
-	passwordHash, err := HashPassword(user.PasswordHash)
-	if err != nil {
+	if dryRun {
 		tx.Rollback()
-		return nil, fmt.Errorf("failed to hash password: %w", err)
+		return user.ToResponseUser(), nil
 	}
-	user.PasswordHash = passwordHash
 
 	// Set CreatedAt, UpdatedAt and ID
 	now := time.Now()
@@ -52,6 +83,11 @@ func (s *service) CreateUser(ctx context.Context, user *models.User) (*models.Re
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
+	if err := recordPasswordHistory(tx, user.ID, user.PasswordHash); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
@@ -103,6 +139,16 @@ func (s *service) UpdateUser(ctx context.Context, user *models.User) (*models.Re
 		return nil, fmt.Errorf("error fetching user: %w", err)
 	}
 
+	// Like CreateUser, PasswordHash carries the new plaintext password
+	if user.PasswordHash != "" {
+		passwordHash, err := HashPassword(user.PasswordHash)
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		user.PasswordHash = passwordHash
+	}
+
 	// Update the user
 	user.UpdatedAt = time.Now()
 	if err := tx.Model(&existingUser).Updates(user).Error; err != nil {
@@ -110,6 +156,13 @@ func (s *service) UpdateUser(ctx context.Context, user *models.User) (*models.Re
 		return nil, fmt.Errorf("failed to update user: %w", err)
 	}
 
+	if user.PasswordHash != "" {
+		if err := recordPasswordHistory(tx, existingUser.ID, user.PasswordHash); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
@@ -181,6 +234,108 @@ func (s *service) AuthenticateUser(ctx context.Context, applicationID, email, pa
 	if err := VerifyPassword(user.PasswordHash, password); err != nil {
 		return nil, fmt.Errorf("invalid password")
 	}
+	rehashPassword(s.db.WithContext(ctx), &models.User{}, user.ID, user.PasswordHash, password)
+
+	if !user.EmailVerified {
+		var app models.Application
+		if err := s.db.WithContext(ctx).Select("email_verification").First(&app, "id = ?", applicationID).Error; err != nil {
+			return nil, fmt.Errorf("failed to get application: %w", err)
+		}
+		if app.EmailVerification == models.EmailVerificationRequired {
+			return nil, ErrEmailNotVerified
+		}
+	}
+
+	return user.ToResponseUser(), nil
+}
+
+// MarkUserEmailVerified records that a user confirmed their email address.
+// Verifying an already verified email keeps the original timestamp.
+func (s *service) MarkUserEmailVerified(ctx context.Context, userID string) (*models.ResponseUser, error) {
+	now := time.Now()
+	err := s.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND email_verified = ?", userID, false).
+		Updates(map[string]interface{}{
+			"email_verified":    true,
+			"email_verified_at": now,
+			"updated_at":        now,
+		}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	return s.GetUserByID(ctx, userID)
+}
+
+// CheckUserPassword checks the current password of a user before a change
+// to their account.
+func (s *service) CheckUserPassword(ctx context.Context, userID, password string) error {
+	var user models.User
+	if err := s.db.WithContext(ctx).Select("password_hash").First(&user, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("user not found with id %s", userID)
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if err := VerifyPassword(user.PasswordHash, password); err != nil {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// ChangeUserEmail sets a new email that the user confirmed they received, so
+// it is verified as well.
+func (s *service) ChangeUserEmail(ctx context.Context, userID, email string) (*models.ResponseUser, error) {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var user models.User
+	if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("user not found with id %s", userID)
+		}
+		return nil, fmt.Errorf("error fetching user: %w", err)
+	}
+
+	var taken int64
+	if err := tx.Model(&models.User{}).Where("application_id = ? AND email = ? AND id <> ?", user.ApplicationID, email, userID).Count(&taken).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("error checking for existing user: %w", err)
+	}
+	if taken > 0 {
+		tx.Rollback()
+		return nil, ErrEmailTaken
+	}
+
+	now := time.Now()
+	user.Email = email
+	user.EmailVerified = true
+	user.EmailVerifiedAt = &now
+	user.UpdatedAt = now
+	err := tx.Model(&user).Updates(map[string]interface{}{
+		"email":             user.Email,
+		"email_verified":    true,
+		"email_verified_at": now,
+		"updated_at":        now,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to update user: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
 
 	return user.ToResponseUser(), nil
 }
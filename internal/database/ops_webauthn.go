@@ -0,0 +1,192 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wbrijesh/identity/buid"
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrWebAuthnChallengeNotFound  = errors.New("WebAuthn challenge not found")
+	ErrWebAuthnChallengeUsed      = errors.New("WebAuthn challenge has already been used")
+	ErrWebAuthnChallengeExpired   = errors.New("WebAuthn challenge has expired")
+	ErrWebAuthnCredentialNotFound = errors.New("passkey not found")
+	ErrWebAuthnCredentialExists   = errors.New("passkey is already registered")
+	ErrWebAuthnSignCount          = errors.New("passkey signature counter went backwards, it may have been cloned")
+)
+
+// CreateWebAuthnChallenge stores the hash of a new ceremony challenge.
+func (s *service) CreateWebAuthnChallenge(ctx context.Context, challenge *models.WebAuthnChallenge) error {
+	now := time.Now()
+	challenge.ID = buid.GenerateBUID()
+	challenge.CreatedAt = now
+	challenge.UpdatedAt = now
+	challenge.ExpiresAt = now.Add(auth.WebAuthnChallengeTTL)
+
+	if err := s.db.WithContext(ctx).Create(challenge).Error; err != nil {
+		return fmt.Errorf("failed to create WebAuthn challenge: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeWebAuthnChallenge marks the challenge with the given hash as used
+// and returns it. A challenge can only be consumed once, and only by the
+// ceremony it was created for.
+func (s *service) ConsumeWebAuthnChallenge(ctx context.Context, challengeHash, ceremony string) (*models.WebAuthnChallenge, error) {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var challenge models.WebAuthnChallenge
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&challenge, "challenge_hash = ?", challengeHash).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebAuthnChallengeNotFound
+		}
+		return nil, fmt.Errorf("error fetching WebAuthn challenge: %w", err)
+	}
+
+	if challenge.Ceremony != ceremony {
+		tx.Rollback()
+		return nil, ErrWebAuthnChallengeNotFound
+	}
+	if challenge.UsedAt != nil {
+		tx.Rollback()
+		return nil, ErrWebAuthnChallengeUsed
+	}
+
+	now := time.Now()
+	if now.After(challenge.ExpiresAt) {
+		tx.Rollback()
+		return nil, ErrWebAuthnChallengeExpired
+	}
+
+	challenge.UsedAt = &now
+	challenge.UpdatedAt = now
+	if err := tx.Save(&challenge).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to mark WebAuthn challenge as used: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &challenge, nil
+}
+
+// CreateWebAuthnCredential stores a passkey registered by a user.
+func (s *service) CreateWebAuthnCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	now := time.Now()
+	credential.ID = buid.GenerateBUID()
+	credential.CreatedAt = now
+	credential.UpdatedAt = now
+
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(credential)
+	if result.Error != nil {
+		return fmt.Errorf("failed to create passkey: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrWebAuthnCredentialExists
+	}
+
+	return nil
+}
+
+// GetWebAuthnCredential returns the passkey with the given credential ID
+// registered with an application.
+func (s *service) GetWebAuthnCredential(ctx context.Context, applicationID, credentialID string) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	err := s.db.WithContext(ctx).
+		First(&credential, "application_id = ? AND credential_id = ?", applicationID, credentialID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebAuthnCredentialNotFound
+		}
+		return nil, fmt.Errorf("error fetching passkey: %w", err)
+	}
+	return &credential, nil
+}
+
+// ListWebAuthnCredentials returns the passkeys of a user, oldest first.
+func (s *service) ListWebAuthnCredentials(ctx context.Context, userID string) ([]*models.WebAuthnCredential, error) {
+	var credentials []*models.WebAuthnCredential
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&credentials).Error; err != nil {
+		return nil, fmt.Errorf("failed to list passkeys: %w", err)
+	}
+	return credentials, nil
+}
+
+// UseWebAuthnCredential records a login with a passkey. The signature
+// counter has to grow with every login, unless the authenticator does not
+// keep one and always reports zero.
+func (s *service) UseWebAuthnCredential(ctx context.Context, id string, signCount uint32) error {
+	tx := s.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var credential models.WebAuthnCredential
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&credential, "id = ?", id).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrWebAuthnCredentialNotFound
+		}
+		return fmt.Errorf("error fetching passkey: %w", err)
+	}
+
+	if (signCount != 0 || credential.SignCount != 0) && int64(signCount) <= credential.SignCount {
+		tx.Rollback()
+		return ErrWebAuthnSignCount
+	}
+
+	now := time.Now()
+	err := tx.Model(&credential).Updates(map[string]interface{}{
+		"sign_count":   int64(signCount),
+		"last_used_at": now,
+		"updated_at":   now,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update passkey: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteWebAuthnCredential removes a passkey of a user.
+func (s *service) DeleteWebAuthnCredential(ctx context.Context, userID, id string) error {
+	result := s.db.WithContext(ctx).Unscoped().Where("id = ? AND user_id = ?", id, userID).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete passkey: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrWebAuthnCredentialNotFound
+	}
+	return nil
+}
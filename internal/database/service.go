@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"github.com/wbrijesh/identity/internal/models"
 )
@@ -19,6 +20,8 @@ type Service interface {
 	UpdateAdmin(ctx context.Context, admin *models.Admin) (*models.ResponseAdmin, error)
 	DeleteAdmin(ctx context.Context, id string) error
 	ListAdmins(ctx context.Context, offset, limit int) ([]*models.ResponseAdmin, int64, error)
+	CheckAdminPassword(ctx context.Context, adminID, password string) error
+	ChangeAdminEmail(ctx context.Context, adminID, email string) (*models.ResponseAdmin, error)
 
 	// Application CRUD operations
 	CreateApplication(ctx context.Context, app *models.Application) (*models.Application, error)
@@ -27,8 +30,15 @@ type Service interface {
 	UpdateApplication(ctx context.Context, app *models.Application) (*models.Application, error)
 	DeleteApplication(ctx context.Context, id string) error
 	ListApplications(ctx context.Context, offset int, limit int, adminID string) ([]*models.Application, int64, error)
-	GenerateRefreshToken(ctx context.Context, id string) (string, error)
-	DeleteRefreshToken(ctx context.Context, id string) error
+
+	// Application credential operations
+	CreateCredential(ctx context.Context, credential *models.ApplicationCredential) (string, error)
+	GetCredential(ctx context.Context, applicationID, id string) (*models.ApplicationCredential, error)
+	ListCredentials(ctx context.Context, applicationID string, offset, limit int) ([]*models.ApplicationCredential, int64, error)
+	UpdateCredential(ctx context.Context, credential *models.ApplicationCredential) (*models.ApplicationCredential, error)
+	RevokeCredential(ctx context.Context, applicationID, id, revokedBy string) (time.Time, error)
+	RotateCredential(ctx context.Context, applicationID, id string) (string, error)
+	UseRefreshToken(ctx context.Context, refreshToken string, rotate bool, requestedScopes []string) (*models.ApplicationCredential, string, error)
 
 	// User CRUD operations
 	CreateUser(ctx context.Context, user *models.User) (*models.ResponseUser, error)
@@ -37,6 +47,63 @@ type Service interface {
 	UpdateUser(ctx context.Context, user *models.User) (*models.ResponseUser, error)
 	DeleteUser(ctx context.Context, id string) error
 	ListUsers(ctx context.Context, applicationID string, offset, limit int) ([]*models.ResponseUser, int64, error)
+	MarkUserEmailVerified(ctx context.Context, userID string) (*models.ResponseUser, error)
+	CheckUserPassword(ctx context.Context, userID, password string) error
+	ChangeUserEmail(ctx context.Context, userID, email string) (*models.ResponseUser, error)
+	ImportUser(ctx context.Context, user *models.User, dryRun bool) (*models.ResponseUser, error)
+
+	// OAuth operations
+	CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error)
+
+	// Session operations
+	CreateSession(ctx context.Context, session *models.Session) (string, error)
+	GetSessionByID(ctx context.Context, id string) (*models.Session, error)
+	RefreshSession(ctx context.Context, refreshToken, applicationID string) (*models.Session, string, error)
+	ListSessions(ctx context.Context, userID string, offset, limit int) ([]*models.Session, int64, error)
+	RevokeSession(ctx context.Context, id string) error
+	RevokeUserSessions(ctx context.Context, userID string, createdBefore time.Time) error
+
+	// MFA operations
+	CreateMFAChallenge(ctx context.Context, challenge *models.MFAChallenge) (string, error)
+	GetMFAChallenge(ctx context.Context, token string) (*models.MFAChallenge, error)
+	ConsumeMFAChallenge(ctx context.Context, id string) error
+	EnrollTOTP(ctx context.Context, userID string) (string, error)
+	VerifyUserTOTP(ctx context.Context, userID, code string, allowPending bool) error
+	DisableTOTP(ctx context.Context, userID string) error
+	EnrollAdminTOTP(ctx context.Context, adminID string) (string, error)
+	VerifyAdminTOTP(ctx context.Context, adminID, code string, allowPending bool) error
+	DisableAdminTOTP(ctx context.Context, adminID string) error
+	ReplaceAdminRecoveryCodes(ctx context.Context, adminID string, codeHashes []string) error
+	UseAdminRecoveryCode(ctx context.Context, adminID, code string) error
+
+	// WebAuthn operations
+	CreateWebAuthnChallenge(ctx context.Context, challenge *models.WebAuthnChallenge) error
+	ConsumeWebAuthnChallenge(ctx context.Context, challengeHash, ceremony string) (*models.WebAuthnChallenge, error)
+	CreateWebAuthnCredential(ctx context.Context, credential *models.WebAuthnCredential) error
+	GetWebAuthnCredential(ctx context.Context, applicationID, credentialID string) (*models.WebAuthnCredential, error)
+	ListWebAuthnCredentials(ctx context.Context, userID string) ([]*models.WebAuthnCredential, error)
+	UseWebAuthnCredential(ctx context.Context, id string, signCount uint32) error
+	DeleteWebAuthnCredential(ctx context.Context, userID, id string) error
+
+	// One-time token operations
+	CreateOneTimeToken(ctx context.Context, token *models.OneTimeToken) error
+	CheckOneTimeToken(ctx context.Context, purpose, applicationID, tokenHash string) (*models.OneTimeToken, error)
+	CheckOneTimeCode(ctx context.Context, purpose, applicationID, subjectID, code string) (*models.OneTimeToken, error)
+	ConsumeOneTimeToken(ctx context.Context, purpose, applicationID, tokenHash string) (*models.OneTimeToken, error)
+	ConsumeOneTimeCode(ctx context.Context, purpose, applicationID, subjectID, code string) (*models.OneTimeToken, error)
+
+	// Password operations
+	PasswordInHistory(ctx context.Context, subjectID, password string, count int) (bool, error)
+	ResetPassword(ctx context.Context, tokenID, password string, revokedBefore time.Time) (*models.OneTimeToken, error)
+	ChangeUserPassword(ctx context.Context, userID, password string, revokedBefore time.Time) error
+	ChangeAdminPassword(ctx context.Context, adminID, password string, revokedBefore time.Time) error
+
+	// Revocation operations
+	RevokeToken(ctx context.Context, revokedToken *models.RevokedToken) error
+	UseToken(ctx context.Context, revokedToken *models.RevokedToken) error
+	RevokeSubjectTokens(ctx context.Context, revocation *models.SubjectRevocation) error
+	ListActiveRevocations(ctx context.Context) ([]*models.RevokedToken, []*models.SubjectRevocation, error)
 
 	// Additional utility methods
 	AuthenticateAdmin(ctx context.Context, email, password string) (*models.ResponseAdmin, error)
@@ -1,36 +1,52 @@
 package middleware
 
 import (
-	"context"
 	"net/http"
 	"strings"
 
 	"github.com/wbrijesh/identity/internal/auth"
 )
 
-func AcessTokenAuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header is required", http.StatusUnauthorized)
-			return
-		}
+func AcessTokenAuthMiddleware(revocations RevocationChecker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				http.Error(w, "Authorization header is required", http.StatusUnauthorized)
+				return
+			}
 
-		bearerToken := strings.Split(authHeader, " ")
-		if len(bearerToken) != 2 || strings.ToLower(bearerToken[0]) != "bearer" {
-			http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
-			return
-		}
+			bearerToken := strings.Split(authHeader, " ")
+			if len(bearerToken) != 2 || strings.ToLower(bearerToken[0]) != "bearer" {
+				http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+				return
+			}
 
-		tokenString := bearerToken[1]
-		adminID, err := auth.ValidateAccessToken(tokenString)
-		if err != nil {
-			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
-			return
-		}
+			tokenString := bearerToken[1]
+			token, err := auth.ValidateAccessToken(tokenString)
+			if err != nil {
+				http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+				return
+			}
 
-		// Add the admin to the request context
-		ctx := context.WithValue(r.Context(), "adminID", adminID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+			revoked, err := revocations.IsRevoked(r.Context(), token)
+			if err != nil {
+				http.Error(w, "Failed to check token revocation", http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+				return
+			}
+
+			// Add the application to the request context
+			ctx := WithPrincipal(r.Context(), &Principal{
+				Kind:          PrincipalApplication,
+				ApplicationID: token.ApplicationID,
+				Scopes:        auth.ParseScope(token.Scope),
+				Token:         token,
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
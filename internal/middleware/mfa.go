@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/wbrijesh/identity/internal/auth"
+)
+
+// RequireAdminMFA rejects admins whose token was issued without a second
+// factor when required is set. Other principals pass.
+func RequireAdminMFA(required bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if required && principal.Kind == PrincipalAdmin && !slices.Contains(principal.AuthMethods, auth.AuthMethodMFA) {
+				http.Error(w, "MFA is required, enroll TOTP and log in again", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
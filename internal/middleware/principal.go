@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/wbrijesh/identity/internal/auth"
+)
+
+// PrincipalKind tells what kind of caller authenticated a request
+type PrincipalKind string
+
+const (
+	PrincipalAdmin       PrincipalKind = "admin"
+	PrincipalApplication PrincipalKind = "application"
+	PrincipalUser        PrincipalKind = "user"
+)
+
+// Principal is the authenticated caller of a request. AdminID is set for
+// admins, ApplicationID for applications and users, UserID and SessionID for
+// users. AuthMethods lists how an admin authenticated.
+type Principal struct {
+	Kind          PrincipalKind
+	AdminID       string
+	ApplicationID string
+	UserID        string
+	SessionID     string
+	Scopes        []string
+	AuthMethods   []string
+
+	// The token the principal authenticated with
+	Token *auth.TokenInfo
+}
+
+// HasScope reports whether the principal was granted the scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// principalKey is private so only this package can set the principal
+type principalKey struct{}
+
+// WithPrincipal returns a copy of the context carrying the principal.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal set by the auth middlewares.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
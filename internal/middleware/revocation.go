@@ -0,0 +1,13 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/wbrijesh/identity/internal/auth"
+)
+
+// RevocationChecker is consulted by the auth middlewares after a token has
+// been validated, so revoked tokens are rejected before they expire.
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, token *auth.TokenInfo) (bool, error)
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/wbrijesh/identity/internal/auth"
+)
+
+// RequireScope rejects requests from applications whose access token was not
+// granted the scope. Admins act on their own applications and are not limited
+// by scopes.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if principal.Kind == PrincipalApplication && !principal.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+				http.Error(w, fmt.Sprintf("Access token is missing the required scope %q", scope), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AdminOrAccessTokenAuthMiddleware accepts either an application access token
+// or an admin token, for routes both an application and its owner can call.
+func AdminOrAccessTokenAuthMiddleware(revocations RevocationChecker) func(http.Handler) http.Handler {
+	adminAuth := AdminAuthMiddleware(revocations)
+	accessTokenAuth := AcessTokenAuthMiddleware(revocations)
+
+	return func(next http.Handler) http.Handler {
+		admin := adminAuth(next)
+		application := accessTokenAuth(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Anything that is not an access token, including a missing or
+			// malformed header, gets the admin middleware's response
+			bearerToken := strings.Split(r.Header.Get("Authorization"), " ")
+			if len(bearerToken) == 2 && strings.ToLower(bearerToken[0]) == "bearer" {
+				if _, err := auth.ValidateAccessToken(bearerToken[1]); err == nil {
+					application.ServeHTTP(w, r)
+					return
+				}
+			}
+			admin.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/wbrijesh/identity/internal/auth"
+)
+
+func UserAuthMiddleware(revocations RevocationChecker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				http.Error(w, "Authorization header is required", http.StatusUnauthorized)
+				return
+			}
+
+			bearerToken := strings.Split(authHeader, " ")
+			if len(bearerToken) != 2 || strings.ToLower(bearerToken[0]) != "bearer" {
+				http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+				return
+			}
+
+			tokenString := bearerToken[1]
+			claims, err := auth.ValidateUserJWT(tokenString)
+			if err != nil {
+				http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+				return
+			}
+			token, err := claims.Info()
+			if err != nil {
+				http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+				return
+			}
+
+			revoked, err := revocations.IsRevoked(r.Context(), token)
+			if err != nil {
+				http.Error(w, "Failed to check token revocation", http.StatusInternalServerError)
+				return
+			}
+			if revoked {
+				http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+				return
+			}
+
+			// Add the user to the request context
+			ctx := WithPrincipal(r.Context(), &Principal{
+				Kind:          PrincipalUser,
+				ApplicationID: claims.ApplicationID,
+				UserID:        claims.Subject,
+				SessionID:     claims.SessionID,
+				Token:         token,
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
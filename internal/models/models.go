@@ -18,9 +18,40 @@ type Admin struct {
 	FirstName    string `json:"FirstName"`
 	LastName     string `json:"LastName"`
 
+	TOTPFactor `gorm:"embedded"`
+
 	Applications []Application `gorm:"foreignKey:AdminID" json:"Applications,omitempty"`
 }
 
+// TOTPFactor is the TOTP second factor of an admin or user. The secret is
+// set on enrollment, encrypted with the secret key ring, and only accepted at
+// login once TOTPEnabledAt is set.
+// TOTPLastCounter is the last time step used, so a code cannot be replayed.
+// Verification is locked for a while after too many failed attempts in a
+// row.
+type TOTPFactor struct {
+	TOTPSecret         string     `json:"-"`
+	TOTPEnabledAt      *time.Time `json:"-"`
+	TOTPLastCounter    int64      `json:"-"`
+	TOTPFailedAttempts int        `json:"-"`
+	TOTPLastFailureAt  *time.Time `json:"-"`
+}
+
+// AdminRecoveryCode is a one-time code an admin can use instead of a TOTP
+// code, e.g. after losing their device. Only a hash of the code is stored.
+type AdminRecoveryCode struct {
+	gorm.Model
+
+	ID        string    `gorm:"primaryKey;default:gen_random_uuid()" json:"ID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+
+	CodeHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	UsedAt   *time.Time `json:"UsedAt"`
+
+	AdminID string `gorm:"not null;index" json:"AdminID"`
+}
+
 type Application struct {
 	gorm.Model
 
@@ -34,9 +65,123 @@ type Application struct {
 	AdminID string `gorm:"not null" json:"AdminID"`
 	Admin   *Admin `gorm:"foreignKey:AdminID" json:"Admin,omitempty"`
 
-	RefreshToken string `json:"RefreshToken"`
+	// Redirect URIs registered for the authorization code flow
+	RedirectURIs []string `gorm:"type:jsonb;serializer:json" json:"RedirectURIs"`
+
+	// Lifetimes, signing algorithm and issuer of the tokens issued to the
+	// application's users, nil uses the defaults
+	TokenPolicy *TokenPolicy `gorm:"type:jsonb;serializer:json" json:"TokenPolicy"`
+
+	// Rules for the passwords of the application's users, nil uses the
+	// defaults
+	PasswordPolicy *PasswordPolicy `gorm:"type:jsonb;serializer:json" json:"PasswordPolicy"`
+
+	// Whether the application's users can or must use a second factor, one
+	// of the MFAPolicy constants
+	MFAPolicy string `gorm:"not null;default:optional" json:"MFAPolicy"`
 
-	Users []User `gorm:"foreignKey:ApplicationID" json:"Users,omitempty"`
+	// Whether users have to verify their email before they can log in with
+	// their password, one of the EmailVerification constants
+	EmailVerification string `gorm:"not null;default:optional" json:"EmailVerification"`
+
+	// Relying party ID and origins passkeys are registered and used with.
+	// Passkeys are turned off while WebAuthnRPID is empty.
+	WebAuthnRPID    string   `json:"WebAuthnRPID"`
+	WebAuthnOrigins []string `gorm:"type:jsonb;serializer:json" json:"WebAuthnOrigins"`
+
+	Users       []User                  `gorm:"foreignKey:ApplicationID" json:"Users,omitempty"`
+	Credentials []ApplicationCredential `gorm:"foreignKey:ApplicationID" json:"Credentials,omitempty"`
+}
+
+// TokenPolicy overrides how tokens are issued for an application. Zero fields
+// use the defaults. UserTokenTTLSeconds is the lifetime of user access tokens,
+// RefreshTokenTTLSeconds of credential refresh tokens and of an unused session,
+// and SessionLifetimeSeconds the absolute lifetime of a session. The signing
+// algorithm and issuer apply to user and ID tokens.
+type TokenPolicy struct {
+	UserTokenTTLSeconds    int    `json:"UserTokenTTLSeconds,omitempty"`
+	RefreshTokenTTLSeconds int    `json:"RefreshTokenTTLSeconds,omitempty"`
+	SessionLifetimeSeconds int    `json:"SessionLifetimeSeconds,omitempty"`
+	SigningAlgorithm       string `json:"SigningAlgorithm,omitempty"`
+	Issuer                 string `json:"Issuer,omitempty"`
+}
+
+// PasswordPolicy sets the rules new passwords have to follow. Zero fields use
+// the defaults. MinLength counts characters and MaxLength bytes, as bcrypt
+// ignores anything past 72 bytes. HistoryCount is how many previous
+// passwords cannot be reused, and CheckBreached rejects passwords on the
+// server's breached password list.
+type PasswordPolicy struct {
+	MinLength        int  `json:"MinLength,omitempty"`
+	MaxLength        int  `json:"MaxLength,omitempty"`
+	RequireUppercase bool `json:"RequireUppercase,omitempty"`
+	RequireLowercase bool `json:"RequireLowercase,omitempty"`
+	RequireDigit     bool `json:"RequireDigit,omitempty"`
+	RequireSymbol    bool `json:"RequireSymbol,omitempty"`
+	HistoryCount     int  `json:"HistoryCount,omitempty"`
+	CheckBreached    bool `json:"CheckBreached,omitempty"`
+}
+
+// PasswordHistory keeps the hashes of the passwords an account used, so a
+// password policy can refuse them. SubjectID is a user or admin.
+type PasswordHistory struct {
+	gorm.Model
+
+	ID        string    `gorm:"primaryKey;default:gen_random_uuid()" json:"ID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+
+	PasswordHash string `gorm:"not null" json:"-"`
+	SubjectID    string `gorm:"not null;index" json:"SubjectID"`
+}
+
+// MFA policies of an application. With MFAPolicyOff users log in with their
+// password only, even if they enrolled a second factor. With
+// MFAPolicyRequired users without a second factor have to enroll one when
+// they log in.
+const (
+	MFAPolicyOff      = "off"
+	MFAPolicyOptional = "optional"
+	MFAPolicyRequired = "required"
+)
+
+// Email verification settings of an application. Users are always sent a
+// verification email, with EmailVerificationRequired they cannot log in
+// with their password until they confirm it.
+const (
+	EmailVerificationOptional = "optional"
+	EmailVerificationRequired = "required"
+)
+
+// ApplicationCredential is one of the API credentials of an application. Each
+// credential has its own refresh token, so revoking one does not affect the
+// others.
+type ApplicationCredential struct {
+	gorm.Model
+
+	ID        string    `gorm:"primaryKey;default:gen_random_uuid()" json:"ID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+
+	Label      string     `gorm:"not null" json:"Label"`
+	Scopes     []string   `gorm:"type:jsonb;serializer:json" json:"Scopes"`
+	ExpiresAt  *time.Time `json:"ExpiresAt"`
+	LastUsedAt *time.Time `json:"LastUsedAt"`
+	RevokedAt  *time.Time `json:"RevokedAt,omitempty"`
+
+	// Only hashes of the refresh tokens are stored, RefreshTokenPrefix tells
+	// the current token apart in listings. The previous refresh token stays
+	// valid until PreviousRefreshTokenExpiresAt so clients can retry a
+	// refresh whose response was lost. RefreshTokenFamily links every token
+	// rotated from the same original, presenting a token of the current family
+	// that has already been rotated out revokes the family.
+	RefreshTokenPrefix            string     `json:"RefreshTokenPrefix"`
+	RefreshTokenHash              string     `gorm:"index" json:"-"`
+	PreviousRefreshTokenHash      string     `json:"-"`
+	PreviousRefreshTokenExpiresAt *time.Time `json:"-"`
+	RefreshTokenFamily            string     `json:"-"`
+
+	ApplicationID string `gorm:"not null;index" json:"ApplicationID"`
 }
 
 type User struct {
@@ -51,18 +196,201 @@ type User struct {
 	FirstName    string `json:"FirstName"`
 	LastName     string `json:"LastName"`
 
+	EmailVerified   bool       `gorm:"not null;default:false" json:"EmailVerified"`
+	EmailVerifiedAt *time.Time `json:"EmailVerifiedAt"`
+
+	TOTPFactor `gorm:"embedded"`
+
 	ApplicationID string       `gorm:"not null" json:"ApplicationID"`
 	Application   *Application `gorm:"foreignKey:ApplicationID" json:"Application,omitempty"`
 }
 
+// MFAChallenge is handed out when a user's password was accepted but a
+// second factor is still needed. Completing the challenge starts the session
+// with the details of the original login. With Enrollment set the user has
+// no second factor yet and enrolls one to complete the challenge.
+type MFAChallenge struct {
+	gorm.Model
+
+	ID        string    `gorm:"primaryKey;default:gen_random_uuid()" json:"ID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+
+	TokenHash  string `gorm:"uniqueIndex;not null" json:"-"`
+	Enrollment bool   `json:"Enrollment"`
+	Nonce      string `json:"Nonce"`
+	UserAgent  string `json:"UserAgent"`
+	IPAddress  string `json:"IPAddress"`
+
+	ExpiresAt time.Time  `gorm:"not null" json:"ExpiresAt"`
+	UsedAt    *time.Time `json:"UsedAt"`
+
+	UserID        string `gorm:"not null;index" json:"UserID"`
+	ApplicationID string `gorm:"not null" json:"ApplicationID"`
+}
+
+// WebAuthnCredential is a passkey registered by a user. CredentialID is the
+// base64url encoded credential ID and PublicKey the COSE key sent by the
+// authenticator. SignCount is the authenticator's signature counter, which
+// only grows unless the authenticator does not keep one.
+type WebAuthnCredential struct {
+	gorm.Model
+
+	ID        string    `gorm:"primaryKey;default:gen_random_uuid()" json:"ID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+
+	Name         string     `json:"Name"`
+	CredentialID string     `gorm:"not null;uniqueIndex:idx_webauthn_credential" json:"CredentialID"`
+	PublicKey    []byte     `gorm:"not null" json:"-"`
+	Algorithm    int        `gorm:"not null" json:"Algorithm"`
+	SignCount    int64      `gorm:"not null" json:"SignCount"`
+	Transports   []string   `gorm:"type:jsonb;serializer:json" json:"Transports"`
+	LastUsedAt   *time.Time `json:"LastUsedAt"`
+
+	UserID        string `gorm:"not null;index" json:"UserID"`
+	ApplicationID string `gorm:"not null;uniqueIndex:idx_webauthn_credential" json:"ApplicationID"`
+}
+
+// WebAuthnChallenge is the challenge of a passkey registration or login
+// ceremony. Only its hash is stored, the browser echoes the challenge back in
+// the client data, where it is looked up. UserID is empty for logins that
+// let the user pick any of their passkeys.
+type WebAuthnChallenge struct {
+	gorm.Model
+
+	ID        string    `gorm:"primaryKey;default:gen_random_uuid()" json:"ID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+
+	ChallengeHash string `gorm:"uniqueIndex;not null" json:"-"`
+	Ceremony      string `gorm:"not null" json:"Ceremony"`
+
+	ExpiresAt time.Time  `gorm:"not null" json:"ExpiresAt"`
+	UsedAt    *time.Time `json:"UsedAt"`
+
+	UserID        string `json:"UserID"`
+	ApplicationID string `gorm:"not null" json:"ApplicationID"`
+}
+
+// Purposes of one-time tokens
+const (
+	OneTimeTokenLogin              = "login"
+	OneTimeTokenEmailVerification  = "email_verification"
+	OneTimeTokenPasswordReset      = "password_reset"
+	OneTimeTokenAdminPasswordReset = "admin_password_reset"
+	OneTimeTokenEmailChange        = "email_change"
+	OneTimeTokenAdminEmailChange   = "admin_email_change"
+)
+
+// OneTimeToken is a short-lived secret sent to someone by email, as a link
+// token and optionally a short code, both stored hashed. SubjectID is the
+// user or admin it was sent to, ApplicationID is empty for admins, and
+// PendingEmail is the new address of an email change. Issuing a new token
+// for a purpose expires the unused ones of the same subject.
+type OneTimeToken struct {
+	gorm.Model
+
+	ID        string    `gorm:"primaryKey;default:gen_random_uuid()" json:"ID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+
+	Purpose   string `gorm:"not null;index:idx_one_time_token_subject" json:"Purpose"`
+	TokenHash string `gorm:"uniqueIndex;not null" json:"-"`
+	CodeHash  string `json:"-"`
+	Attempts  int    `gorm:"not null;default:0" json:"Attempts"`
+
+	PendingEmail string `json:"PendingEmail"`
+
+	ExpiresAt time.Time  `gorm:"not null" json:"ExpiresAt"`
+	UsedAt    *time.Time `json:"UsedAt"`
+
+	SubjectID     string `gorm:"not null;index:idx_one_time_token_subject" json:"SubjectID"`
+	ApplicationID string `json:"ApplicationID"`
+}
+
+type AuthorizationCode struct {
+	gorm.Model
+
+	ID        string    `gorm:"primaryKey;default:gen_random_uuid()" json:"ID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+
+	CodeHash            string `gorm:"uniqueIndex;not null" json:"-"`
+	RedirectURI         string `gorm:"not null" json:"RedirectURI"`
+	Scope               string `json:"Scope"`
+	Nonce               string `json:"Nonce"`
+	CodeChallenge       string `gorm:"not null" json:"-"`
+	CodeChallengeMethod string `gorm:"not null" json:"CodeChallengeMethod"`
+
+	ExpiresAt time.Time  `gorm:"not null" json:"ExpiresAt"`
+	UsedAt    *time.Time `json:"UsedAt"`
+
+	ApplicationID string `gorm:"not null;index" json:"ApplicationID"`
+	UserID        string `gorm:"not null" json:"UserID"`
+}
+
+type Session struct {
+	gorm.Model
+
+	ID        string    `gorm:"primaryKey;default:gen_random_uuid()" json:"ID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+
+	UserAgent  string     `json:"UserAgent"`
+	IPAddress  string     `json:"IPAddress"`
+	LastUsedAt time.Time  `json:"LastUsedAt"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"ExpiresAt"`
+	RevokedAt  *time.Time `json:"RevokedAt,omitempty"`
+
+	// Reason the session was revoked, e.g. refresh token reuse
+	RevokedReason string `json:"RevokedReason,omitempty"`
+
+	// Only hashes of the refresh tokens are stored. The previous token stays
+	// valid until PreviousRefreshTokenExpiresAt to allow clients to retry a
+	// refresh whose response was lost.
+	RefreshTokenHash              string     `gorm:"uniqueIndex;not null" json:"-"`
+	PreviousRefreshTokenHash      string     `json:"-"`
+	PreviousRefreshTokenExpiresAt *time.Time `json:"-"`
+
+	UserID        string `gorm:"not null;index" json:"UserID"`
+	ApplicationID string `gorm:"not null;index" json:"ApplicationID"`
+}
+
+// RevokedToken blocks a single token by its jti until the token would have
+// expired anyway.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey" json:"JTI"`
+	CreatedAt time.Time `json:"CreatedAt"`
+
+	Subject   string    `gorm:"index" json:"Subject"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"ExpiresAt"`
+	RevokedBy string    `json:"RevokedBy"`
+}
+
+// SubjectRevocation blocks every token issued to a subject (an admin,
+// application or user ID) before RevokedBefore. Subject is also the ID of a
+// revoked application credential, blocking the access tokens issued from it.
+type SubjectRevocation struct {
+	Subject   string    `gorm:"primaryKey" json:"Subject"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
+
+	RevokedBefore time.Time `gorm:"not null" json:"RevokedBefore"`
+	RevokedBy     string    `json:"RevokedBy"`
+}
+
 type ResponseUser struct {
 	ID        string    `gorm:"primaryKey;default:gen_random_uuid()" json:"ID"`
 	CreatedAt time.Time `json:"CreatedAt"`
 	UpdatedAt time.Time `json:"UpdatedAt"`
 
-	Email     string `gorm:"uniqueIndex;not null" json:"Email"`
-	FirstName string `json:"FirstName"`
-	LastName  string `json:"LastName"`
+	Email           string     `gorm:"uniqueIndex;not null" json:"Email"`
+	EmailVerified   bool       `json:"EmailVerified"`
+	EmailVerifiedAt *time.Time `json:"EmailVerifiedAt"`
+	FirstName       string     `json:"FirstName"`
+	LastName        string     `json:"LastName"`
+	MFAEnabled      bool       `json:"MFAEnabled"`
 
 	ApplicationID string       `gorm:"not null" json:"ApplicationID"`
 	Application   *Application `gorm:"foreignKey:ApplicationID" json:"Application,omitempty"`
@@ -73,23 +401,27 @@ type ResponseAdmin struct {
 	CreatedAt time.Time `json:"CreatedAt"`
 	UpdatedAt time.Time `json:"UpdatedAt"`
 
-	Email     string `gorm:"uniqueIndex;not null" json:"Email"`
-	FirstName string `json:"FirstName"`
-	LastName  string `json:"LastName"`
+	Email      string `gorm:"uniqueIndex;not null" json:"Email"`
+	FirstName  string `json:"FirstName"`
+	LastName   string `json:"LastName"`
+	MFAEnabled bool   `json:"MFAEnabled"`
 
 	Applications []Application `gorm:"foreignKey:AdminID" json:"Applications,omitempty"`
 }
 
 func (u *User) ToResponseUser() *ResponseUser {
 	return &ResponseUser{
-		ID:            u.ID,
-		CreatedAt:     u.CreatedAt,
-		UpdatedAt:     u.UpdatedAt,
-		Email:         u.Email,
-		FirstName:     u.FirstName,
-		LastName:      u.LastName,
-		ApplicationID: u.ApplicationID,
-		Application:   u.Application,
+		ID:              u.ID,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+		Email:           u.Email,
+		EmailVerified:   u.EmailVerified,
+		EmailVerifiedAt: u.EmailVerifiedAt,
+		FirstName:       u.FirstName,
+		LastName:        u.LastName,
+		MFAEnabled:      u.TOTPEnabledAt != nil,
+		ApplicationID:   u.ApplicationID,
+		Application:     u.Application,
 	}
 }
 
@@ -101,6 +433,7 @@ func (a *Admin) ToResponseAdmin() *ResponseAdmin {
 		Email:        a.Email,
 		FirstName:    a.FirstName,
 		LastName:     a.LastName,
+		MFAEnabled:   a.TOTPEnabledAt != nil,
 		Applications: a.Applications,
 	}
 }
@@ -0,0 +1,190 @@
+package revocation
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/models"
+)
+
+// How long the cached revocations are trusted before they are reloaded from
+// the database. Revocations made through another instance take at most this
+// long to be picked up.
+const refreshInterval = 15 * time.Second
+
+// Store keeps the revocation list in memory so checking a token does not hit
+// the database on every request. Revocations are written to Postgres first
+// and then applied to the cache.
+type Store struct {
+	db database.Service
+
+	mu       sync.RWMutex
+	tokens   map[string]time.Time
+	subjects map[string]time.Time
+	loaded   bool
+
+	// When the last reload was started, whether or not it succeeded
+	refreshedAt time.Time
+}
+
+func NewStore(db database.Service) *Store {
+	return &Store{
+		db:       db,
+		tokens:   make(map[string]time.Time),
+		subjects: make(map[string]time.Time),
+	}
+}
+
+// IsRevoked reports whether the token was revoked by its jti, by a
+// revocation of its subject or by the revocation of the credential it was
+// issued from.
+func (s *Store) IsRevoked(ctx context.Context, token *auth.TokenInfo) (bool, error) {
+	if err := s.refreshIfStale(ctx); err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.tokens[token.ID]; ok && token.ID != "" {
+		return true, nil
+	}
+	// iat has second precision, so only tokens from a second before the
+	// cut-off's are known to be older. Tokens issued just before it within
+	// the same second stay valid, rather than refusing those issued right
+	// after it, such as a login following a password change.
+	if revokedBefore, ok := s.subjects[token.Subject]; ok && token.IssuedAt.Before(revokedBefore.Truncate(time.Second)) {
+		return true, nil
+	}
+	// A revoked credential issues no more tokens, so every token from it up
+	// to and including the second it was revoked in is refused
+	if revokedAt, ok := s.subjects[token.CredentialID]; ok && token.CredentialID != "" && !token.IssuedAt.After(revokedAt) {
+		return true, nil
+	}
+	return false, nil
+}
+
+// RevokeToken revokes a single token until it expires.
+func (s *Store) RevokeToken(ctx context.Context, token *auth.TokenInfo, revokedBy string) error {
+	err := s.db.RevokeToken(ctx, &models.RevokedToken{
+		JTI:       token.ID,
+		Subject:   token.Subject,
+		ExpiresAt: token.ExpiresAt,
+		RevokedBy: revokedBy,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.tokens[token.ID] = token.ExpiresAt
+	s.mu.Unlock()
+	return nil
+}
+
+// UseToken revokes a single-use token as it is used. It fails with
+// database.ErrTokenUsed when the token was used or revoked before, including
+// by a concurrent request.
+func (s *Store) UseToken(ctx context.Context, token *auth.TokenInfo, usedBy string) error {
+	err := s.db.UseToken(ctx, &models.RevokedToken{
+		JTI:       token.ID,
+		Subject:   token.Subject,
+		ExpiresAt: token.ExpiresAt,
+		RevokedBy: usedBy,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.tokens[token.ID] = token.ExpiresAt
+	s.mu.Unlock()
+	return nil
+}
+
+// RevokeSubject revokes every token issued to the subject before the given
+// time.
+func (s *Store) RevokeSubject(ctx context.Context, subject string, before time.Time, revokedBy string) error {
+	err := s.db.RevokeSubjectTokens(ctx, &models.SubjectRevocation{
+		Subject:       subject,
+		RevokedBefore: before,
+		RevokedBy:     revokedBy,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.SubjectRevoked(subject, before)
+	return nil
+}
+
+// SubjectRevoked applies a revocation of a subject's tokens that was already
+// stored, as part of a larger transaction, to the cache.
+func (s *Store) SubjectRevoked(subject string, before time.Time) {
+	s.mu.Lock()
+	if current, ok := s.subjects[subject]; !ok || before.After(current) {
+		s.subjects[subject] = before
+	}
+	s.mu.Unlock()
+}
+
+// refreshIfStale reloads the revocations from the database once the cache is
+// older than refreshInterval. Until the first load succeeds every call tries
+// again, afterwards a failed reload keeps the cache until the next interval.
+func (s *Store) refreshIfStale(ctx context.Context) error {
+	s.mu.Lock()
+	loaded := s.loaded
+	if loaded && time.Since(s.refreshedAt) < refreshInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	// Claimed before loading, so concurrent requests keep using the cache
+	// instead of all querying a slow or failing database
+	s.refreshedAt = time.Now()
+	s.mu.Unlock()
+
+	revokedTokens, subjectRevocations, err := s.db.ListActiveRevocations(ctx)
+	if err != nil {
+		// Keep serving from the last known list rather than failing every
+		// request while the database is unavailable, unless it was never loaded
+		if loaded {
+			log.Printf("failed to refresh revocation list: %v", err)
+			return nil
+		}
+		return err
+	}
+
+	tokens := make(map[string]time.Time, len(revokedTokens))
+	for _, revokedToken := range revokedTokens {
+		tokens[revokedToken.JTI] = revokedToken.ExpiresAt
+	}
+	subjects := make(map[string]time.Time, len(subjectRevocations))
+	for _, revocation := range subjectRevocations {
+		subjects[revocation.Subject] = revocation.RevokedBefore
+	}
+
+	// Revocations made by this instance while the list was read may be
+	// missing from it, so the cached ones are merged in rather than dropped.
+	// Expired tokens are left out, the database no longer lists them.
+	now := time.Now()
+	s.mu.Lock()
+	for jti, expiresAt := range s.tokens {
+		if _, ok := tokens[jti]; !ok && expiresAt.After(now) {
+			tokens[jti] = expiresAt
+		}
+	}
+	for subject, before := range s.subjects {
+		if current, ok := subjects[subject]; !ok || before.After(current) {
+			subjects[subject] = before
+		}
+	}
+	s.tokens = tokens
+	s.subjects = subjects
+	s.loaded = true
+	s.mu.Unlock()
+	return nil
+}
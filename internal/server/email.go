@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/mailer"
+	"github.com/wbrijesh/identity/internal/models"
+)
+
+// issueOneTimeToken stores a new one-time token for a user or admin and
+// returns the link token and the code to email them. The caller sets who
+// the token is for, its hashes and expiry are set here.
+func (s *Server) issueOneTimeToken(ctx context.Context, oneTimeToken *models.OneTimeToken, ttl time.Duration) (string, string, error) {
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	code, err := auth.GenerateOneTimeCode()
+	if err != nil {
+		return "", "", err
+	}
+
+	oneTimeToken.TokenHash = tokenHash
+	oneTimeToken.CodeHash = auth.HashOpaqueToken(code)
+	oneTimeToken.ExpiresAt = time.Now().Add(ttl)
+	if err := s.db.CreateOneTimeToken(ctx, oneTimeToken); err != nil {
+		return "", "", err
+	}
+
+	return token, code, nil
+}
+
+// How long issuing and sending an email may take once the response is sent
+const backgroundEmailTimeout = 30 * time.Second
+
+// sendInBackground issues and sends an email after the response, for the
+// endpoints that answer the same whether or not an address has an account.
+// Neither their status nor their response time may depend on it, so errors,
+// rate limits included, are only logged.
+func (s *Server) sendInBackground(r *http.Request, description string, send func(ctx context.Context) error) {
+	ctx := context.WithoutCancel(r.Context())
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, backgroundEmailTimeout)
+		defer cancel()
+
+		err := send(ctx)
+		switch {
+		case errors.Is(err, database.ErrOneTimeTokenRateLimited):
+			log.Printf("not sending %s: %v", description, err)
+		case err != nil:
+			log.Printf("failed to send %s: %v", description, err)
+		}
+	}()
+}
+
+// oneTimeTokenLink adds a one-time token to a redirect URI of the
+// application as the given query parameter.
+func oneTimeTokenLink(redirectURI, param, token string) (string, error) {
+	link, err := url.Parse(redirectURI)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set(param, token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+// sendEmailVerification emails a user a code to confirm their address, and
+// a link with a verification_token parameter when a redirect URI is given.
+func (s *Server) sendEmailVerification(r *http.Request, app *models.Application, user *models.ResponseUser, redirectURI string) error {
+	token, code, err := s.issueOneTimeToken(r.Context(), &models.OneTimeToken{
+		Purpose:       models.OneTimeTokenEmailVerification,
+		SubjectID:     user.ID,
+		ApplicationID: app.ID,
+	}, auth.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("Your code to confirm your email address for %s is %s.\n", app.Name, code)
+	if redirectURI != "" {
+		link, err := oneTimeTokenLink(redirectURI, "verification_token", token)
+		if err != nil {
+			return err
+		}
+		text += fmt.Sprintf("\nOr confirm it with this link:\n%s\n", link)
+	}
+	text += fmt.Sprintf("\nThe code expires in %d hours. If you did not sign up, you can ignore this email.\n", int(auth.EmailVerificationTTL.Hours()))
+
+	return s.mailer.Send(r.Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address for " + app.Name,
+		Body:    text,
+	})
+}
+
+// consumeEmailedToken consumes a one-time token sent by email, given either
+// the token from its link or the recipient's email and the code. subjectID
+// looks up who an email belongs to. It writes the error response and returns
+// false when the token is not accepted.
+func (s *Server) consumeEmailedToken(w http.ResponseWriter, r *http.Request, purpose, applicationID, token, email, code string, subjectID func(email string) (string, error)) (*models.OneTimeToken, bool) {
+	var (
+		oneTimeToken *models.OneTimeToken
+		err          error
+	)
+	switch {
+	case token != "":
+		oneTimeToken, err = s.db.ConsumeOneTimeToken(r.Context(), purpose, applicationID, auth.HashOpaqueToken(token))
+	case email != "" && code != "":
+		id, lookupErr := subjectID(email)
+		if lookupErr != nil {
+			http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
+			return nil, false
+		}
+		oneTimeToken, err = s.db.ConsumeOneTimeCode(r.Context(), purpose, applicationID, id, code)
+	default:
+		http.Error(w, "token, or email and code, are required", http.StatusBadRequest)
+		return nil, false
+	}
+	if err != nil {
+		writeOneTimeTokenError(w, err)
+		return nil, false
+	}
+	return oneTimeToken, true
+}
+
+// checkEmailedToken checks a one-time token sent by email like
+// consumeEmailedToken, wrong codes counting as attempts, but leaves it
+// unused so the rest of the request can be checked before it is used.
+func (s *Server) checkEmailedToken(w http.ResponseWriter, r *http.Request, purpose, applicationID, token, email, code string, subjectID func(email string) (string, error)) (*models.OneTimeToken, bool) {
+	var (
+		oneTimeToken *models.OneTimeToken
+		err          error
+	)
+	switch {
+	case token != "":
+		oneTimeToken, err = s.db.CheckOneTimeToken(r.Context(), purpose, applicationID, auth.HashOpaqueToken(token))
+	case email != "" && code != "":
+		id, lookupErr := subjectID(email)
+		if lookupErr != nil {
+			http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
+			return nil, false
+		}
+		oneTimeToken, err = s.db.CheckOneTimeCode(r.Context(), purpose, applicationID, id, code)
+	default:
+		http.Error(w, "token, or email and code, are required", http.StatusBadRequest)
+		return nil, false
+	}
+	if err != nil {
+		writeOneTimeTokenError(w, err)
+		return nil, false
+	}
+	return oneTimeToken, true
+}
+
+// consumeOwnToken consumes a one-time token sent to a signed in user or
+// admin, given either the token from its link or the code.
+func (s *Server) consumeOwnToken(w http.ResponseWriter, r *http.Request, purpose, applicationID, subjectID, token, code string) (*models.OneTimeToken, bool) {
+	var (
+		oneTimeToken *models.OneTimeToken
+		err          error
+	)
+	switch {
+	case token != "":
+		oneTimeToken, err = s.db.ConsumeOneTimeToken(r.Context(), purpose, applicationID, auth.HashOpaqueToken(token))
+		if err == nil && oneTimeToken.SubjectID != subjectID {
+			err = database.ErrOneTimeTokenNotFound
+		}
+	case code != "":
+		oneTimeToken, err = s.db.ConsumeOneTimeCode(r.Context(), purpose, applicationID, subjectID, code)
+	default:
+		http.Error(w, "token or code is required", http.StatusBadRequest)
+		return nil, false
+	}
+	if err != nil {
+		writeOneTimeTokenError(w, err)
+		return nil, false
+	}
+	return oneTimeToken, true
+}
+
+// sendEmailChange emails the new address of an email change a code, and a
+// link with an email_change_token parameter when there is a URL to point it
+// to.
+func (s *Server) sendEmailChange(r *http.Request, to, accountName, confirmURL, token, code string) error {
+	text := fmt.Sprintf("Your code to confirm your new email address for %s is %s.\n", accountName, code)
+	if confirmURL != "" {
+		link, err := oneTimeTokenLink(confirmURL, "email_change_token", token)
+		if err != nil {
+			return err
+		}
+		text += fmt.Sprintf("\nOr confirm it with this link:\n%s\n", link)
+	}
+	text += fmt.Sprintf("\nThe code expires in %d minutes. If you did not ask to change your email address, you can ignore this email.\n", int(auth.EmailChangeTTL.Minutes()))
+
+	return s.mailer.Send(r.Context(), mailer.Message{
+		To:      to,
+		Subject: "Confirm your new email address for " + accountName,
+		Body:    text,
+	})
+}
+
+// userByEmail returns a lookup of user IDs by email in an application for
+// consumeEmailedToken.
+func (s *Server) userByEmail(r *http.Request, applicationID string) func(string) (string, error) {
+	return func(email string) (string, error) {
+		user, err := s.db.GetUserByEmail(r.Context(), applicationID, email)
+		if err != nil {
+			return "", err
+		}
+		return user.ID, nil
+	}
+}
+
+// writeOneTimeTokenError maps the errors of consuming a one-time token to
+// a response without telling which check failed.
+func writeOneTimeTokenError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrOneTimeTokenNotFound) ||
+		errors.Is(err, database.ErrOneTimeTokenUsed) ||
+		errors.Is(err, database.ErrOneTimeTokenExpired) ||
+		errors.Is(err, database.ErrOneTimeCodeInvalid) {
+		http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
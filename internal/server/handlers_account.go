@@ -0,0 +1,297 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/middleware"
+	"github.com/wbrijesh/identity/internal/models"
+	"github.com/wbrijesh/identity/utils"
+)
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type changeEmailRequest struct {
+	NewEmail        string `json:"new_email"`
+	CurrentPassword string `json:"current_password"`
+	RedirectURI     string `json:"redirect_uri"`
+}
+
+type confirmEmailRequest struct {
+	Token string `json:"token"`
+	Code  string `json:"code"`
+}
+
+// writeCurrentPasswordError answers a failed check of the current password.
+func writeCurrentPasswordError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrInvalidPassword) {
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// writeEmailChangeError answers a failed email change request or
+// confirmation.
+func writeEmailChangeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrEmailTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, database.ErrOneTimeTokenRateLimited):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ChangeMyPasswordHandler changes the password of the signed in user and
+// logs them out everywhere, including the session making the change.
+func (s *Server) ChangeMyPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalUser)
+	if !ok {
+		return
+	}
+
+	var body changePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := utils.CheckNeceassaryFieldsExist(body, []string{"CurrentPassword", "NewPassword"}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.CheckUserPassword(r.Context(), principal.UserID, body.CurrentPassword); err != nil {
+		writeCurrentPasswordError(w, err)
+		return
+	}
+
+	app, err := s.db.GetApplicationByID(r.Context(), principal.ApplicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	}
+	if !s.checkPasswordPolicy(w, r, app.PasswordPolicy, principal.UserID, body.NewPassword) {
+		return
+	}
+
+	now := time.Now()
+	if err := s.db.ChangeUserPassword(r.Context(), principal.UserID, body.NewPassword, now); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.revocations.SubjectRevoked(principal.UserID, now)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangeMyEmailHandler sends a confirmation to the new email of the signed
+// in user. The email only changes once the user confirms it.
+func (s *Server) ChangeMyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalUser)
+	if !ok {
+		return
+	}
+
+	var body changeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := utils.CheckNeceassaryFieldsExist(body, []string{"NewEmail", "CurrentPassword"}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app, err := s.db.GetApplicationByID(r.Context(), principal.ApplicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	}
+	if body.RedirectURI != "" && !slices.Contains(app.RedirectURIs, body.RedirectURI) {
+		http.Error(w, "redirect_uri is not registered for this application", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.CheckUserPassword(r.Context(), principal.UserID, body.CurrentPassword); err != nil {
+		writeCurrentPasswordError(w, err)
+		return
+	}
+	if _, err := s.db.GetUserByEmail(r.Context(), app.ID, body.NewEmail); err == nil {
+		writeEmailChangeError(w, database.ErrEmailTaken)
+		return
+	}
+
+	token, code, err := s.issueOneTimeToken(r.Context(), &models.OneTimeToken{
+		Purpose:       models.OneTimeTokenEmailChange,
+		PendingEmail:  body.NewEmail,
+		SubjectID:     principal.UserID,
+		ApplicationID: app.ID,
+	}, auth.EmailChangeTTL)
+	if err != nil {
+		writeEmailChangeError(w, err)
+		return
+	}
+
+	if err := s.sendEmailChange(r, body.NewEmail, app.Name, body.RedirectURI, token, code); err != nil {
+		http.Error(w, "Failed to send email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"expires_in": int64(auth.EmailChangeTTL.Seconds()),
+	})
+}
+
+// ConfirmMyEmailHandler changes the email of the signed in user to the one
+// the token or code was sent to.
+func (s *Server) ConfirmMyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalUser)
+	if !ok {
+		return
+	}
+
+	var body confirmEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, ok := s.consumeOwnToken(w, r, models.OneTimeTokenEmailChange, principal.ApplicationID, principal.UserID, body.Token, body.Code)
+	if !ok {
+		return
+	}
+
+	user, err := s.db.ChangeUserEmail(r.Context(), principal.UserID, token.PendingEmail)
+	if err != nil {
+		writeEmailChangeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(user)
+}
+
+// ChangeAdminPasswordHandler changes the password of the signed in admin and
+// revokes every admin token issued so far, including the one making the
+// change.
+func (s *Server) ChangeAdminPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalAdmin)
+	if !ok {
+		return
+	}
+
+	var body changePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := utils.CheckNeceassaryFieldsExist(body, []string{"CurrentPassword", "NewPassword"}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.CheckAdminPassword(r.Context(), principal.AdminID, body.CurrentPassword); err != nil {
+		writeCurrentPasswordError(w, err)
+		return
+	}
+
+	if !s.checkPasswordPolicy(w, r, auth.AdminPasswordPolicy, principal.AdminID, body.NewPassword) {
+		return
+	}
+
+	now := time.Now()
+	if err := s.db.ChangeAdminPassword(r.Context(), principal.AdminID, body.NewPassword, now); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.revocations.SubjectRevoked(principal.AdminID, now)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChangeAdminEmailHandler sends a confirmation code to the new email of the
+// signed in admin. The email only changes once the admin confirms it.
+func (s *Server) ChangeAdminEmailHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalAdmin)
+	if !ok {
+		return
+	}
+
+	var body changeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := utils.CheckNeceassaryFieldsExist(body, []string{"NewEmail", "CurrentPassword"}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.CheckAdminPassword(r.Context(), principal.AdminID, body.CurrentPassword); err != nil {
+		writeCurrentPasswordError(w, err)
+		return
+	}
+	if _, err := s.db.GetAdminByEmail(r.Context(), body.NewEmail); err == nil {
+		writeEmailChangeError(w, database.ErrEmailTaken)
+		return
+	}
+
+	token, code, err := s.issueOneTimeToken(r.Context(), &models.OneTimeToken{
+		Purpose:      models.OneTimeTokenAdminEmailChange,
+		PendingEmail: body.NewEmail,
+		SubjectID:    principal.AdminID,
+	}, auth.EmailChangeTTL)
+	if err != nil {
+		writeEmailChangeError(w, err)
+		return
+	}
+
+	// Admins have no application to link back to, so only the code is sent
+	if err := s.sendEmailChange(r, body.NewEmail, "your admin account", "", token, code); err != nil {
+		http.Error(w, "Failed to send email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"expires_in": int64(auth.EmailChangeTTL.Seconds()),
+	})
+}
+
+// ConfirmAdminEmailHandler changes the email of the signed in admin to the
+// one the code was sent to.
+func (s *Server) ConfirmAdminEmailHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalAdmin)
+	if !ok {
+		return
+	}
+
+	var body confirmEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, ok := s.consumeOwnToken(w, r, models.OneTimeTokenAdminEmailChange, "", principal.AdminID, body.Token, body.Code)
+	if !ok {
+		return
+	}
+
+	admin, err := s.db.ChangeAdminEmail(r.Context(), principal.AdminID, token.PendingEmail)
+	if err != nil {
+		writeEmailChangeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(admin)
+}
@@ -21,13 +21,17 @@ func (s *Server) CreateAdminHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.checkPasswordPolicy(w, r, auth.AdminPasswordPolicy, "", admin.PasswordHash) {
+		return
+	}
+
 	createdAdmin, err := s.db.CreateAdmin(r.Context(), &admin)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	token, err := auth.GenerateAdminJWT(createdAdmin)
+	token, err := auth.GenerateAdminJWT(createdAdmin, []string{auth.AuthMethodPassword})
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
@@ -62,7 +66,22 @@ func (s *Server) LoginAdminHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := auth.GenerateAdminJWT(admin)
+	if admin.MFAEnabled {
+		mfaToken, err := auth.GenerateAdminMFAToken(admin)
+		if err != nil {
+			http.Error(w, "Failed to generate MFA token", http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int(auth.MFAChallengeTTL.Seconds()),
+		})
+		return
+	}
+
+	token, err := auth.GenerateAdminJWT(admin, []string{auth.AuthMethodPassword})
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
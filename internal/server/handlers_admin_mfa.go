@@ -0,0 +1,267 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/middleware"
+)
+
+// LoginAdminMFAHandler completes an admin login with a TOTP code or one of
+// the admin's recovery codes. The admin token records which one was used.
+// Each challenge takes a single code, and TOTP codes are checked like a
+// user's: a time step is accepted once and invalid codes lock TOTP for a
+// while.
+func (s *Server) LoginAdminMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if body.Code == "" && body.RecoveryCode == "" {
+		http.Error(w, "code or recovery_code is required", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := auth.ValidateAdminMFAToken(body.MFAToken)
+	if err != nil {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+	challenge, err := claims.Info()
+	if err != nil {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+	revoked, err := s.revocations.IsRevoked(r.Context(), challenge)
+	if err != nil {
+		http.Error(w, "Failed to check token revocation", http.StatusInternalServerError)
+		return
+	}
+	if revoked {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	// A challenge completes a single login, and is spent before the code is
+	// checked so concurrent requests cannot try several codes against it.
+	// An invalid code counts towards the admin's TOTP lockout and the admin
+	// signs in with their password again.
+	if err := s.revocations.UseToken(r.Context(), challenge, claims.Subject); err != nil {
+		if errors.Is(err, database.ErrTokenUsed) {
+			http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to complete MFA challenge", http.StatusInternalServerError)
+		return
+	}
+
+	authMethods := []string{auth.AuthMethodPassword, auth.AuthMethodOTP, auth.AuthMethodMFA}
+	if body.Code != "" {
+		err = s.db.VerifyAdminTOTP(r.Context(), claims.Subject, body.Code, false)
+	} else {
+		authMethods = []string{auth.AuthMethodPassword, auth.AuthMethodRecoveryCode, auth.AuthMethodMFA}
+		err = s.db.UseAdminRecoveryCode(r.Context(), claims.Subject, body.RecoveryCode)
+	}
+	if err != nil {
+		writeTOTPError(w, err)
+		return
+	}
+
+	admin, err := s.db.GetAdminByID(r.Context(), claims.Subject)
+	if err != nil {
+		http.Error(w, "Admin not found", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := auth.GenerateAdminJWT(admin, authMethods)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"admin": admin,
+		"token": token,
+	})
+}
+
+// EnrollAdminTOTPHandler starts TOTP enrollment for the signed in admin. TOTP
+// is enabled once a code is confirmed.
+func (s *Server) EnrollAdminTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalAdmin)
+	if !ok {
+		return
+	}
+
+	admin, err := s.db.GetAdminByID(r.Context(), principal.AdminID)
+	if err != nil {
+		http.Error(w, "Admin not found", http.StatusNotFound)
+		return
+	}
+
+	secret, err := s.db.EnrollAdminTOTP(r.Context(), admin.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrTOTPAlreadyEnabled) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPProvisioningURI(adminTOTPIssuer(), admin.Email, secret),
+	})
+}
+
+// adminTOTPIssuer names the service in the admin's authenticator app.
+func adminTOTPIssuer() string {
+	if issuer, err := url.Parse(auth.Issuer()); err == nil && issuer.Host != "" {
+		return issuer.Host
+	}
+	return auth.Issuer()
+}
+
+// ConfirmAdminTOTPHandler enables TOTP for the signed in admin with a code
+// from the secret returned on enrollment. It returns the admin's recovery
+// codes, which are not shown again, and a token issued with MFA.
+func (s *Server) ConfirmAdminTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalAdmin)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	admin, err := s.db.GetAdminByID(r.Context(), principal.AdminID)
+	if err != nil {
+		http.Error(w, "Admin not found", http.StatusNotFound)
+		return
+	}
+	if admin.MFAEnabled {
+		http.Error(w, database.ErrTOTPAlreadyEnabled.Error(), http.StatusConflict)
+		return
+	}
+
+	if err := s.db.VerifyAdminTOTP(r.Context(), admin.ID, body.Code, true); err != nil {
+		writeTOTPError(w, err)
+		return
+	}
+	admin.MFAEnabled = true
+
+	codes, err := s.replaceRecoveryCodes(r, admin.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	token, err := auth.GenerateAdminJWT(admin, []string{auth.AuthMethodPassword, auth.AuthMethodOTP, auth.AuthMethodMFA})
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recovery_codes": codes,
+		"token":          token,
+	})
+}
+
+// DisableAdminTOTPHandler turns TOTP off for the signed in admin, who has to
+// present a current TOTP code or a recovery code. The recovery codes are
+// dropped as well.
+func (s *Server) DisableAdminTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalAdmin)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if body.RecoveryCode != "" {
+		err = s.db.UseAdminRecoveryCode(r.Context(), principal.AdminID, body.RecoveryCode)
+	} else {
+		err = s.db.VerifyAdminTOTP(r.Context(), principal.AdminID, body.Code, false)
+	}
+	if err != nil {
+		writeTOTPError(w, err)
+		return
+	}
+
+	if err := s.db.DisableAdminTOTP(r.Context(), principal.AdminID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodesHandler replaces the recovery codes of the signed in
+// admin, who has to present a current TOTP code.
+func (s *Server) RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalAdmin)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.VerifyAdminTOTP(r.Context(), principal.AdminID, body.Code, false); err != nil {
+		writeTOTPError(w, err)
+		return
+	}
+
+	codes, err := s.replaceRecoveryCodes(r, principal.AdminID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+func (s *Server) replaceRecoveryCodes(r *http.Request, adminID string) ([]string, error) {
+	codes, hashes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.ReplaceAdminRecoveryCodes(r.Context(), adminID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
@@ -2,21 +2,24 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/middleware"
 	"github.com/wbrijesh/identity/internal/models"
 	"github.com/wbrijesh/identity/utils"
 )
 
 func (s *Server) CreateApplicationHandler(w http.ResponseWriter, r *http.Request) {
-	adminID, ok := r.Context().Value("adminID").(string)
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalAdmin)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusInternalServerError)
 		return
 	}
+	adminID := principal.AdminID
 
 	var app models.Application
 	if err := json.NewDecoder(r.Body).Decode(&app); err != nil {
@@ -31,37 +34,52 @@ func (s *Server) CreateApplicationHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	createdApp, err := s.db.CreateApplication(r.Context(), &app)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := validateRedirectURIs(app.RedirectURIs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	json.NewEncoder(w).Encode(createdApp)
-}
+	if err := auth.ValidateTokenPolicy(app.TokenPolicy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-func (s *Server) ListApplicationsHandler(w http.ResponseWriter, r *http.Request) {
-	adminID, ok := r.Context().Value("adminID").(string)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusInternalServerError)
+	if err := auth.ValidatePasswordPolicy(app.PasswordPolicy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
-	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
 
-	apps, total, err := s.db.ListApplications(r.Context(), offset, limit, adminID)
+	if app.MFAPolicy == "" {
+		app.MFAPolicy = models.MFAPolicyOptional
+	}
+	if err := validateMFAPolicy(app.MFAPolicy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if app.EmailVerification == "" {
+		app.EmailVerification = models.EmailVerificationOptional
+	}
+	if err := validateEmailVerification(app.EmailVerification); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateWebAuthnConfig(app.WebAuthnRPID, app.WebAuthnOrigins); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	createdApp, err := s.db.CreateApplication(r.Context(), &app)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"applications": apps,
-		"total":        total,
-	})
+	json.NewEncoder(w).Encode(createdApp)
 }
 
-func (s *Server) GenerateRefreshTokenForApplicationHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) UpdateApplicationHandler(w http.ResponseWriter, r *http.Request) {
 	applicationID := chi.URLParam(r, "applicationID")
 	if applicationID == "" {
 		http.Error(w, "Application ID is required", http.StatusBadRequest)
@@ -69,11 +87,11 @@ func (s *Server) GenerateRefreshTokenForApplicationHandler(w http.ResponseWriter
 	}
 
 	// Check if request is coming from the application owner
-	adminID, ok := r.Context().Value("adminID").(string)
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalAdmin)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusInternalServerError)
 		return
 	}
+	adminID := principal.AdminID
 	application, err := s.db.GetApplicationByID(r.Context(), applicationID)
 	if err != nil {
 		http.Error(w, "Application not found", http.StatusNotFound)
@@ -84,90 +102,137 @@ func (s *Server) GenerateRefreshTokenForApplicationHandler(w http.ResponseWriter
 		return
 	}
 
-	// Generate refresh token
-	refreshToken, err := s.db.GenerateRefreshToken(r.Context(), applicationID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var body struct {
+		Name         string              `json:"Name"`
+		Description  string              `json:"Description"`
+		RedirectURIs []string            `json:"RedirectURIs"`
+		TokenPolicy  *models.TokenPolicy `json:"TokenPolicy"`
+		MFAPolicy    string              `json:"MFAPolicy"`
+
+		PasswordPolicy *models.PasswordPolicy `json:"PasswordPolicy"`
+
+		EmailVerification string `json:"EmailVerification"`
+
+		WebAuthnRPID    string   `json:"WebAuthnRPID"`
+		WebAuthnOrigins []string `json:"WebAuthnOrigins"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// DELETE TemporaryAccessToken before pushing first stable version
-	accessToken, err := auth.GenerateAccessTokenFromRefreshToken(refreshToken)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := validateRedirectURIs(body.RedirectURIs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Prepare response
-	response := struct {
-		RefreshToken         string `json:"refresh_token"`
-		TemporaryAccessToken string `json:"temporary_access_token"`
-	}{
-		RefreshToken:         refreshToken,
-		TemporaryAccessToken: accessToken,
+	if err := auth.ValidateTokenPolicy(body.TokenPolicy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
-}
-
-func (s *Server) UpdateRefreshTokenForApplicationHandler(w http.ResponseWriter, r *http.Request) {
-	applicationID := chi.URLParam(r, "applicationID")
-	if applicationID == "" {
-		http.Error(w, "Application ID is required", http.StatusBadRequest)
+	if err := auth.ValidatePasswordPolicy(body.PasswordPolicy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Check if request is coming from the application owner
-	adminID, ok := r.Context().Value("adminID").(string)
-	if !ok {
-		http.Error(w, "Unauthorized", http.StatusInternalServerError)
-		return
+	if body.MFAPolicy != "" {
+		if err := validateMFAPolicy(body.MFAPolicy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
-	application, err := s.db.GetApplicationByID(r.Context(), applicationID)
-	if err != nil {
-		http.Error(w, "Application not found", http.StatusNotFound)
-		return
+
+	if body.EmailVerification != "" {
+		if err := validateEmailVerification(body.EmailVerification); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
-	if application.AdminID != adminID {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+
+	// Passkey settings are checked together, with the stored value standing
+	// in for the one that is not changed
+	if body.WebAuthnRPID != "" || body.WebAuthnOrigins != nil {
+		rpID, origins := application.WebAuthnRPID, application.WebAuthnOrigins
+		if body.WebAuthnRPID != "" {
+			rpID = body.WebAuthnRPID
+		}
+		if body.WebAuthnOrigins != nil {
+			origins = body.WebAuthnOrigins
+		}
+		if err := validateWebAuthnConfig(rpID, origins); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
-	// Delete existing refresh token
-	err = s.db.DeleteRefreshToken(r.Context(), applicationID)
+	// Only copy the editable fields so the owner or secrets cannot be changed
+	_, err = s.db.UpdateApplication(r.Context(), &models.Application{
+		ID:           applicationID,
+		Name:         body.Name,
+		Description:  body.Description,
+		RedirectURIs: body.RedirectURIs,
+		TokenPolicy:  body.TokenPolicy,
+		MFAPolicy:    body.MFAPolicy,
+
+		PasswordPolicy: body.PasswordPolicy,
+
+		EmailVerification: body.EmailVerification,
+
+		WebAuthnRPID:    body.WebAuthnRPID,
+		WebAuthnOrigins: body.WebAuthnOrigins,
+	})
 	if err != nil {
-		http.Error(w, "Failed to delete existing refresh token: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	s.policies.invalidate(applicationID)
 
-	// Generate new refresh token
-	newRefreshToken, err := s.db.GenerateRefreshToken(r.Context(), applicationID)
+	updatedApp, err := s.db.GetApplicationByID(r.Context(), applicationID)
 	if err != nil {
-		http.Error(w, "Failed to generate new refresh token: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// DELETE TemporaryAccessToken before pushing first stable version
-	accessToken, err := auth.GenerateAccessTokenFromRefreshToken(newRefreshToken)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(updatedApp)
+}
+
+// validateRedirectURIs checks that redirect URIs are absolute and carry no
+// fragment, as required by RFC 6749 section 3.1.2. Custom schemes are allowed
+// for mobile apps.
+func validateRedirectURIs(redirectURIs []string) error {
+	for _, redirectURI := range redirectURIs {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || parsed.Scheme == "" || !parsed.IsAbs() {
+			return fmt.Errorf("redirect URI %s must be an absolute URI", redirectURI)
+		}
+		if parsed.Fragment != "" {
+			return fmt.Errorf("redirect URI %s must not contain a fragment", redirectURI)
+		}
+		if parsed.Scheme == "http" && parsed.Hostname() != "localhost" && parsed.Hostname() != "127.0.0.1" {
+			return fmt.Errorf("redirect URI %s must use https", redirectURI)
+		}
+	}
+	return nil
+}
+
+func (s *Server) ListApplicationsHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalAdmin)
+	if !ok {
 		return
 	}
+	adminID := principal.AdminID
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
 
-	// Prepare response
-	response := struct {
-		RefreshToken         string `json:"refresh_token"`
-		TemporaryAccessToken string `json:"temporary_access_token"`
-	}{
-		RefreshToken:         newRefreshToken,
-		TemporaryAccessToken: accessToken,
+	apps, total, err := s.db.ListApplications(r.Context(), offset, limit, adminID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"applications": apps,
+		"total":        total,
+	})
 }
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/middleware"
+	"github.com/wbrijesh/identity/internal/models"
+)
+
+// credentialRequest is the body of the create and update credential
+// endpoints. Fields left out keep their current value on update.
+type credentialRequest struct {
+	Label     string     `json:"label"`
+	Scopes    *[]string  `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (req *credentialRequest) validate() error {
+	if req.Scopes != nil {
+		if err := auth.ValidateScopes(*req.Scopes); err != nil {
+			return err
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	return nil
+}
+
+// authorizeApplication checks that the application in the URL belongs to the
+// calling admin.
+func (s *Server) authorizeApplication(w http.ResponseWriter, r *http.Request) (*models.Application, bool) {
+	applicationID := chi.URLParam(r, "applicationID")
+	if applicationID == "" {
+		http.Error(w, "Application ID is required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	// Check if request is coming from the application owner
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalAdmin)
+	if !ok {
+		return nil, false
+	}
+	application, err := s.db.GetApplicationByID(r.Context(), applicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return nil, false
+	}
+	if application.AdminID != principal.AdminID {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return application, true
+}
+
+func writeCredentialError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrCredentialNotFound):
+		http.Error(w, "Credential not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrCredentialRevoked), errors.Is(err, database.ErrCredentialExpired):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// CreateCredentialHandler issues a new credential for an application. The
+// refresh token is only returned here and by rotation.
+func (s *Server) CreateCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	application, ok := s.authorizeApplication(w, r)
+	if !ok {
+		return
+	}
+
+	var req credentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Label == "" {
+		http.Error(w, "label is required", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Without a choice from the admin the credential gets every scope
+	scopes := auth.ApplicationScopes
+	if req.Scopes != nil {
+		scopes = *req.Scopes
+	}
+
+	credential := &models.ApplicationCredential{
+		Label:         req.Label,
+		Scopes:        scopes,
+		ExpiresAt:     req.ExpiresAt,
+		ApplicationID: application.ID,
+	}
+	refreshToken, err := s.db.CreateCredential(r.Context(), credential)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"credential":    credential,
+		"refresh_token": refreshToken,
+	})
+}
+
+func (s *Server) ListCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	application, ok := s.authorizeApplication(w, r)
+	if !ok {
+		return
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	credentials, total, err := s.db.ListCredentials(r.Context(), application.ID, offset, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"credentials": credentials,
+		"total":       total,
+	})
+}
+
+func (s *Server) GetCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	application, ok := s.authorizeApplication(w, r)
+	if !ok {
+		return
+	}
+
+	credential, err := s.db.GetCredential(r.Context(), application.ID, chi.URLParam(r, "credentialID"))
+	if err != nil {
+		writeCredentialError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(credential)
+}
+
+// UpdateCredentialHandler changes the label, scopes or expiry of a
+// credential. New scopes apply to access tokens issued from then on.
+func (s *Server) UpdateCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	application, ok := s.authorizeApplication(w, r)
+	if !ok {
+		return
+	}
+
+	credential, err := s.db.GetCredential(r.Context(), application.ID, chi.URLParam(r, "credentialID"))
+	if err != nil {
+		writeCredentialError(w, err)
+		return
+	}
+
+	var req credentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Label != "" {
+		credential.Label = req.Label
+	}
+	if req.Scopes != nil {
+		credential.Scopes = *req.Scopes
+	}
+	if req.ExpiresAt != nil {
+		credential.ExpiresAt = req.ExpiresAt
+	}
+
+	updatedCredential, err := s.db.UpdateCredential(r.Context(), credential)
+	if err != nil {
+		writeCredentialError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(updatedCredential)
+}
+
+// RotateCredentialHandler replaces the refresh token of a credential, the
+// previous one stays valid for the grace period.
+func (s *Server) RotateCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	application, ok := s.authorizeApplication(w, r)
+	if !ok {
+		return
+	}
+
+	refreshToken, err := s.db.RotateCredential(r.Context(), application.ID, chi.URLParam(r, "credentialID"))
+	if err != nil {
+		writeCredentialError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"refresh_token": refreshToken,
+	})
+}
+
+// RevokeCredentialHandler revokes a credential. Its refresh token and the
+// access tokens already issued from it stop working at once. Other
+// credentials of the application keep working.
+func (s *Server) RevokeCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	application, ok := s.authorizeApplication(w, r)
+	if !ok {
+		return
+	}
+
+	credentialID := chi.URLParam(r, "credentialID")
+	revokedAt, err := s.db.RevokeCredential(r.Context(), application.ID, credentialID, application.AdminID)
+	if err != nil {
+		writeCredentialError(w, err)
+		return
+	}
+	s.revocations.SubjectRevoked(credentialID, revokedAt)
+
+	w.WriteHeader(http.StatusNoContent)
+}
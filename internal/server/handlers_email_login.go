@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/mailer"
+	"github.com/wbrijesh/identity/internal/middleware"
+	"github.com/wbrijesh/identity/internal/models"
+	"github.com/wbrijesh/identity/utils"
+)
+
+// RequestEmailLoginHandler emails a user a login code, and a login link when
+// the application passes one of its redirect URIs. The link points to the
+// redirect URI with a login_token parameter, which the application exchanges
+// through VerifyEmailLoginHandler.
+func (s *Server) RequestEmailLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ApplicationID string `json:"application_id"`
+		Email         string `json:"email"`
+		RedirectURI   string `json:"redirect_uri"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Check if request is coming from the application
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalApplication)
+	if !ok {
+		return
+	}
+	if !s.ownsApplication(r, principal, body.ApplicationID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := utils.CheckNeceassaryFieldsExist(body, []string{"ApplicationID", "Email"}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app, err := s.db.GetApplicationByID(r.Context(), body.ApplicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	}
+	if body.RedirectURI != "" && !slices.Contains(app.RedirectURIs, body.RedirectURI) {
+		http.Error(w, "redirect_uri is not registered for this application", http.StatusBadRequest)
+		return
+	}
+
+	// Unknown emails get the same answer, so the endpoint does not tell
+	// which emails have accounts. The email is sent after the response so
+	// the time it takes does not tell either.
+	if user, err := s.db.GetUserByEmail(r.Context(), app.ID, body.Email); err == nil {
+		s.sendInBackground(r, "login email to user "+user.ID, func(ctx context.Context) error {
+			return s.sendEmailLogin(ctx, app, user, body.RedirectURI)
+		})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"expires_in": int64(auth.EmailLoginTTL.Seconds()),
+	})
+}
+
+// sendEmailLogin emails a user a login code, and a link with a login_token
+// parameter when a redirect URI is given.
+func (s *Server) sendEmailLogin(ctx context.Context, app *models.Application, user *models.ResponseUser, redirectURI string) error {
+	token, code, err := s.issueOneTimeToken(ctx, &models.OneTimeToken{
+		Purpose:       models.OneTimeTokenLogin,
+		SubjectID:     user.ID,
+		ApplicationID: app.ID,
+	}, auth.EmailLoginTTL)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("Your code to sign in to %s is %s.\n", app.Name, code)
+	if redirectURI != "" {
+		link, err := oneTimeTokenLink(redirectURI, "login_token", token)
+		if err != nil {
+			return err
+		}
+		text += fmt.Sprintf("\nOr sign in with this link:\n%s\n", link)
+	}
+	text += fmt.Sprintf("\nThe code expires in %d minutes. If you did not try to sign in, you can ignore this email.\n", int(auth.EmailLoginTTL.Minutes()))
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Sign in to " + app.Name,
+		Body:    text,
+	})
+}
+
+// VerifyEmailLoginHandler exchanges a login token from a link, or an email
+// and code, for the same response as a password login. Users with a second
+// factor still have to pass the MFA challenge.
+func (s *Server) VerifyEmailLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ApplicationID string `json:"application_id"`
+		Token         string `json:"token"`
+		Email         string `json:"email"`
+		Code          string `json:"code"`
+		Nonce         string `json:"nonce"`
+
+		// Forwarded by application backends that log users in on their
+		// behalf, so sessions show the end user's device
+		UserAgent string `json:"user_agent"`
+		IPAddress string `json:"ip_address"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Check if request is coming from the application
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalApplication)
+	if !ok {
+		return
+	}
+	if !s.ownsApplication(r, principal, body.ApplicationID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	token, ok := s.consumeEmailedToken(w, r, models.OneTimeTokenLogin, body.ApplicationID, body.Token, body.Email, body.Code, s.userByEmail(r, body.ApplicationID))
+	if !ok {
+		return
+	}
+
+	// Receiving the email proves the user owns the address
+	user, err := s.db.MarkUserEmailVerified(r.Context(), token.SubjectID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	app, err := s.db.GetApplicationByID(r.Context(), body.ApplicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	}
+	if challenge, enroll := mfaRequirement(app, user); challenge {
+		s.writeMFAChallenge(w, r, user, enroll, body.Nonce, body.UserAgent, body.IPAddress)
+		return
+	}
+
+	s.writeUserLogin(w, r, user, body.UserAgent, body.IPAddress, body.Nonce)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/middleware"
+	"github.com/wbrijesh/identity/internal/models"
+	"github.com/wbrijesh/identity/utils"
+)
+
+var emailVerificationPolicies = []string{models.EmailVerificationOptional, models.EmailVerificationRequired}
+
+func validateEmailVerification(policy string) error {
+	if !slices.Contains(emailVerificationPolicies, policy) {
+		return fmt.Errorf("EmailVerification must be one of %v", emailVerificationPolicies)
+	}
+	return nil
+}
+
+// sendSignupVerification sends the verification email of a new user. The
+// user is already created, so a mail failure is logged rather than failing
+// the request, and the application can resend it.
+func (s *Server) sendSignupVerification(r *http.Request, app *models.Application, user *models.ResponseUser, redirectURI string) {
+	if redirectURI != "" && !slices.Contains(app.RedirectURIs, redirectURI) {
+		redirectURI = ""
+	}
+	if err := s.sendEmailVerification(r, app, user, redirectURI); err != nil {
+		log.Printf("failed to send verification email to user %s: %v", user.ID, err)
+	}
+}
+
+// ResendEmailVerificationHandler sends a user a new verification email,
+// invalidating the previous one.
+func (s *Server) ResendEmailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ApplicationID string `json:"application_id"`
+		Email         string `json:"email"`
+		RedirectURI   string `json:"redirect_uri"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Check if request is coming from the application
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalApplication)
+	if !ok {
+		return
+	}
+	if !s.ownsApplication(r, principal, body.ApplicationID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := utils.CheckNeceassaryFieldsExist(body, []string{"ApplicationID", "Email"}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app, err := s.db.GetApplicationByID(r.Context(), body.ApplicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	}
+	if body.RedirectURI != "" && !slices.Contains(app.RedirectURIs, body.RedirectURI) {
+		http.Error(w, "redirect_uri is not registered for this application", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.db.GetUserByEmail(r.Context(), app.ID, body.Email)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.EmailVerified {
+		http.Error(w, "Email address is already verified", http.StatusConflict)
+		return
+	}
+
+	if err := s.sendEmailVerification(r, app, user, body.RedirectURI); err != nil {
+		if errors.Is(err, database.ErrOneTimeTokenRateLimited) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		http.Error(w, "Failed to send email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"expires_in": int64(auth.EmailVerificationTTL.Seconds()),
+	})
+}
+
+// ConfirmEmailVerificationHandler marks a user's email as verified with the
+// token from a verification link, or the email and code.
+func (s *Server) ConfirmEmailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ApplicationID string `json:"application_id"`
+		Token         string `json:"token"`
+		Email         string `json:"email"`
+		Code          string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Check if request is coming from the application
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalApplication)
+	if !ok {
+		return
+	}
+	if !s.ownsApplication(r, principal, body.ApplicationID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	token, ok := s.consumeEmailedToken(w, r, models.OneTimeTokenEmailVerification, body.ApplicationID, body.Token, body.Email, body.Code, s.userByEmail(r, body.ApplicationID))
+	if !ok {
+		return
+	}
+
+	user, err := s.db.MarkUserEmailVerified(r.Context(), token.SubjectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(user)
+}
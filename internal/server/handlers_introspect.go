@@ -0,0 +1,216 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/models"
+)
+
+// IntrospectHandler implements RFC 7662 token introspection so resource
+// servers can check a token without holding the signing keys. The caller
+// authenticates with its application ID and refresh token, and only learns
+// about tokens issued for its own application or by the admin who owns it.
+// Anything else is reported as inactive.
+func (s *Server) IntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid form body")
+		return
+	}
+
+	application, ok := s.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	tokenString := r.PostForm.Get("token")
+	if tokenString == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	// token_type_hint is optional and every token type is tried anyway, so
+	// it is ignored
+	token, err := s.introspectToken(r, tokenString)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to introspect token")
+		return
+	}
+	if token == nil || !canIntrospect(application, token) {
+		writeTokenResponse(w, map[string]interface{}{"active": false})
+		return
+	}
+
+	response := map[string]interface{}{
+		"active":     true,
+		"sub":        token.Subject,
+		"token_type": token.Type,
+		"exp":        token.ExpiresAt.Unix(),
+		"iat":        token.IssuedAt.Unix(),
+		"jti":        token.ID,
+		"iss":        auth.Issuer(),
+	}
+	if token.Issuer != "" {
+		response["iss"] = token.Issuer
+	}
+	if token.ApplicationID != "" {
+		response["app_id"] = token.ApplicationID
+		response["client_id"] = token.ApplicationID
+	}
+	if token.Scope != "" {
+		response["scope"] = token.Scope
+	}
+
+	writeTokenResponse(w, response)
+}
+
+// authenticateClient checks the application ID and the credential refresh
+// token presented as client credentials.
+func (s *Server) authenticateClient(w http.ResponseWriter, r *http.Request) (*models.Application, bool) {
+	clientID, clientSecret := clientCredentials(r)
+	if clientID == "" || clientSecret == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="identity"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication is required")
+		return nil, false
+	}
+
+	claims, err := auth.ValidateRefreshToken(clientSecret)
+	if err != nil || claims.ApplicationID != clientID {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "invalid client credentials")
+		return nil, false
+	}
+	info, err := claims.Info()
+	if err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "invalid client credentials")
+		return nil, false
+	}
+	revoked, err := s.revocations.IsRevoked(r.Context(), info)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to check token revocation")
+		return nil, false
+	}
+	if revoked {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client credentials have been revoked")
+		return nil, false
+	}
+
+	if _, _, err := s.db.UseRefreshToken(r.Context(), clientSecret, false, nil); err != nil {
+		if errors.Is(err, database.ErrRefreshTokenInvalid) ||
+			errors.Is(err, database.ErrRefreshTokenReused) ||
+			errors.Is(err, database.ErrCredentialRevoked) ||
+			errors.Is(err, database.ErrCredentialExpired) {
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "invalid client credentials")
+			return nil, false
+		}
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to check client credentials")
+		return nil, false
+	}
+
+	application, err := s.db.GetApplicationByID(r.Context(), clientID)
+	if err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "invalid client credentials")
+		return nil, false
+	}
+	return application, true
+}
+
+// introspectToken returns the token when it is active, or nil when it is
+// invalid, expired, revoked or was rotated out.
+func (s *Server) introspectToken(r *http.Request, tokenString string) (*auth.TokenInfo, error) {
+	if auth.IsSessionRefreshToken(tokenString) {
+		return s.introspectSessionRefreshToken(r, tokenString)
+	}
+
+	token, err := auth.ParseToken(tokenString)
+	if err != nil {
+		return nil, nil
+	}
+
+	revoked, err := s.revocations.IsRevoked(r.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, nil
+	}
+
+	// Application refresh tokens are only active until they are rotated out
+	// or their credential is revoked, and have the scopes of the credential
+	if token.Type == auth.TokenTypeRefresh {
+		credential, err := s.db.GetCredential(r.Context(), token.ApplicationID, token.CredentialID)
+		if err != nil {
+			if errors.Is(err, database.ErrCredentialNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if !credentialActive(credential) || !refreshTokenInUse(credential, tokenString) {
+			return nil, nil
+		}
+		token.Scope = auth.FormatScope(credential.Scopes)
+	}
+
+	return token, nil
+}
+
+// introspectSessionRefreshToken looks up the session of an opaque session
+// refresh token. Only the current token of an active session is active.
+func (s *Server) introspectSessionRefreshToken(r *http.Request, tokenString string) (*auth.TokenInfo, error) {
+	sessionID, ok := auth.ParseSessionRefreshToken(tokenString)
+	if !ok {
+		return nil, nil
+	}
+
+	session, err := s.db.GetSessionByID(r.Context(), sessionID)
+	if err != nil {
+		if errors.Is(err, database.ErrSessionNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) ||
+		subtle.ConstantTimeCompare([]byte(session.RefreshTokenHash), []byte(auth.HashOpaqueToken(tokenString))) != 1 {
+		return nil, nil
+	}
+
+	return &auth.TokenInfo{
+		Type:          auth.TokenTypeRefresh,
+		ID:            session.ID,
+		Subject:       session.UserID,
+		ApplicationID: session.ApplicationID,
+		IssuedAt:      session.LastUsedAt,
+		ExpiresAt:     session.ExpiresAt,
+	}, nil
+}
+
+// credentialActive reports whether the credential is neither revoked nor
+// expired.
+func credentialActive(credential *models.ApplicationCredential) bool {
+	return credential.RevokedAt == nil && (credential.ExpiresAt == nil || time.Now().Before(*credential.ExpiresAt))
+}
+
+// refreshTokenInUse reports whether the token is the current refresh token of
+// the credential or the previous one within the grace period.
+func refreshTokenInUse(credential *models.ApplicationCredential, refreshToken string) bool {
+	refreshTokenHash := auth.HashOpaqueToken(refreshToken)
+	if credential.RefreshTokenHash != "" &&
+		subtle.ConstantTimeCompare([]byte(credential.RefreshTokenHash), []byte(refreshTokenHash)) == 1 {
+		return true
+	}
+	return credential.PreviousRefreshTokenHash != "" &&
+		subtle.ConstantTimeCompare([]byte(credential.PreviousRefreshTokenHash), []byte(refreshTokenHash)) == 1 &&
+		credential.PreviousRefreshTokenExpiresAt != nil && time.Now().Before(*credential.PreviousRefreshTokenExpiresAt)
+}
+
+// canIntrospect reports whether the application may learn about the token.
+func canIntrospect(application *models.Application, token *auth.TokenInfo) bool {
+	if token.Type == auth.TokenTypeAdmin {
+		return token.Subject == application.AdminID
+	}
+	return token.ApplicationID == application.ID
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/middleware"
+	"github.com/wbrijesh/identity/internal/models"
+)
+
+var mfaPolicies = []string{models.MFAPolicyOff, models.MFAPolicyOptional, models.MFAPolicyRequired}
+
+func validateMFAPolicy(policy string) error {
+	if !slices.Contains(mfaPolicies, policy) {
+		return fmt.Errorf("MFAPolicy must be one of %v", mfaPolicies)
+	}
+	return nil
+}
+
+// mfaRequirement reports whether a user who just entered their password
+// still has to pass a second factor, and whether they have to enroll one
+// first.
+func mfaRequirement(app *models.Application, user *models.ResponseUser) (challenge, enroll bool) {
+	switch app.MFAPolicy {
+	case models.MFAPolicyOff:
+		return false, false
+	case models.MFAPolicyRequired:
+		return true, !user.MFAEnabled
+	default:
+		return user.MFAEnabled, false
+	}
+}
+
+// writeMFAChallenge answers a login whose password was accepted with a
+// challenge for the second factor instead of tokens.
+func (s *Server) writeMFAChallenge(w http.ResponseWriter, r *http.Request, user *models.ResponseUser, enroll bool, nonce, userAgent, ipAddress string) {
+	token, err := s.db.CreateMFAChallenge(r.Context(), &models.MFAChallenge{
+		Enrollment:    enroll,
+		Nonce:         nonce,
+		UserAgent:     userAgent,
+		IPAddress:     ipAddress,
+		UserID:        user.ID,
+		ApplicationID: user.ApplicationID,
+	})
+	if err != nil {
+		http.Error(w, "Failed to create MFA challenge", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"mfa_required":            true,
+		"mfa_token":               token,
+		"mfa_enrollment_required": enroll,
+		"expires_in":              int(auth.MFAChallengeTTL.Seconds()),
+	})
+}
+
+// mfaChallenge returns the challenge of the mfa_token in a login request,
+// when the caller is the challenge's application.
+func (s *Server) mfaChallenge(w http.ResponseWriter, r *http.Request, token string) (*models.MFAChallenge, bool) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalApplication)
+	if !ok {
+		return nil, false
+	}
+
+	if token == "" {
+		http.Error(w, "mfa_token is required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	challenge, err := s.db.GetMFAChallenge(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, database.ErrMFAChallengeNotFound) ||
+			errors.Is(err, database.ErrMFAChallengeUsed) ||
+			errors.Is(err, database.ErrMFAChallengeExpired) {
+			http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !s.ownsApplication(r, principal, challenge.ApplicationID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return challenge, true
+}
+
+// LoginMFAEnrollHandler returns a new TOTP secret for a user who has to
+// enroll a second factor to complete the login.
+func (s *Server) LoginMFAEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	challenge, ok := s.mfaChallenge(w, r, body.MFAToken)
+	if !ok {
+		return
+	}
+	if !challenge.Enrollment {
+		http.Error(w, "MFA is already enrolled", http.StatusConflict)
+		return
+	}
+
+	s.enrollTOTP(w, r, challenge.UserID)
+}
+
+// LoginMFAHandler completes a login with the TOTP code for its challenge and
+// returns the same tokens as a login without MFA.
+func (s *Server) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	challenge, ok := s.mfaChallenge(w, r, body.MFAToken)
+	if !ok {
+		return
+	}
+
+	if err := s.db.VerifyUserTOTP(r.Context(), challenge.UserID, body.Code, challenge.Enrollment); err != nil {
+		writeTOTPError(w, err)
+		return
+	}
+	if err := s.db.ConsumeMFAChallenge(r.Context(), challenge.ID); err != nil {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.db.GetUserByID(r.Context(), challenge.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	s.writeUserLogin(w, r, user, challenge.UserAgent, challenge.IPAddress, challenge.Nonce)
+}
+
+// EnrollTOTPHandler starts TOTP enrollment for the signed in user. TOTP is
+// enabled once a code is confirmed.
+func (s *Server) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalUser)
+	if !ok {
+		return
+	}
+
+	app, err := s.db.GetApplicationByID(r.Context(), principal.ApplicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	}
+	if app.MFAPolicy == models.MFAPolicyOff {
+		http.Error(w, "MFA is turned off for this application", http.StatusForbidden)
+		return
+	}
+
+	s.enrollTOTP(w, r, principal.UserID)
+}
+
+func (s *Server) enrollTOTP(w http.ResponseWriter, r *http.Request, userID string) {
+	user, err := s.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	app, err := s.db.GetApplicationByID(r.Context(), user.ApplicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	}
+
+	secret, err := s.db.EnrollTOTP(r.Context(), user.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrTOTPAlreadyEnabled) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPProvisioningURI(app.Name, user.Email, secret),
+	})
+}
+
+// ConfirmTOTPHandler enables TOTP for the signed in user with a code from
+// the secret returned on enrollment.
+func (s *Server) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalUser)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.db.GetUserByID(r.Context(), principal.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.MFAEnabled {
+		http.Error(w, database.ErrTOTPAlreadyEnabled.Error(), http.StatusConflict)
+		return
+	}
+
+	if err := s.db.VerifyUserTOTP(r.Context(), user.ID, body.Code, true); err != nil {
+		writeTOTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DisableTOTPHandler turns TOTP off for the signed in user, who has to
+// present a current code. Users of applications that require MFA cannot
+// turn it off.
+func (s *Server) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalUser)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	app, err := s.db.GetApplicationByID(r.Context(), principal.ApplicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	}
+	if app.MFAPolicy == models.MFAPolicyRequired {
+		http.Error(w, "MFA is required for this application", http.StatusForbidden)
+		return
+	}
+
+	if err := s.db.VerifyUserTOTP(r.Context(), principal.UserID, body.Code, false); err != nil {
+		writeTOTPError(w, err)
+		return
+	}
+	if err := s.db.DisableTOTP(r.Context(), principal.UserID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetUserTOTPHandler removes the TOTP secret of a user who lost their
+// device. With MFA required the user enrolls again on the next login.
+func (s *Server) ResetUserTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authorizeApplicationUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.DisableTOTP(r.Context(), user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeTOTPError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrTOTPCodeInvalid), errors.Is(err, database.ErrRecoveryCodeInvalid):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, database.ErrTOTPLocked):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, database.ErrTOTPNotEnrolled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
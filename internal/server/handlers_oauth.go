@@ -0,0 +1,517 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/models"
+)
+
+// Authorization codes are short lived, the client redeems them immediately
+const authorizationCodeTTL = 5 * time.Minute
+
+var supportedUserScopes = []string{"openid", "email", "profile"}
+
+type authorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+func parseAuthorizeRequest(r *http.Request) authorizeRequest {
+	return authorizeRequest{
+		ResponseType:        r.Form.Get("response_type"),
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		Nonce:               r.Form.Get("nonce"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+	}
+}
+
+// validateAuthorizeRequest checks the request in two steps. Errors with the
+// client or redirect URI are shown to the user, since redirecting to an
+// unregistered URI would make the endpoint an open redirector. Any other error
+// is sent back to the client through the redirect URI.
+func (s *Server) validateAuthorizeRequest(w http.ResponseWriter, r *http.Request, req authorizeRequest) (*models.Application, bool) {
+	if req.ClientID == "" || req.RedirectURI == "" {
+		http.Error(w, "client_id and redirect_uri are required", http.StatusBadRequest)
+		return nil, false
+	}
+
+	app, err := s.db.GetApplicationByID(r.Context(), req.ClientID)
+	if err != nil {
+		http.Error(w, "Unknown client", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if !slices.Contains(app.RedirectURIs, req.RedirectURI) {
+		http.Error(w, "redirect_uri is not registered for this client", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if req.ResponseType != "code" {
+		redirectWithError(w, r, req, "unsupported_response_type", "only the code response type is supported")
+		return nil, false
+	}
+
+	if req.CodeChallengeMethod != auth.CodeChallengeMethodS256 || !auth.ValidCodeChallenge(req.CodeChallenge) {
+		redirectWithError(w, r, req, "invalid_request", "a S256 code_challenge is required")
+		return nil, false
+	}
+
+	for _, scope := range strings.Fields(req.Scope) {
+		if !slices.Contains(supportedUserScopes, scope) {
+			redirectWithError(w, r, req, "invalid_scope", "unsupported scope "+scope)
+			return nil, false
+		}
+	}
+
+	return app, true
+}
+
+func redirectWithError(w http.ResponseWriter, r *http.Request, req authorizeRequest, code, description string) {
+	params := url.Values{}
+	params.Set("error", code)
+	params.Set("error_description", description)
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	http.Redirect(w, r, appendQuery(req.RedirectURI, params), http.StatusFound)
+}
+
+func appendQuery(rawURL string, params url.Values) string {
+	separator := "?"
+	if strings.Contains(rawURL, "?") {
+		separator = "&"
+	}
+	return rawURL + separator + params.Encode()
+}
+
+func renderLogin(w http.ResponseWriter, status int, app *models.Application, req authorizeRequest, email, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	loginTemplate.Execute(w, map[string]interface{}{
+		"ApplicationName": app.Name,
+		"Request":         req,
+		"Email":           email,
+		"Error":           message,
+	})
+}
+
+// renderMFA asks for the TOTP code of a login. With a secret the user is
+// enrolling and is shown the secret to add to their authenticator app.
+func renderMFA(w http.ResponseWriter, status int, app *models.Application, req authorizeRequest, mfaToken, email, secret, message string) {
+	data := map[string]interface{}{
+		"ApplicationName": app.Name,
+		"Request":         req,
+		"MFAToken":        mfaToken,
+		"Error":           message,
+	}
+	if secret != "" {
+		data["Secret"] = secret
+		// otpauth is not a scheme html/template trusts, the URI is built
+		// from values under our control
+		data["ProvisioningURI"] = template.URL(auth.TOTPProvisioningURI(app.Name, email, secret))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	mfaTemplate.Execute(w, data)
+}
+
+func (s *Server) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	req := parseAuthorizeRequest(r)
+	app, ok := s.validateAuthorizeRequest(w, r, req)
+	if !ok {
+		return
+	}
+
+	renderLogin(w, http.StatusOK, app, req, "", "")
+}
+
+func (s *Server) AuthorizeLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	req := parseAuthorizeRequest(r)
+	app, ok := s.validateAuthorizeRequest(w, r, req)
+	if !ok {
+		return
+	}
+
+	if r.PostForm.Has("mfa_token") {
+		s.authorizeMFA(w, r, app, req)
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	password := r.PostForm.Get("password")
+	if email == "" || password == "" {
+		renderLogin(w, http.StatusBadRequest, app, req, email, "Email and password are required")
+		return
+	}
+
+	user, err := s.db.AuthenticateUser(r.Context(), app.ID, email, password)
+	if errors.Is(err, database.ErrEmailNotVerified) {
+		renderLogin(w, http.StatusForbidden, app, req, email, "Confirm your email address before signing in")
+		return
+	}
+	if err != nil {
+		renderLogin(w, http.StatusUnauthorized, app, req, email, "Invalid email or password")
+		return
+	}
+
+	if challenge, enroll := mfaRequirement(app, user); challenge {
+		token, err := s.db.CreateMFAChallenge(r.Context(), &models.MFAChallenge{
+			Enrollment:    enroll,
+			Nonce:         req.Nonce,
+			UserID:        user.ID,
+			ApplicationID: app.ID,
+		})
+		if err != nil {
+			http.Error(w, "Failed to create MFA challenge", http.StatusInternalServerError)
+			return
+		}
+
+		var secret string
+		if enroll {
+			secret, err = s.db.EnrollTOTP(r.Context(), user.ID)
+			if err != nil {
+				http.Error(w, "Failed to enroll TOTP", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		renderMFA(w, http.StatusOK, app, req, token, user.Email, secret, "")
+		return
+	}
+
+	s.redirectWithCode(w, r, app, req, user.ID)
+}
+
+// authorizeMFA completes a login that is waiting for the user's TOTP code.
+func (s *Server) authorizeMFA(w http.ResponseWriter, r *http.Request, app *models.Application, req authorizeRequest) {
+	token := r.PostForm.Get("mfa_token")
+	challenge, err := s.db.GetMFAChallenge(r.Context(), token)
+	if err != nil || challenge.ApplicationID != app.ID {
+		renderLogin(w, http.StatusUnauthorized, app, req, "", "Your sign in has expired, please sign in again")
+		return
+	}
+
+	if err := s.db.VerifyUserTOTP(r.Context(), challenge.UserID, r.PostForm.Get("code"), challenge.Enrollment); err != nil {
+		switch {
+		case errors.Is(err, database.ErrTOTPCodeInvalid):
+			renderMFA(w, http.StatusUnauthorized, app, req, token, "", "", "Invalid code")
+		case errors.Is(err, database.ErrTOTPLocked):
+			renderMFA(w, http.StatusTooManyRequests, app, req, token, "", "", "Too many invalid codes, try again later")
+		default:
+			renderLogin(w, http.StatusUnauthorized, app, req, "", "Your sign in has expired, please sign in again")
+		}
+		return
+	}
+
+	if err := s.db.ConsumeMFAChallenge(r.Context(), challenge.ID); err != nil {
+		renderLogin(w, http.StatusUnauthorized, app, req, "", "Your sign in has expired, please sign in again")
+		return
+	}
+
+	s.redirectWithCode(w, r, app, req, challenge.UserID)
+}
+
+// redirectWithCode issues an authorization code to a signed in user and
+// sends them back to the client.
+func (s *Server) redirectWithCode(w http.ResponseWriter, r *http.Request, app *models.Application, req authorizeRequest, userID string) {
+	code, codeHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		http.Error(w, "Failed to generate authorization code", http.StatusInternalServerError)
+		return
+	}
+
+	authorizationCode := &models.AuthorizationCode{
+		CodeHash:            codeHash,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		ExpiresAt:           time.Now().Add(authorizationCodeTTL),
+		ApplicationID:       app.ID,
+		UserID:              userID,
+	}
+	if err := s.db.CreateAuthorizationCode(r.Context(), authorizationCode); err != nil {
+		http.Error(w, "Failed to create authorization code", http.StatusInternalServerError)
+		return
+	}
+
+	params := url.Values{}
+	params.Set("code", code)
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	http.Redirect(w, r, appendQuery(req.RedirectURI, params), http.StatusFound)
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+func writeTokenResponse(w http.ResponseWriter, response map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) OAuthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid form body")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		s.authorizationCodeGrant(w, r)
+	case "client_credentials":
+		s.clientCredentialsGrant(w, r)
+	case "refresh_token":
+		s.refreshTokenGrant(w, r)
+	case "":
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "grant_type is required")
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "unsupported grant_type")
+	}
+}
+
+func (s *Server) authorizationCodeGrant(w http.ResponseWriter, r *http.Request) {
+	code := r.PostForm.Get("code")
+	clientID := r.PostForm.Get("client_id")
+	redirectURI := r.PostForm.Get("redirect_uri")
+	codeVerifier := r.PostForm.Get("code_verifier")
+	if code == "" || clientID == "" || redirectURI == "" || codeVerifier == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "code, client_id, redirect_uri and code_verifier are required")
+		return
+	}
+
+	authorizationCode, err := s.db.ConsumeAuthorizationCode(r.Context(), auth.HashOpaqueToken(code))
+	if err != nil {
+		if errors.Is(err, database.ErrAuthorizationCodeNotFound) ||
+			errors.Is(err, database.ErrAuthorizationCodeUsed) ||
+			errors.Is(err, database.ErrAuthorizationCodeExpired) {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+			return
+		}
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to redeem authorization code")
+		return
+	}
+
+	if authorizationCode.ApplicationID != clientID || authorizationCode.RedirectURI != redirectURI {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "authorization code was issued to another client or redirect_uri")
+		return
+	}
+
+	if !auth.VerifyCodeChallenge(codeVerifier, authorizationCode.CodeChallenge) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match the code_challenge")
+		return
+	}
+
+	user, err := s.db.GetUserByID(r.Context(), authorizationCode.UserID)
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "user no longer exists")
+		return
+	}
+
+	tokens, err := s.startSession(r, user, "", "")
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to start session")
+		return
+	}
+
+	response := map[string]interface{}{
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    tokens.ExpiresIn,
+		"scope":         authorizationCode.Scope,
+	}
+
+	if slices.Contains(strings.Fields(authorizationCode.Scope), "openid") {
+		idToken, err := auth.GenerateIDToken(user, authorizationCode.Nonce)
+		if err != nil {
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to generate ID token")
+			return
+		}
+		response["id_token"] = idToken
+	}
+
+	writeTokenResponse(w, response)
+}
+
+// clientCredentials reads the client ID and secret from HTTP Basic auth or,
+// failing that, from the form body (RFC 6749 section 2.3.1).
+func clientCredentials(r *http.Request) (string, string) {
+	if clientID, clientSecret, ok := r.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+		return clientID, clientSecret
+	}
+	return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+}
+
+// applicationAccessToken checks the refresh token against the one stored on
+// its credential and exchanges it for an access token. With rotate set the
+// refresh token is rotated and the new one returned alongside.
+func (s *Server) applicationAccessToken(w http.ResponseWriter, r *http.Request, refreshToken string, rotate bool) {
+	if s.refreshTokenRevoked(w, r, refreshToken) {
+		return
+	}
+
+	// Applications may ask for fewer scopes than their credential was granted
+	requested := auth.ParseScope(r.PostForm.Get("scope"))
+	credential, newRefreshToken, err := s.db.UseRefreshToken(r.Context(), refreshToken, rotate, requested)
+	if err != nil {
+		if errors.Is(err, database.ErrScopeNotGranted) {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+			return
+		}
+		if errors.Is(err, database.ErrRefreshTokenInvalid) ||
+			errors.Is(err, database.ErrRefreshTokenReused) ||
+			errors.Is(err, database.ErrCredentialRevoked) ||
+			errors.Is(err, database.ErrCredentialExpired) {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+			return
+		}
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to check refresh token")
+		return
+	}
+
+	scopes := credential.Scopes
+	if len(requested) > 0 {
+		scopes = requested
+	}
+
+	accessToken, err := auth.GenerateAccessToken(credential.ApplicationID, credential.ID, scopes)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to generate access token")
+		return
+	}
+
+	response := map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(auth.AccessTokenTTL.Seconds()),
+		"scope":        auth.FormatScope(scopes),
+	}
+	if rotate {
+		response["refresh_token"] = newRefreshToken
+	}
+
+	writeTokenResponse(w, response)
+}
+
+// refreshTokenRevoked writes an invalid_grant error and reports true when the
+// application refresh token was revoked by its jti or by its subject.
+func (s *Server) refreshTokenRevoked(w http.ResponseWriter, r *http.Request, refreshToken string) bool {
+	claims, err := auth.ValidateRefreshToken(refreshToken)
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "invalid refresh token")
+		return true
+	}
+	info, err := claims.Info()
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+		return true
+	}
+
+	revoked, err := s.revocations.IsRevoked(r.Context(), info)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to check token revocation")
+		return true
+	}
+	if revoked {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "refresh token has been revoked")
+		return true
+	}
+	return false
+}
+
+// clientCredentialsGrant authenticates an application with its ID and the
+// refresh token of one of its credentials as the client secret. The secret is
+// not rotated.
+func (s *Server) clientCredentialsGrant(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret := clientCredentials(r)
+	if clientID == "" || clientSecret == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="identity"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication is required")
+		return
+	}
+
+	claims, err := auth.ValidateRefreshToken(clientSecret)
+	if err != nil || claims.ApplicationID != clientID {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "invalid client credentials")
+		return
+	}
+
+	s.applicationAccessToken(w, r, clientSecret, false)
+}
+
+// refreshTokenGrant renews user sessions and application tokens. Both kinds of
+// refresh token are rotated on every use.
+func (s *Server) refreshTokenGrant(w http.ResponseWriter, r *http.Request) {
+	refreshToken := r.PostForm.Get("refresh_token")
+	if refreshToken == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "refresh_token is required")
+		return
+	}
+
+	if auth.IsSessionRefreshToken(refreshToken) {
+		s.sessionRefreshGrant(w, r, refreshToken)
+		return
+	}
+
+	claims, err := auth.ValidateRefreshToken(refreshToken)
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	}
+
+	// The client ID is optional, but has to match the token if sent
+	if clientID, _ := clientCredentials(r); clientID != "" && clientID != claims.ApplicationID {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "refresh token was issued to another client")
+		return
+	}
+
+	s.applicationAccessToken(w, r, refreshToken, true)
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/wbrijesh/identity/internal/middleware"
+)
+
+func (s *Server) UserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalUser)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	user, err := s.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	response := map[string]interface{}{
+		"sub":            user.ID,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"given_name":     user.FirstName,
+		"family_name":    user.LastName,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/mailer"
+	"github.com/wbrijesh/identity/internal/middleware"
+	"github.com/wbrijesh/identity/internal/models"
+	"github.com/wbrijesh/identity/utils"
+)
+
+// sendPasswordReset issues a password reset token and emails its code, and
+// a link with a reset_token parameter when there is a URL to point it to.
+func (s *Server) sendPasswordReset(ctx context.Context, oneTimeToken *models.OneTimeToken, to, accountName, resetURL string) error {
+	token, code, err := s.issueOneTimeToken(ctx, oneTimeToken, auth.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("Your code to reset your password for %s is %s.\n", accountName, code)
+	if resetURL != "" {
+		link, err := oneTimeTokenLink(resetURL, "reset_token", token)
+		if err != nil {
+			return err
+		}
+		text += fmt.Sprintf("\nOr choose a new password with this link:\n%s\n", link)
+	}
+	text += fmt.Sprintf("\nThe code expires in %d minutes. If you did not ask to reset your password, you can ignore this email.\n", int(auth.PasswordResetTTL.Minutes()))
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      to,
+		Subject: "Reset your password for " + accountName,
+		Body:    text,
+	})
+}
+
+// resetPassword uses a checked reset token to set the new password, and
+// revokes every token issued to the account so far and the sessions of a
+// user, all in one transaction. It writes the error response and returns
+// false when the token was used in the meantime or the reset failed.
+func (s *Server) resetPassword(w http.ResponseWriter, r *http.Request, token *models.OneTimeToken, password string) bool {
+	now := time.Now()
+	if _, err := s.db.ResetPassword(r.Context(), token.ID, password, now); err != nil {
+		writeOneTimeTokenError(w, err)
+		return false
+	}
+	s.revocations.SubjectRevoked(token.SubjectID, now)
+	return true
+}
+
+// ForgotUserPasswordHandler emails a user a password reset code, and a link
+// to the application's reset page when it passes one of its redirect URIs.
+func (s *Server) ForgotUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ApplicationID string `json:"application_id"`
+		Email         string `json:"email"`
+		RedirectURI   string `json:"redirect_uri"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Check if request is coming from the application
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalApplication)
+	if !ok {
+		return
+	}
+	if !s.ownsApplication(r, principal, body.ApplicationID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := utils.CheckNeceassaryFieldsExist(body, []string{"ApplicationID", "Email"}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app, err := s.db.GetApplicationByID(r.Context(), body.ApplicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	}
+	if body.RedirectURI != "" && !slices.Contains(app.RedirectURIs, body.RedirectURI) {
+		http.Error(w, "redirect_uri is not registered for this application", http.StatusBadRequest)
+		return
+	}
+
+	// Unknown emails get the same answer, so the endpoint does not tell
+	// which emails have accounts. The email is sent after the response so
+	// the time it takes does not tell either.
+	if user, err := s.db.GetUserByEmail(r.Context(), app.ID, body.Email); err == nil {
+		s.sendInBackground(r, "password reset email to user "+user.ID, func(ctx context.Context) error {
+			return s.sendPasswordReset(ctx, &models.OneTimeToken{
+				Purpose:       models.OneTimeTokenPasswordReset,
+				SubjectID:     user.ID,
+				ApplicationID: app.ID,
+			}, user.Email, app.Name, body.RedirectURI)
+		})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"expires_in": int64(auth.PasswordResetTTL.Seconds()),
+	})
+}
+
+// ResetUserPasswordHandler sets a new password with the token from a reset
+// link, or the email and code, and logs the user out everywhere.
+func (s *Server) ResetUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ApplicationID string `json:"application_id"`
+		Token         string `json:"token"`
+		Email         string `json:"email"`
+		Code          string `json:"code"`
+		Password      string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Check if request is coming from the application
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalApplication)
+	if !ok {
+		return
+	}
+	if !s.ownsApplication(r, principal, body.ApplicationID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := utils.CheckNeceassaryFieldsExist(body, []string{"ApplicationID", "Password"}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app, err := s.db.GetApplicationByID(r.Context(), body.ApplicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	}
+
+	// The password is only checked once the code is, so the history check
+	// can't be used to test guesses of the current password. The token is
+	// used afterwards, a refused password can be retried with the same code.
+	token, ok := s.checkEmailedToken(w, r, models.OneTimeTokenPasswordReset, app.ID, body.Token, body.Email, body.Code, s.userByEmail(r, app.ID))
+	if !ok {
+		return
+	}
+	if !s.checkPasswordPolicy(w, r, app.PasswordPolicy, token.SubjectID, body.Password) {
+		return
+	}
+	if !s.resetPassword(w, r, token, body.Password) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ForgotAdminPasswordHandler emails an admin a password reset code, and a
+// link to ADMIN_PASSWORD_RESET_URL when it is set.
+func (s *Server) ForgotAdminPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := utils.CheckNeceassaryFieldsExist(body, []string{"Email"}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Like for users, unknown emails get the same answer and the email is
+	// sent after the response
+	if admin, err := s.db.GetAdminByEmail(r.Context(), body.Email); err == nil {
+		s.sendInBackground(r, "password reset email to admin "+admin.ID, func(ctx context.Context) error {
+			return s.sendPasswordReset(ctx, &models.OneTimeToken{
+				Purpose:   models.OneTimeTokenAdminPasswordReset,
+				SubjectID: admin.ID,
+			}, admin.Email, "your admin account", s.adminPasswordResetURL)
+		})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"expires_in": int64(auth.PasswordResetTTL.Seconds()),
+	})
+}
+
+// ResetAdminPasswordHandler sets a new admin password with the token from a
+// reset link, or the email and code, and revokes every admin token issued
+// so far. TOTP is still asked for at the next login.
+func (s *Server) ResetAdminPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token    string `json:"token"`
+		Email    string `json:"email"`
+		Code     string `json:"code"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := utils.CheckNeceassaryFieldsExist(body, []string{"Password"}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	adminByEmail := func(email string) (string, error) {
+		admin, err := s.db.GetAdminByEmail(r.Context(), email)
+		if err != nil {
+			return "", err
+		}
+		return admin.ID, nil
+	}
+	// Like for users, the code is checked before the password
+	token, ok := s.checkEmailedToken(w, r, models.OneTimeTokenAdminPasswordReset, "", body.Token, body.Email, body.Code, adminByEmail)
+	if !ok {
+		return
+	}
+	if !s.checkPasswordPolicy(w, r, auth.AdminPasswordPolicy, token.SubjectID, body.Password) {
+		return
+	}
+	if !s.resetPassword(w, r, token, body.Password) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
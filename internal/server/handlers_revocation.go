@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/middleware"
+)
+
+// Kinds of subject a token can be issued to
+const (
+	subjectAdmin       = "admin"
+	subjectApplication = "application"
+	subjectUser        = "user"
+)
+
+// ownedSubject returns the kind of the subject when it is the calling admin,
+// one of the admin's applications or a user of one of those applications.
+func (s *Server) ownedSubject(r *http.Request, adminID, subject string) (string, bool) {
+	if subject == adminID {
+		return subjectAdmin, true
+	}
+	if application, err := s.db.GetApplicationByID(r.Context(), subject); err == nil {
+		return subjectApplication, application.AdminID == adminID
+	}
+	if user, err := s.db.GetUserByID(r.Context(), subject); err == nil {
+		application, err := s.db.GetApplicationByID(r.Context(), user.ApplicationID)
+		return subjectUser, err == nil && application.AdminID == adminID
+	}
+	return "", false
+}
+
+// RevokeTokenHandler revokes a single token of any type by its jti. The token
+// stays revoked until it expires.
+func (s *Server) RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalAdmin)
+	if !ok {
+		return
+	}
+	adminID := principal.AdminID
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, err := auth.ParseToken(req.Token)
+	if err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := s.ownedSubject(r, adminID, token.Subject); !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// The oldest application refresh tokens have no jti
+	if token.ID == "" {
+		http.Error(w, "Token has no jti, revoke its subject instead", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.revocations.RevokeToken(r.Context(), token, adminID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeSubjectHandler revokes every token issued to a subject before the
+// given time, which defaults to now. Revoking a user also revokes the user's
+// sessions created up to that time so they cannot mint new tokens.
+func (s *Server) RevokeSubjectHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalAdmin)
+	if !ok {
+		return
+	}
+	adminID := principal.AdminID
+
+	var req struct {
+		Subject string     `json:"subject"`
+		Before  *time.Time `json:"before"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Subject == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	before := now
+	if req.Before != nil {
+		if req.Before.After(now) {
+			http.Error(w, "before cannot be in the future", http.StatusBadRequest)
+			return
+		}
+		before = *req.Before
+	}
+
+	kind, ok := s.ownedSubject(r, adminID, req.Subject)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := s.revocations.RevokeSubject(r.Context(), req.Subject, before, adminID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if kind == subjectUser {
+		if err := s.db.RevokeUserSessions(r.Context(), req.Subject, before); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/middleware"
+	"github.com/wbrijesh/identity/internal/models"
+)
+
+type sessionTokens struct {
+	SessionID    string
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int
+}
+
+// startSession records a new session for a user who just authenticated and
+// issues its first access and refresh tokens.
+func (s *Server) startSession(r *http.Request, user *models.ResponseUser, userAgent, ipAddress string) (*sessionTokens, error) {
+	if userAgent == "" {
+		userAgent = r.UserAgent()
+	}
+	if ipAddress == "" {
+		ipAddress = clientIP(r)
+	}
+
+	policy, err := auth.ApplicationPolicy(user.ApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &models.Session{
+		UserAgent:     userAgent,
+		IPAddress:     ipAddress,
+		ExpiresAt:     time.Now().Add(policy.SessionTTL),
+		UserID:        user.ID,
+		ApplicationID: user.ApplicationID,
+	}
+	refreshToken, err := s.db.CreateSession(r.Context(), session)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := auth.GenerateUserJWT(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionTokens{
+		SessionID:    session.ID,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(policy.UserTokenTTL.Seconds()),
+	}, nil
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sessionRefreshGrant exchanges a session refresh token for a new access token
+// and a new refresh token. Refresh fails once the session is revoked.
+func (s *Server) sessionRefreshGrant(w http.ResponseWriter, r *http.Request, refreshToken string) {
+	clientID, _ := clientCredentials(r)
+	session, newRefreshToken, err := s.db.RefreshSession(r.Context(), refreshToken, clientID)
+	if err != nil {
+		if errors.Is(err, database.ErrSessionNotFound) ||
+			errors.Is(err, database.ErrSessionRevoked) ||
+			errors.Is(err, database.ErrSessionExpired) ||
+			errors.Is(err, database.ErrRefreshTokenReused) {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+			return
+		}
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to refresh session")
+		return
+	}
+
+	user, err := s.db.GetUserByID(r.Context(), session.UserID)
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "user no longer exists")
+		return
+	}
+
+	accessToken, err := auth.GenerateUserJWT(user, session.ID)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to generate token")
+		return
+	}
+	policy, err := auth.ApplicationPolicy(session.ApplicationID)
+	if err != nil {
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to load token policy")
+		return
+	}
+
+	writeTokenResponse(w, map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": newRefreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(policy.UserTokenTTL.Seconds()),
+	})
+}
+
+func (s *Server) ListMySessionsHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalUser)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	sessions, total, err := s.db.ListSessions(r.Context(), userID, offset, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": sessions,
+		"total":    total,
+	})
+}
+
+func (s *Server) RevokeMySessionHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := chi.URLParam(r, "sessionID")
+
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalUser)
+	if !ok {
+		return
+	}
+	userID := principal.UserID
+
+	session, err := s.db.GetSessionByID(r.Context(), sessionID)
+	if err != nil || session.UserID != userID {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.db.RevokeSession(r.Context(), session.ID); err != nil {
+		if errors.Is(err, database.ErrSessionNotFound) {
+			http.Error(w, "Session already revoked", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeApplicationUser checks that the caller is the application in the
+// URL or the admin who owns it, and that the user in the URL belongs to the
+// application.
+func (s *Server) authorizeApplicationUser(w http.ResponseWriter, r *http.Request) (*models.ResponseUser, bool) {
+	applicationID := chi.URLParam(r, "applicationID")
+	userID := chi.URLParam(r, "userID")
+
+	// Check if request is coming from the application or its owner
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalApplication, middleware.PrincipalAdmin)
+	if !ok {
+		return nil, false
+	}
+	if !s.ownsApplication(r, principal, applicationID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	user, err := s.db.GetUserByID(r.Context(), userID)
+	if err != nil || user.ApplicationID != applicationID {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (s *Server) ListUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authorizeApplicationUser(w, r)
+	if !ok {
+		return
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	sessions, total, err := s.db.ListSessions(r.Context(), user.ID, offset, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": sessions,
+		"total":    total,
+	})
+}
+
+func (s *Server) RevokeUserSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authorizeApplicationUser(w, r)
+	if !ok {
+		return
+	}
+
+	session, err := s.db.GetSessionByID(r.Context(), chi.URLParam(r, "sessionID"))
+	if err != nil || session.UserID != user.ID {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.db.RevokeSession(r.Context(), session.ID); err != nil {
+		if errors.Is(err, database.ErrSessionNotFound) {
+			http.Error(w, "Session already revoked", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) RevokeAllUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.authorizeApplicationUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.RevokeUserSessions(r.Context(), user.ID, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,41 +2,37 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/middleware"
 	"github.com/wbrijesh/identity/internal/models"
 	"github.com/wbrijesh/identity/utils"
 )
 
 func (s *Server) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	var body struct {
+		models.User
+
+		// Registered redirect URI the verification link points to
+		VerificationRedirectURI string `json:"verification_redirect_uri"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	user := body.User
 
-	// Check if request is coming from the application owner
-	adminID, ok := r.Context().Value("adminID").(string)
+	// Check if request is coming from the application
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalApplication)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusInternalServerError)
-		return
-	}
-	adminApps, _, err := s.db.ListApplications(r.Context(), 0, 200, adminID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	adminOwnedApp := false
-	for _, app := range adminApps {
-		if app.ID == user.ApplicationID {
-			adminOwnedApp = true
-			break
-		}
-	}
-	if !adminOwnedApp {
+	if !s.ownsApplication(r, principal, user.ApplicationID) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -46,21 +42,49 @@ func (s *Server) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app, err := s.db.GetApplicationByID(r.Context(), user.ApplicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	}
+
+	if !s.checkPasswordPolicy(w, r, app.PasswordPolicy, "", user.PasswordHash) {
+		return
+	}
+
+	// Emails are only verified by the user confirming them
+	user.EmailVerified = false
+	user.EmailVerifiedAt = nil
+
 	createdUser, err := s.db.CreateUser(r.Context(), &user)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	token, err := auth.GenerateUserJWT(createdUser)
+	s.sendSignupVerification(r, app, createdUser, body.VerificationRedirectURI)
+
+	// Users who cannot log in before verifying their email are not logged
+	// in by signing up either
+	if app.EmailVerification == models.EmailVerificationRequired {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"user":                        createdUser,
+			"email_verification_required": true,
+		})
+		return
+	}
+
+	tokens, err := s.startSession(r, createdUser, "", "")
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
 	response := map[string]interface{}{
-		"user":  createdUser,
-		"token": token,
+		"user":          createdUser,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -71,31 +95,24 @@ func (s *Server) LoginUserHandler(w http.ResponseWriter, r *http.Request) {
 		ApplicationID string `json:"application_id"`
 		Email         string `json:"email"`
 		Password      string `json:"password"`
+		Nonce         string `json:"nonce"`
+
+		// Forwarded by application backends that log users in on their
+		// behalf, so sessions show the end user's device
+		UserAgent string `json:"user_agent"`
+		IPAddress string `json:"ip_address"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Check if request is coming from the application owner
-	adminID, ok := r.Context().Value("adminID").(string)
+	// Check if request is coming from the application
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalApplication)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusInternalServerError)
-		return
-	}
-	adminApps, _, err := s.db.ListApplications(r.Context(), 0, 200, adminID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	adminOwnedApp := false
-	for _, app := range adminApps {
-		if app.ID == creds.ApplicationID {
-			adminOwnedApp = true
-			break
-		}
-	}
-	if !adminOwnedApp {
+	if !s.ownsApplication(r, principal, creds.ApplicationID) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -107,19 +124,48 @@ func (s *Server) LoginUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	user, err := s.db.AuthenticateUser(r.Context(), creds.ApplicationID, creds.Email, creds.Password)
 	if err != nil {
+		if errors.Is(err, database.ErrEmailNotVerified) {
+			http.Error(w, "Email address is not verified", http.StatusForbidden)
+			return
+		}
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	token, err := auth.GenerateUserJWT(user)
+	app, err := s.db.GetApplicationByID(r.Context(), creds.ApplicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	}
+	if challenge, enroll := mfaRequirement(app, user); challenge {
+		s.writeMFAChallenge(w, r, user, enroll, creds.Nonce, creds.UserAgent, creds.IPAddress)
+		return
+	}
+
+	s.writeUserLogin(w, r, user, creds.UserAgent, creds.IPAddress, creds.Nonce)
+}
+
+// writeUserLogin starts a session for a user who passed every login step and
+// writes the user token, refresh token and ID token.
+func (s *Server) writeUserLogin(w http.ResponseWriter, r *http.Request, user *models.ResponseUser, userAgent, ipAddress, nonce string) {
+	tokens, err := s.startSession(r, user, userAgent, ipAddress)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
+	idToken, err := auth.GenerateIDToken(user, nonce)
+	if err != nil {
+		http.Error(w, "Failed to generate ID token", http.StatusInternalServerError)
+		return
+	}
+
 	response := map[string]interface{}{
-		"user":  user,
-		"token": token,
+		"user":          user,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"id_token":      idToken,
 	}
 
 	json.NewEncoder(w).Encode(response)
@@ -130,25 +176,12 @@ func (s *Server) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
 	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
 	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
 
-	// Check if request is coming from the application owner
-	adminID, ok := r.Context().Value("adminID").(string)
+	// Check if request is coming from the application
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalApplication)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusInternalServerError)
 		return
 	}
-	adminApps, _, err := s.db.ListApplications(r.Context(), 0, 200, adminID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	adminOwnedApp := false
-	for _, app := range adminApps {
-		if app.ID == applicationID {
-			adminOwnedApp = true
-			break
-		}
-	}
-	if !adminOwnedApp {
+	if !s.ownsApplication(r, principal, applicationID) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/wbrijesh/identity/internal/middleware"
+	"github.com/wbrijesh/identity/internal/userimport"
+)
+
+// Largest import accepted in one request
+const maxImportBytes = 32 << 20
+
+// ImportUsersHandler creates users in bulk from a JSONL or CSV body, keeping
+// their existing password hashes. The format comes from the format query
+// parameter or else the content type, and dry_run=true checks every row
+// without creating any user.
+func (s *Server) ImportUsersHandler(w http.ResponseWriter, r *http.Request) {
+	applicationID := chi.URLParam(r, "applicationID")
+
+	// Check if request is coming from the application or its owner
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalApplication, middleware.PrincipalAdmin)
+	if !ok {
+		return
+	}
+	if !s.ownsApplication(r, principal, applicationID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	app, err := s.db.GetApplicationByID(r.Context(), applicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = userimport.FormatJSONL
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil && mediaType == "text/csv" {
+			format = userimport.FormatCSV
+		}
+	}
+	format, err = userimport.ParseFormat(format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid dry_run", http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := userimport.Import(r.Context(), s.db, http.MaxBytesReader(w, r.Body, maxImportBytes), userimport.Options{
+		ApplicationID:  applicationID,
+		Format:         format,
+		DryRun:         dryRun,
+		PasswordPolicy: app.PasswordPolicy,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
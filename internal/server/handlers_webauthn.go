@@ -0,0 +1,478 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/middleware"
+	"github.com/wbrijesh/identity/internal/models"
+	"github.com/wbrijesh/identity/utils"
+)
+
+// publicKeyCredential is the JSON encoding of a PublicKeyCredential, as
+// returned by its toJSON method. Binary values are base64url encoded.
+type publicKeyCredential struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+		AuthenticatorData string   `json:"authenticatorData"`
+		Signature         string   `json:"signature"`
+		UserHandle        string   `json:"userHandle"`
+	} `json:"response"`
+}
+
+type credentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// validateWebAuthnConfig checks that every origin is an https origin, or
+// http on localhost, whose host is the RP ID or one of its subdomains.
+func validateWebAuthnConfig(rpID string, origins []string) error {
+	if rpID == "" {
+		if len(origins) != 0 {
+			return errors.New("WebAuthnOrigins require a WebAuthnRPID")
+		}
+		return nil
+	}
+	if strings.ContainsAny(rpID, ":/") {
+		return errors.New("WebAuthnRPID must be a domain name without scheme or port")
+	}
+	if len(origins) == 0 {
+		return errors.New("WebAuthnOrigins must list at least one origin")
+	}
+
+	for _, origin := range origins {
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Host == "" || parsed.Path != "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+			return fmt.Errorf("WebAuthn origin %s must be a scheme and host only", origin)
+		}
+		host := parsed.Hostname()
+		if parsed.Scheme != "https" && !(parsed.Scheme == "http" && host == "localhost") {
+			return fmt.Errorf("WebAuthn origin %s must use https", origin)
+		}
+		if host != rpID && !strings.HasSuffix(host, "."+rpID) {
+			return fmt.Errorf("WebAuthn origin %s is not on %s", origin, rpID)
+		}
+	}
+	return nil
+}
+
+// passkeyApplication returns the application of a passkey ceremony when it
+// has passkeys configured.
+func (s *Server) passkeyApplication(w http.ResponseWriter, r *http.Request, applicationID string) (*models.Application, bool) {
+	app, err := s.db.GetApplicationByID(r.Context(), applicationID)
+	if err != nil {
+		http.Error(w, "Application not found", http.StatusNotFound)
+		return nil, false
+	}
+	if app.WebAuthnRPID == "" {
+		http.Error(w, "Passkeys are not configured for this application", http.StatusForbidden)
+		return nil, false
+	}
+	return app, true
+}
+
+// newWebAuthnChallenge stores a new challenge for a ceremony and returns it.
+func (s *Server) newWebAuthnChallenge(r *http.Request, ceremony, applicationID, userID string) (string, error) {
+	challenge, challengeHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	err = s.db.CreateWebAuthnChallenge(r.Context(), &models.WebAuthnChallenge{
+		ChallengeHash: challengeHash,
+		Ceremony:      ceremony,
+		UserID:        userID,
+		ApplicationID: applicationID,
+	})
+	if err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// consumeWebAuthnChallenge looks up the challenge echoed in the client data
+// of a ceremony response and marks it as used.
+func (s *Server) consumeWebAuthnChallenge(w http.ResponseWriter, r *http.Request, clientDataJSON []byte, ceremony, applicationID string) (*models.WebAuthnChallenge, bool) {
+	clientData, err := auth.ParseClientData(clientDataJSON)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	challenge, err := s.db.ConsumeWebAuthnChallenge(r.Context(), auth.HashOpaqueToken(clientData.Challenge), ceremony)
+	if err != nil {
+		if errors.Is(err, database.ErrWebAuthnChallengeNotFound) ||
+			errors.Is(err, database.ErrWebAuthnChallengeUsed) ||
+			errors.Is(err, database.ErrWebAuthnChallengeExpired) {
+			http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if challenge.ApplicationID != applicationID {
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return challenge, true
+}
+
+func credentialDescriptors(credentials []*models.WebAuthnCredential) []credentialDescriptor {
+	descriptors := make([]credentialDescriptor, len(credentials))
+	for i, credential := range credentials {
+		descriptors[i] = credentialDescriptor{
+			Type:       "public-key",
+			ID:         credential.CredentialID,
+			Transports: credential.Transports,
+		}
+	}
+	return descriptors
+}
+
+// BeginPasskeyRegistrationHandler returns the options to pass to
+// navigator.credentials.create for adding a passkey to the signed in user.
+func (s *Server) BeginPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalUser)
+	if !ok {
+		return
+	}
+
+	app, ok := s.passkeyApplication(w, r, principal.ApplicationID)
+	if !ok {
+		return
+	}
+	user, err := s.db.GetUserByID(r.Context(), principal.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	existing, err := s.db.ListWebAuthnCredentials(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	challenge, err := s.newWebAuthnChallenge(r, auth.WebAuthnCeremonyRegistration, app.ID, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to create challenge", http.StatusInternalServerError)
+		return
+	}
+
+	params := make([]map[string]interface{}, len(auth.COSEAlgorithms))
+	for i, algorithm := range auth.COSEAlgorithms {
+		params[i] = map[string]interface{}{"type": "public-key", "alg": algorithm}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"publicKey": map[string]interface{}{
+			"challenge": challenge,
+			"rp": map[string]interface{}{
+				"id":   app.WebAuthnRPID,
+				"name": app.Name,
+			},
+			"user": map[string]interface{}{
+				"id":          base64.RawURLEncoding.EncodeToString([]byte(user.ID)),
+				"name":        user.Email,
+				"displayName": strings.TrimSpace(user.FirstName + " " + user.LastName),
+			},
+			"pubKeyCredParams": params,
+			"timeout":          auth.WebAuthnChallengeTTL.Milliseconds(),
+			"attestation":      "none",
+			"authenticatorSelection": map[string]interface{}{
+				"residentKey":        "required",
+				"requireResidentKey": true,
+				"userVerification":   "required",
+			},
+			"excludeCredentials": credentialDescriptors(existing),
+		},
+	})
+}
+
+// FinishPasskeyRegistrationHandler verifies the response of
+// navigator.credentials.create and stores the new passkey.
+func (s *Server) FinishPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalUser)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Name       string              `json:"name"`
+		Credential publicKeyCredential `json:"credential"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	app, ok := s.passkeyApplication(w, r, principal.ApplicationID)
+	if !ok {
+		return
+	}
+
+	clientDataJSON, err := auth.DecodeWebAuthnBase64(body.Credential.Response.ClientDataJSON)
+	if err != nil {
+		http.Error(w, "Invalid clientDataJSON", http.StatusBadRequest)
+		return
+	}
+	attestationObject, err := auth.DecodeWebAuthnBase64(body.Credential.Response.AttestationObject)
+	if err != nil {
+		http.Error(w, "Invalid attestationObject", http.StatusBadRequest)
+		return
+	}
+
+	challenge, ok := s.consumeWebAuthnChallenge(w, r, clientDataJSON, auth.WebAuthnCeremonyRegistration, app.ID)
+	if !ok {
+		return
+	}
+	if challenge.UserID != principal.UserID {
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+
+	registration, err := auth.VerifyRegistration(app.WebAuthnRPID, app.WebAuthnOrigins, clientDataJSON, attestationObject)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	credential := &models.WebAuthnCredential{
+		Name:          body.Name,
+		CredentialID:  base64.RawURLEncoding.EncodeToString(registration.CredentialID),
+		PublicKey:     registration.PublicKey,
+		Algorithm:     registration.Algorithm,
+		SignCount:     int64(registration.SignCount),
+		Transports:    body.Credential.Response.Transports,
+		UserID:        principal.UserID,
+		ApplicationID: app.ID,
+	}
+	if err := s.db.CreateWebAuthnCredential(r.Context(), credential); err != nil {
+		if errors.Is(err, database.ErrWebAuthnCredentialExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(credential)
+}
+
+func (s *Server) ListMyPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalUser)
+	if !ok {
+		return
+	}
+
+	credentials, err := s.db.ListWebAuthnCredentials(r.Context(), principal.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"passkeys": credentials,
+	})
+}
+
+func (s *Server) DeleteMyPasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalUser)
+	if !ok {
+		return
+	}
+
+	if err := s.db.DeleteWebAuthnCredential(r.Context(), principal.UserID, chi.URLParam(r, "passkeyID")); err != nil {
+		if errors.Is(err, database.ErrWebAuthnCredentialNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BeginPasskeyLoginHandler returns the options to pass to
+// navigator.credentials.get. With an email only that user's passkeys are
+// allowed, without one the user picks any passkey for the application.
+func (s *Server) BeginPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ApplicationID string `json:"application_id"`
+		Email         string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Check if request is coming from the application
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalApplication)
+	if !ok {
+		return
+	}
+	if !s.ownsApplication(r, principal, body.ApplicationID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	app, ok := s.passkeyApplication(w, r, body.ApplicationID)
+	if !ok {
+		return
+	}
+
+	// An unknown email gets an empty list rather than an error, so the
+	// endpoint does not tell which emails have accounts
+	var userID string
+	allowCredentials := []credentialDescriptor{}
+	if body.Email != "" {
+		if user, err := s.db.GetUserByEmail(r.Context(), app.ID, body.Email); err == nil {
+			credentials, err := s.db.ListWebAuthnCredentials(r.Context(), user.ID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			userID = user.ID
+			allowCredentials = credentialDescriptors(credentials)
+		}
+	}
+
+	challenge, err := s.newWebAuthnChallenge(r, auth.WebAuthnCeremonyLogin, app.ID, userID)
+	if err != nil {
+		http.Error(w, "Failed to create challenge", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"publicKey": map[string]interface{}{
+			"challenge":        challenge,
+			"rpId":             app.WebAuthnRPID,
+			"timeout":          auth.WebAuthnChallengeTTL.Milliseconds(),
+			"userVerification": "required",
+			"allowCredentials": allowCredentials,
+		},
+	})
+}
+
+// FinishPasskeyLoginHandler verifies the response of
+// navigator.credentials.get and returns the same tokens as a password login.
+// A passkey with user verification is already two factors, so no TOTP code
+// is asked for. Users who still have to verify their email are refused, as
+// they are at a password login.
+func (s *Server) FinishPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ApplicationID string              `json:"application_id"`
+		Credential    publicKeyCredential `json:"credential"`
+		Nonce         string              `json:"nonce"`
+
+		// Forwarded by application backends that log users in on their
+		// behalf, so sessions show the end user's device
+		UserAgent string `json:"user_agent"`
+		IPAddress string `json:"ip_address"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Check if request is coming from the application
+	principal, ok := requirePrincipal(w, r, middleware.PrincipalApplication)
+	if !ok {
+		return
+	}
+	if !s.ownsApplication(r, principal, body.ApplicationID) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := utils.CheckNeceassaryFieldsExist(body.Credential, []string{"ID"}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	app, ok := s.passkeyApplication(w, r, body.ApplicationID)
+	if !ok {
+		return
+	}
+
+	clientDataJSON, err := auth.DecodeWebAuthnBase64(body.Credential.Response.ClientDataJSON)
+	if err != nil {
+		http.Error(w, "Invalid clientDataJSON", http.StatusBadRequest)
+		return
+	}
+	authenticatorData, err := auth.DecodeWebAuthnBase64(body.Credential.Response.AuthenticatorData)
+	if err != nil {
+		http.Error(w, "Invalid authenticatorData", http.StatusBadRequest)
+		return
+	}
+	signature, err := auth.DecodeWebAuthnBase64(body.Credential.Response.Signature)
+	if err != nil {
+		http.Error(w, "Invalid signature", http.StatusBadRequest)
+		return
+	}
+
+	challenge, ok := s.consumeWebAuthnChallenge(w, r, clientDataJSON, auth.WebAuthnCeremonyLogin, app.ID)
+	if !ok {
+		return
+	}
+
+	credential, err := s.db.GetWebAuthnCredential(r.Context(), app.ID, strings.TrimRight(body.Credential.ID, "="))
+	if err != nil {
+		http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+		return
+	}
+	if challenge.UserID != "" && challenge.UserID != credential.UserID {
+		http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+		return
+	}
+	if body.Credential.Response.UserHandle != "" {
+		userHandle, err := auth.DecodeWebAuthnBase64(body.Credential.Response.UserHandle)
+		if err != nil || string(userHandle) != credential.UserID {
+			http.Error(w, "Invalid passkey", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	signCount, err := auth.VerifyAssertion(app.WebAuthnRPID, app.WebAuthnOrigins, credential.PublicKey, clientDataJSON, authenticatorData, signature)
+	if err != nil {
+		http.Error(w, "Invalid passkey: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := s.db.UseWebAuthnCredential(r.Context(), credential.ID, signCount); err != nil {
+		if errors.Is(err, database.ErrWebAuthnSignCount) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.db.GetUserByID(r.Context(), credential.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+	// A passkey skips AuthenticateUser, so its email verification check is
+	// repeated here
+	if app.EmailVerification == models.EmailVerificationRequired && !user.EmailVerified {
+		http.Error(w, "Email address is not verified", http.StatusForbidden)
+		return
+	}
+
+	s.writeUserLogin(w, r, user, body.UserAgent, body.IPAddress, body.Nonce)
+}
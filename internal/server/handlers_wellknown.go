@@ -0,0 +1,53 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/wbrijesh/identity/internal/auth"
+)
+
+func (s *Server) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	jwks, err := auth.JWKS()
+	if err != nil {
+		http.Error(w, "Failed to load signing keys", http.StatusInternalServerError)
+		return
+	}
+
+	// Let resource servers cache the keys, rotation keeps old keys around
+	// long enough for caches to pick up new ones
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(jwks)
+}
+
+func (s *Server) OpenIDConfigurationHandler(w http.ResponseWriter, r *http.Request) {
+	algorithms, err := auth.SigningAlgorithms()
+	if err != nil {
+		http.Error(w, "Failed to load signing keys", http.StatusInternalServerError)
+		return
+	}
+
+	issuer := auth.Issuer()
+	response := map[string]interface{}{
+		"issuer":                                        issuer,
+		"authorization_endpoint":                        issuer + "/oauth/authorize",
+		"token_endpoint":                                issuer + "/oauth/token",
+		"jwks_uri":                                      issuer + "/.well-known/jwks.json",
+		"userinfo_endpoint":                             issuer + "/userinfo",
+		"introspection_endpoint":                        issuer + "/oauth/introspect",
+		"response_types_supported":                      []string{"code"},
+		"grant_types_supported":                         []string{"authorization_code", "client_credentials", "refresh_token"},
+		"code_challenge_methods_supported":              []string{auth.CodeChallengeMethodS256},
+		"token_endpoint_auth_methods_supported":         []string{"none", "client_secret_basic", "client_secret_post"},
+		"introspection_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"subject_types_supported":                       []string{"public"},
+		"id_token_signing_alg_values_supported":         algorithms,
+		"scopes_supported":                              supportedUserScopes,
+		"claims_supported":                              []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified", "given_name", "family_name"},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(response)
+}
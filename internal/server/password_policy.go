@@ -0,0 +1,40 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/models"
+)
+
+// checkPasswordPolicy checks a new password against a password policy and
+// the previous passwords of the account, when it already exists. It writes
+// every failed rule and returns false when the password is refused.
+func (s *Server) checkPasswordPolicy(w http.ResponseWriter, r *http.Request, policy *models.PasswordPolicy, subjectID, password string) bool {
+	violations := auth.CheckPassword(policy, password)
+
+	historyCount := auth.EffectivePasswordPolicy(policy).HistoryCount
+	if subjectID != "" && historyCount > 0 {
+		reused, err := s.db.PasswordInHistory(r.Context(), subjectID, password, historyCount)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		if reused {
+			violations = append(violations, auth.HistoryViolation(historyCount))
+		}
+	}
+
+	if len(violations) == 0 {
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      "password does not meet the policy",
+		"violations": violations,
+	})
+	return false
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/models"
+)
+
+// How long a token policy is cached. Policy changes made through another
+// instance take at most this long to be picked up.
+const policyCacheTTL = 30 * time.Second
+
+type cachedPolicy struct {
+	policy   *models.TokenPolicy
+	loadedAt time.Time
+}
+
+// policyCache resolves application token policies for the auth package
+// without hitting the database for every token.
+type policyCache struct {
+	db database.Service
+
+	mu       sync.Mutex
+	policies map[string]cachedPolicy
+}
+
+func newPolicyCache(db database.Service) *policyCache {
+	return &policyCache{
+		db:       db,
+		policies: make(map[string]cachedPolicy),
+	}
+}
+
+func (c *policyCache) resolve(applicationID string) (*models.TokenPolicy, error) {
+	c.mu.Lock()
+	cached, ok := c.policies[applicationID]
+	c.mu.Unlock()
+	if ok && time.Since(cached.loadedAt) < policyCacheTTL {
+		return cached.policy, nil
+	}
+
+	app, err := c.db.GetApplicationByID(context.Background(), applicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.policies[applicationID] = cachedPolicy{policy: app.TokenPolicy, loadedAt: time.Now()}
+	c.mu.Unlock()
+	return app.TokenPolicy, nil
+}
+
+// invalidate drops the cached policy of an application after it changed.
+func (c *policyCache) invalidate(applicationID string) {
+	c.mu.Lock()
+	delete(c.policies, applicationID)
+	c.mu.Unlock()
+}
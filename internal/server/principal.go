@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/wbrijesh/identity/internal/middleware"
+)
+
+// requirePrincipal returns the caller of the request when it is one of the
+// given kinds.
+func requirePrincipal(w http.ResponseWriter, r *http.Request, kinds ...middleware.PrincipalKind) (*middleware.Principal, bool) {
+	principal, ok := middleware.PrincipalFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	for _, kind := range kinds {
+		if principal.Kind == kind {
+			return principal, true
+		}
+	}
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return nil, false
+}
+
+// ownsApplication reports whether the principal may manage the users of an
+// application: admins own their applications and applications own
+// themselves.
+func (s *Server) ownsApplication(r *http.Request, principal *middleware.Principal, applicationID string) bool {
+	switch principal.Kind {
+	case middleware.PrincipalAdmin:
+		application, err := s.db.GetApplicationByID(r.Context(), applicationID)
+		return err == nil && application.AdminID == principal.AdminID
+	case middleware.PrincipalApplication:
+		return applicationID != "" && principal.ApplicationID == applicationID
+	default:
+		return false
+	}
+}
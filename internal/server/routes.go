@@ -6,6 +6,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	chiMiddleware "github.com/go-chi/chi/v5/middleware"
 
+	"github.com/wbrijesh/identity/internal/auth"
 	"github.com/wbrijesh/identity/internal/middleware"
 )
 
@@ -15,28 +16,117 @@ func (s *Server) RegisterRoutes() http.Handler {
 
 	r.Get("/", s.HelloWorldHandler)
 	r.Get("/health", s.healthHandler)
+	r.Get("/.well-known/jwks.json", s.JWKSHandler)
+	r.Get("/.well-known/openid-configuration", s.OpenIDConfigurationHandler)
+
+	// OAuth routes
+	r.Get("/oauth/authorize", s.AuthorizeHandler)
+	r.Post("/oauth/authorize", s.AuthorizeLoginHandler)
+	r.Post("/oauth/token", s.OAuthTokenHandler)
+	r.Post("/oauth/introspect", s.IntrospectHandler)
 
 	// Admin routes
 	r.Post("/admin/register", s.CreateAdminHandler)
 	r.Post("/admin/login", s.LoginAdminHandler)
+	r.Post("/admin/login/mfa", s.LoginAdminMFAHandler)
+	r.Post("/admin/password/forgot", s.ForgotAdminPasswordHandler)
+	r.Post("/admin/password/reset", s.ResetAdminPasswordHandler)
+
+	// Admin MFA routes (protected by Admin auth middleware)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AdminAuthMiddleware(s.revocations))
 
-	// Application routes (protected by Admin auth middleware)
+		r.Post("/admin/mfa/totp", s.EnrollAdminTOTPHandler)
+		r.Post("/admin/mfa/totp/confirm", s.ConfirmAdminTOTPHandler)
+		r.Delete("/admin/mfa/totp", s.DisableAdminTOTPHandler)
+		r.Post("/admin/mfa/recovery-codes", s.RegenerateRecoveryCodesHandler)
+	})
+
+	// Admin account routes (protected by Admin auth middleware, and MFA when
+	// required)
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.AdminAuthMiddleware)
+		r.Use(middleware.AdminAuthMiddleware(s.revocations))
+		r.Use(middleware.RequireAdminMFA(s.adminMFARequired))
+
+		r.Post("/admin/password", s.ChangeAdminPasswordHandler)
+		r.Post("/admin/email", s.ChangeAdminEmailHandler)
+		r.Post("/admin/email/confirm", s.ConfirmAdminEmailHandler)
+	})
+
+	// Application routes (protected by Admin auth middleware, and MFA when
+	// required)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AdminAuthMiddleware(s.revocations))
+		r.Use(middleware.RequireAdminMFA(s.adminMFARequired))
 
 		r.Post("/applications", s.CreateApplicationHandler)
 		r.Get("/applications", s.ListApplicationsHandler)
-		r.Post("/applications/{applicationID}/refresh-token", s.GenerateRefreshTokenForApplicationHandler)
-		r.Put("/applications/{applicationID}/refresh-token", s.UpdateRefreshTokenForApplicationHandler)
+		r.Put("/applications/{applicationID}", s.UpdateApplicationHandler)
+		r.Post("/applications/{applicationID}/credentials", s.CreateCredentialHandler)
+		r.Get("/applications/{applicationID}/credentials", s.ListCredentialsHandler)
+		r.Get("/applications/{applicationID}/credentials/{credentialID}", s.GetCredentialHandler)
+		r.Put("/applications/{applicationID}/credentials/{credentialID}", s.UpdateCredentialHandler)
+		r.Delete("/applications/{applicationID}/credentials/{credentialID}", s.RevokeCredentialHandler)
+		r.Post("/applications/{applicationID}/credentials/{credentialID}/rotate", s.RotateCredentialHandler)
+		r.Post("/revocations/tokens", s.RevokeTokenHandler)
+		r.Post("/revocations/subjects", s.RevokeSubjectHandler)
 	})
 
 	// User routes (protected by Access Token auth middleware)
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.AcessTokenAuthMiddleware)
+		r.Use(middleware.AcessTokenAuthMiddleware(s.revocations))
+
+		r.With(middleware.RequireScope(auth.ScopeUsersWrite)).Post("/users", s.CreateUserHandler)
+		r.With(middleware.RequireScope(auth.ScopeUsersWrite)).Post("/users/verify-email/resend", s.ResendEmailVerificationHandler)
+		r.With(middleware.RequireScope(auth.ScopeUsersWrite)).Post("/users/verify-email/confirm", s.ConfirmEmailVerificationHandler)
+		r.With(middleware.RequireScope(auth.ScopeUsersLogin)).Post("/users/login", s.LoginUserHandler)
+		r.With(middleware.RequireScope(auth.ScopeUsersLogin)).Post("/users/login/mfa", s.LoginMFAHandler)
+		r.With(middleware.RequireScope(auth.ScopeUsersLogin)).Post("/users/login/mfa/enroll", s.LoginMFAEnrollHandler)
+		r.With(middleware.RequireScope(auth.ScopeUsersLogin)).Post("/users/login/webauthn/begin", s.BeginPasskeyLoginHandler)
+		r.With(middleware.RequireScope(auth.ScopeUsersLogin)).Post("/users/login/webauthn/finish", s.FinishPasskeyLoginHandler)
+		r.With(middleware.RequireScope(auth.ScopeUsersLogin)).Post("/users/login/email", s.RequestEmailLoginHandler)
+		r.With(middleware.RequireScope(auth.ScopeUsersLogin)).Post("/users/login/email/verify", s.VerifyEmailLoginHandler)
+		r.With(middleware.RequireScope(auth.ScopeUsersLogin)).Post("/users/password/forgot", s.ForgotUserPasswordHandler)
+		r.With(middleware.RequireScope(auth.ScopeUsersLogin)).Post("/users/password/reset", s.ResetUserPasswordHandler)
+		r.With(middleware.RequireScope(auth.ScopeUsersRead)).Get("/applications/{applicationID}/users", s.ListUsersHandler)
+	})
+
+	// User session, MFA and import routes (protected by Admin or Access Token auth middleware)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AdminOrAccessTokenAuthMiddleware(s.revocations))
+		r.Use(middleware.RequireAdminMFA(s.adminMFARequired))
+
+		r.With(middleware.RequireScope(auth.ScopeSessionsRead)).Get("/applications/{applicationID}/users/{userID}/sessions", s.ListUserSessionsHandler)
+		r.With(middleware.RequireScope(auth.ScopeSessionsRevoke)).Delete("/applications/{applicationID}/users/{userID}/sessions", s.RevokeAllUserSessionsHandler)
+		r.With(middleware.RequireScope(auth.ScopeSessionsRevoke)).Delete("/applications/{applicationID}/users/{userID}/sessions/{sessionID}", s.RevokeUserSessionHandler)
+		r.With(middleware.RequireScope(auth.ScopeUsersWrite)).Delete("/applications/{applicationID}/users/{userID}/mfa/totp", s.ResetUserTOTPHandler)
+		r.With(middleware.RequireScope(auth.ScopeUsersWrite)).Post("/applications/{applicationID}/users/import", s.ImportUsersHandler)
+	})
+
+	// OpenID Connect routes (protected by User auth middleware)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.UserAuthMiddleware(s.revocations))
+
+		r.Get("/userinfo", s.UserInfoHandler)
+		r.Post("/userinfo", s.UserInfoHandler)
+	})
+
+	// Session and MFA routes for the signed in user (protected by User auth middleware)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.UserAuthMiddleware(s.revocations))
 
-		r.Post("/users", s.CreateUserHandler)
-		r.Post("/users/login", s.LoginUserHandler)
-		r.Get("/applications/{applicationID}/users", s.ListUsersHandler)
+		r.Get("/users/me/sessions", s.ListMySessionsHandler)
+		r.Delete("/users/me/sessions/{sessionID}", s.RevokeMySessionHandler)
+		r.Post("/users/me/mfa/totp", s.EnrollTOTPHandler)
+		r.Post("/users/me/mfa/totp/confirm", s.ConfirmTOTPHandler)
+		r.Delete("/users/me/mfa/totp", s.DisableTOTPHandler)
+		r.Post("/users/me/password", s.ChangeMyPasswordHandler)
+		r.Post("/users/me/email", s.ChangeMyEmailHandler)
+		r.Post("/users/me/email/confirm", s.ConfirmMyEmailHandler)
+		r.Post("/users/me/webauthn/register/begin", s.BeginPasskeyRegistrationHandler)
+		r.Post("/users/me/webauthn/register/finish", s.FinishPasskeyRegistrationHandler)
+		r.Get("/users/me/webauthn/credentials", s.ListMyPasskeysHandler)
+		r.Delete("/users/me/webauthn/credentials/{passkeyID}", s.DeleteMyPasskeyHandler)
 	})
 
 	return r
@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -9,22 +10,48 @@ import (
 
 	_ "github.com/joho/godotenv/autoload"
 
+	"github.com/wbrijesh/identity/internal/auth"
 	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/mailer"
+	"github.com/wbrijesh/identity/internal/revocation"
 )
 
 type Server struct {
 	port int
 
-	db database.Service
+	db          database.Service
+	revocations *revocation.Store
+	policies    *policyCache
+	mailer      mailer.Mailer
+
+	// Whether admins need a token issued with MFA to manage applications
+	adminMFARequired bool
+
+	// Page admin password reset links point to, without it only codes are
+	// sent
+	adminPasswordResetURL string
 }
 
 func NewServer() *http.Server {
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
+	adminMFARequired, _ := strconv.ParseBool(os.Getenv("ADMIN_MFA_REQUIRED"))
+	db := database.New()
+	mail, err := mailer.New()
+	if err != nil {
+		log.Fatal(err)
+	}
 	NewServer := &Server{
 		port: port,
 
-		db: database.New(),
+		db:          db,
+		revocations: revocation.NewStore(db),
+		policies:    newPolicyCache(db),
+		mailer:      mail,
+
+		adminMFARequired:      adminMFARequired,
+		adminPasswordResetURL: os.Getenv("ADMIN_PASSWORD_RESET_URL"),
 	}
+	auth.SetPolicyResolver(NewServer.policies.resolve)
 
 	// Declare Server config
 	server := &http.Server{
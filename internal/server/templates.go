@@ -0,0 +1,61 @@
+package server
+
+import "html/template"
+
+// Hidden fields that carry the authorization request through each step of
+// the login, executed with the authorizeRequest as its data
+const authorizeRequestFields = `{{define "request"}}
+		<input type="hidden" name="response_type" value="{{.ResponseType}}">
+		<input type="hidden" name="client_id" value="{{.ClientID}}">
+		<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+		<input type="hidden" name="scope" value="{{.Scope}}">
+		<input type="hidden" name="state" value="{{.State}}">
+		<input type="hidden" name="nonce" value="{{.Nonce}}">
+		<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+		<input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+{{- end}}`
+
+var loginTemplate = template.Must(template.New("login").Parse(authorizeRequestFields + `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Sign in to {{.ApplicationName}}</title>
+</head>
+<body>
+	<h1>Sign in to {{.ApplicationName}}</h1>
+	{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+	<form method="post" action="/oauth/authorize">
+		{{- template "request" .Request}}
+		<label>Email <input type="email" name="email" value="{{.Email}}" required autofocus></label>
+		<label>Password <input type="password" name="password" required></label>
+		<button type="submit">Sign in</button>
+	</form>
+</body>
+</html>
+`))
+
+var mfaTemplate = template.Must(template.New("mfa").Parse(authorizeRequestFields + `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Sign in to {{.ApplicationName}}</title>
+</head>
+<body>
+	<h1>Sign in to {{.ApplicationName}}</h1>
+	{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+	{{if .Secret}}
+	<p>{{.ApplicationName}} requires two-step verification. Add this account to
+	your authenticator app with the setup key <code>{{.Secret}}</code> or
+	<a href="{{.ProvisioningURI}}">open it in your authenticator app</a>.</p>
+	{{end}}
+	<form method="post" action="/oauth/authorize">
+		{{- template "request" .Request}}
+		<input type="hidden" name="mfa_token" value="{{.MFAToken}}">
+		<label>Code from your authenticator app <input type="text" name="code" inputmode="numeric" autocomplete="one-time-code" pattern="[0-9]{6}" required autofocus></label>
+		<button type="submit">Verify</button>
+	</form>
+</body>
+</html>
+`))
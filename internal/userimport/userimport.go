@@ -0,0 +1,306 @@
+// Package userimport creates users in bulk from JSONL or CSV exports of
+// other systems, keeping their existing password hashes.
+package userimport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wbrijesh/identity/internal/auth"
+	"github.com/wbrijesh/identity/internal/database"
+	"github.com/wbrijesh/identity/internal/models"
+)
+
+// Supported input formats
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// Longest JSONL line accepted
+const maxLineBytes = 1 << 20
+
+// Options of an import. PasswordPolicy is the application's, checked
+// against plaintext passwords only.
+type Options struct {
+	ApplicationID  string
+	Format         string
+	DryRun         bool
+	PasswordPolicy *models.PasswordPolicy
+}
+
+// Record is one user to import. Exactly one of Password, a plaintext
+// password, and PasswordHash, an existing hash in a supported format, is set.
+type Record struct {
+	Email         string `json:"Email"`
+	FirstName     string `json:"FirstName"`
+	LastName      string `json:"LastName"`
+	Password      string `json:"Password"`
+	PasswordHash  string `json:"PasswordHash"`
+	EmailVerified bool   `json:"EmailVerified"`
+}
+
+// RowError reports why a row was not imported. Line is the line of the row
+// in the input.
+type RowError struct {
+	Line  int    `json:"line"`
+	Email string `json:"email,omitempty"`
+	Error string `json:"error"`
+}
+
+// Report sums up an import. Rows are imported independently, so a failed
+// row does not stop the others. Error is set when the input could not be
+// read to the end, the rows before it are still counted.
+type Report struct {
+	DryRun   bool       `json:"dry_run"`
+	Total    int        `json:"total"`
+	Imported int        `json:"imported"`
+	Failed   int        `json:"failed"`
+	Errors   []RowError `json:"errors"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// ParseFormat returns the input format for a name, accepting ndjson for
+// jsonl.
+func ParseFormat(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case FormatJSONL, "ndjson":
+		return FormatJSONL, nil
+	case FormatCSV:
+		return FormatCSV, nil
+	default:
+		return "", fmt.Errorf("unsupported import format %q, use jsonl or csv", name)
+	}
+}
+
+// Import creates the users read from r in the application. The returned
+// error is only set when the input can't be read at all, like a CSV header
+// naming an unknown column.
+func Import(ctx context.Context, db database.Service, r io.Reader, opts Options) (*Report, error) {
+	var next func() (int, *Record, error)
+	switch opts.Format {
+	case FormatJSONL:
+		next = jsonlReader(r)
+	case FormatCSV:
+		var err error
+		if next, err = csvReader(r); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported import format %q, use jsonl or csv", opts.Format)
+	}
+
+	report := &Report{DryRun: opts.DryRun, Errors: []RowError{}}
+	seen := make(map[string]int)
+	for {
+		line, record, err := next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *rowError
+		if errors.As(err, &rowErr) {
+			report.fail(line, "", err)
+			continue
+		}
+		if err != nil {
+			report.Error = err.Error()
+			break
+		}
+
+		if firstLine, ok := seen[record.Email]; ok {
+			report.fail(line, record.Email, fmt.Errorf("duplicate of the user on line %d", firstLine))
+			continue
+		}
+		if record.Email != "" {
+			seen[record.Email] = line
+		}
+
+		if err := importRecord(ctx, db, record, opts); err != nil {
+			report.fail(line, record.Email, err)
+			continue
+		}
+		report.Total++
+		report.Imported++
+	}
+
+	return report, nil
+}
+
+func (r *Report) fail(line int, email string, err error) {
+	r.Total++
+	r.Failed++
+	r.Errors = append(r.Errors, RowError{Line: line, Email: email, Error: err.Error()})
+}
+
+// importRecord checks a record and creates its user.
+func importRecord(ctx context.Context, db database.Service, record *Record, opts Options) error {
+	if record.Email == "" {
+		return errors.New("Email is required")
+	}
+
+	passwordHash := record.PasswordHash
+	switch {
+	case record.Password != "" && record.PasswordHash != "":
+		return errors.New("only one of Password and PasswordHash can be set")
+	case record.Password != "":
+		if violations := auth.CheckPassword(opts.PasswordPolicy, record.Password); len(violations) > 0 {
+			return &auth.PasswordPolicyError{Violations: violations}
+		}
+		hash, err := auth.HashPassword(record.Password)
+		if err != nil {
+			return err
+		}
+		passwordHash = hash
+	case record.PasswordHash == "":
+		return errors.New("Password or PasswordHash is required")
+	}
+
+	user := &models.User{
+		Email:         record.Email,
+		PasswordHash:  passwordHash,
+		FirstName:     record.FirstName,
+		LastName:      record.LastName,
+		EmailVerified: record.EmailVerified,
+		ApplicationID: opts.ApplicationID,
+	}
+	if record.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	_, err := db.ImportUser(ctx, user, opts.DryRun)
+	return err
+}
+
+// rowError is a row that could not be parsed, reading continues with the
+// next one.
+type rowError struct {
+	err error
+}
+
+func (e *rowError) Error() string {
+	return e.err.Error()
+}
+
+// jsonlReader reads one JSON object per line, skipping blank lines.
+func jsonlReader(r io.Reader) func() (int, *Record, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	line := 0
+
+	return func() (int, *Record, error) {
+		for scanner.Scan() {
+			line++
+			text := bytes.TrimSpace(scanner.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+
+			var record Record
+			decoder := json.NewDecoder(bytes.NewReader(text))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&record); err != nil {
+				return line, nil, &rowError{fmt.Errorf("invalid JSON: %w", err)}
+			}
+			if decoder.More() {
+				return line, nil, &rowError{errors.New("invalid JSON: more than one value on the line")}
+			}
+			record.Email = strings.TrimSpace(record.Email)
+			return line, &record, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return line + 1, nil, fmt.Errorf("failed to read line %d: %w", line+1, err)
+		}
+		return line, nil, io.EOF
+	}
+}
+
+// csvReader reads CSV with a header row naming the Record fields the
+// columns hold, in any case and order.
+func csvReader(r io.Reader) (func() (int, *Record, error), error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.New("missing CSV header")
+		}
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+
+	columns := make([]string, len(header))
+	for i, name := range header {
+		column, ok := csvColumn(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+		for _, previous := range columns[:i] {
+			if previous == column {
+				return nil, fmt.Errorf("duplicate CSV column %q", name)
+			}
+		}
+		columns[i] = column
+	}
+
+	return func() (int, *Record, error) {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			// A wrong number of fields leaves the reader on the next row,
+			// other errors leave it in the middle of the broken one
+			if errors.Is(err, csv.ErrFieldCount) {
+				return parseErr.StartLine, nil, &rowError{err}
+			}
+			return parseErr.StartLine, nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		var record Record
+		for i, value := range row {
+			switch columns[i] {
+			case "Email":
+				record.Email = strings.TrimSpace(value)
+			case "FirstName":
+				record.FirstName = value
+			case "LastName":
+				record.LastName = value
+			case "Password":
+				record.Password = value
+			case "PasswordHash":
+				record.PasswordHash = value
+			case "EmailVerified":
+				if value == "" {
+					continue
+				}
+				if record.EmailVerified, err = strconv.ParseBool(value); err != nil {
+					return line, nil, &rowError{fmt.Errorf("invalid EmailVerified %q", value)}
+				}
+			}
+		}
+		return line, &record, nil
+	}, nil
+}
+
+// csvColumn returns the Record field a CSV column names.
+func csvColumn(name string) (string, bool) {
+	for _, column := range []string{"Email", "FirstName", "LastName", "Password", "PasswordHash", "EmailVerified"} {
+		if strings.EqualFold(name, column) {
+			return column, true
+		}
+	}
+	return "", false
+}